@@ -3,6 +3,8 @@ package main
 import (
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -12,7 +14,9 @@ import (
 	"pbgui-backend/internal/api/handlers"
 	"pbgui-backend/internal/api/routes"
 	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/jobs"
 	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/secrets"
 	"pbgui-backend/pkg/config"
 )
 
@@ -27,16 +31,56 @@ func main() {
 	}
 
 	// Auto-migrate models
-	db.AutoMigrate(&models.Instance{}, &models.Job{}, &models.VPSServer{})
+	db.AutoMigrate(&models.Instance{}, &models.Job{}, &models.VPSServer{}, &models.AuditLog{}, &models.ConfigRevision{}, &models.ConfigTemplate{}, &models.InstanceSymbol{}, &models.ExchangeAccount{}, &models.BacktestFill{})
 
 	// Initialize services
-	pbRunner := passivbot.NewRunner(cfg.PassivbotPath, cfg.PythonPath)
+	logStore := passivbot.NewLogStore(cfg.LogsDir, passivbot.RotationPolicy{
+		MaxSize:       int64(cfg.LogMaxSizeMB) * 1024 * 1024,
+		MaxSegmentAge: time.Duration(cfg.LogRotateHours) * time.Hour,
+		MaxBackups:    cfg.LogMaxBackups,
+		MaxAge:        time.Duration(cfg.LogMaxAgeDays) * 24 * time.Hour,
+	})
+	pbRunner := passivbot.NewRunner(cfg.PassivbotPath, cfg.PythonPath, filepath.Join(cfg.DataDir, "run"), logStore)
+	pbRunner.SetStopGracePeriod(time.Duration(cfg.StopGraceSeconds) * time.Second)
+	pbRunner.SetBulkWorkers(cfg.BulkWorkers)
+	pbRunner.SetCgroupRoot(cfg.CgroupRoot)
+	pbRunner.SetWorkspaceDir(cfg.WorkspaceDir)
 	
+	// Exchange credentials are encrypted with the master key; without one
+	// accounts cannot be created or used
+	var secretBox *secrets.Box
+	if cfg.MasterKey != "" {
+		secretBox, err = secrets.NewBox(cfg.MasterKey)
+		if err != nil {
+			log.Fatal("Invalid MASTER_KEY:", err)
+		}
+	} else {
+		log.Printf("MASTER_KEY is not set; exchange accounts are disabled")
+	}
+
 	// Initialize handlers
 	handlers := &handlers.Handlers{
 		DB:       db,
 		PBRunner: pbRunner,
 		Config:   cfg,
+		Secrets:  secretBox,
+		Jobs:     jobs.NewQueue(db),
+	}
+	pbRunner.OnStateChange(handlers.RecordInstanceState)
+
+	// Resume jobs queued or interrupted before a restart
+	handlers.RegisterJobHandlers()
+	if err := handlers.Jobs.Start(); err != nil {
+		log.Printf("Failed to recover jobs: %v", err)
+	}
+
+	// Re-adopt or clean up instances left over from a previous run before
+	// serving requests, then keep stored statuses honest in the background
+	if _, err := handlers.ReconcileInstances(); err != nil {
+		log.Printf("Startup reconcile failed: %v", err)
+	}
+	if cfg.ReconcileIntervalSeconds > 0 {
+		go handlers.RunReconciler(time.Duration(cfg.ReconcileIntervalSeconds) * time.Second)
 	}
 
 	// Setup Gin router
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/secrets"
+)
+
+var errAccountNotFound = errors.New("exchange account not found")
+
+// Exchange Account Handlers
+
+func (h *Handlers) GetAccounts(c *gin.Context) {
+	var accounts []models.ExchangeAccount
+	if err := h.DB.Order("label").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (h *Handlers) GetAccount(c *gin.Context) {
+	var account models.ExchangeAccount
+	if err := h.DB.First(&account, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errAccountNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+// accountRequest creates or changes an account. On update, fields left out
+// keep their value; credentials are write-only.
+type accountRequest struct {
+	Label      *string `json:"label"`
+	Exchange   *string `json:"exchange"`
+	Subaccount *string `json:"subaccount"`
+	Key        *string `json:"key"`
+	Secret     *string `json:"secret"`
+	Passphrase *string `json:"passphrase"`
+}
+
+func (h *Handlers) CreateAccount(c *gin.Context) {
+	if h.Secrets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": secrets.ErrNotConfigured.Error()})
+		return
+	}
+	var req accountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == nil || req.Secret == nil || *req.Key == "" || *req.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key and secret are required"})
+		return
+	}
+
+	account := models.ExchangeAccount{ID: uuid.New().String(), CreatedAt: time.Now()}
+	if err := h.applyAccountRequest(&account, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	account.UpdatedAt = time.Now()
+	if err := h.DB.Create(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, account)
+}
+
+// UpdateAccount changes an account. Running instances using it keep the
+// old credentials until restarted; they are listed in restart_required.
+func (h *Handlers) UpdateAccount(c *gin.Context) {
+	if h.Secrets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": secrets.ErrNotConfigured.Error()})
+		return
+	}
+	var account models.ExchangeAccount
+	if err := h.DB.First(&account, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errAccountNotFound.Error()})
+		return
+	}
+	var req accountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.applyAccountRequest(&account, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	account.UpdatedAt = time.Now()
+	if err := h.DB.Save(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids, running []string
+	h.DB.Model(&models.Instance{}).Where("account_id = ?", account.ID).Pluck("id", &ids)
+	for _, id := range ids {
+		if _, ok := h.PBRunner.Supervised(id); ok {
+			running = append(running, id)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"account": account, "restart_required": running})
+}
+
+// DeleteAccount removes an account no instance uses
+func (h *Handlers) DeleteAccount(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.ExchangeAccount{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errAccountNotFound.Error()})
+		return
+	}
+
+	var ids []string
+	h.DB.Model(&models.Instance{}).Where("account_id = ?", id).Pluck("id", &ids)
+	if len(ids) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "account is used by instances", "instances": ids})
+		return
+	}
+
+	if err := h.DB.Delete(&models.ExchangeAccount{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// applyAccountRequest copies a request into account, encrypting the
+// credentials it contains
+func (h *Handlers) applyAccountRequest(account *models.ExchangeAccount, req accountRequest) error {
+	if req.Label != nil {
+		account.Label = strings.TrimSpace(*req.Label)
+	}
+	if req.Exchange != nil {
+		account.Exchange = strings.ToLower(strings.TrimSpace(*req.Exchange))
+	}
+	if req.Subaccount != nil {
+		account.Subaccount = *req.Subaccount
+	}
+	if account.Label == "" {
+		return errors.New("label is required")
+	}
+	if account.Exchange == "" {
+		return errors.New("exchange is required")
+	}
+
+	seal := func(value *string, field string, dst *string) error {
+		if value == nil {
+			return nil
+		}
+		sealed, err := h.Secrets.Seal(*value, account.ID+"/"+field)
+		if err != nil {
+			return err
+		}
+		*dst = sealed
+		return nil
+	}
+	if req.Key != nil && *req.Key == "" || req.Secret != nil && *req.Secret == "" {
+		return errors.New("key and secret must not be empty")
+	}
+	if err := seal(req.Key, "key", &account.Key); err != nil {
+		return err
+	}
+	if err := seal(req.Secret, "secret", &account.Secret); err != nil {
+		return err
+	}
+	if err := seal(req.Passphrase, "passphrase", &account.Passphrase); err != nil {
+		return err
+	}
+	if req.Key != nil {
+		account.KeyHint = keyHint(*req.Key)
+	}
+	account.HasPassphrase = account.Passphrase != ""
+	return nil
+}
+
+// keyHint keeps the last four characters of a long enough API key
+func keyHint(key string) string {
+	if len(key) < 12 {
+		return ""
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// checkAccount verifies that an instance's account exists
+func (h *Handlers) checkAccount(accountID string) error {
+	if accountID == "" {
+		return nil
+	}
+	if err := h.DB.First(&models.ExchangeAccount{}, "id = ?", accountID).Error; err != nil {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+// attachCredentials decrypts the credentials of an instance's account for
+// the runner
+func (h *Handlers) attachCredentials(instance *models.Instance) error {
+	instance.Credentials = nil
+	if instance.AccountID == "" {
+		return nil
+	}
+	if h.Secrets == nil {
+		return secrets.ErrNotConfigured
+	}
+	var account models.ExchangeAccount
+	if err := h.DB.First(&account, "id = ?", instance.AccountID).Error; err != nil {
+		return errAccountNotFound
+	}
+
+	creds := models.APICredentials{
+		User:       passivbot.AccountUser(account.ID),
+		Exchange:   account.Exchange,
+		Subaccount: account.Subaccount,
+	}
+	for _, f := range []struct {
+		field string
+		value string
+		dst   *string
+	}{
+		{"key", account.Key, &creds.Key},
+		{"secret", account.Secret, &creds.Secret},
+		{"passphrase", account.Passphrase, &creds.Passphrase},
+	} {
+		plain, err := h.Secrets.Open(f.value, account.ID+"/"+f.field)
+		if err != nil {
+			return err
+		}
+		*f.dst = plain
+	}
+	instance.Credentials = &creds
+	return nil
+}
+
+// prepareStart attaches everything the runner needs to start an instance
+func (h *Handlers) prepareStart(instance *models.Instance) error {
+	if err := h.attachConfigSources(instance); err != nil {
+		return err
+	}
+	return h.attachCredentials(instance)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/secrets"
+)
+
+func (s *testServer) enableSecrets(t *testing.T) {
+	t.Helper()
+	box, err := secrets.NewBox(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", secrets.KeySize))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Secrets = box
+}
+
+// apiKeys reads the api-keys.json written for an instance, if any
+func (s *testServer) apiKeys(t *testing.T, id string) (map[string]map[string]string, bool) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(s.dir, "workspace", "instances", id, "api-keys.json"))
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+	return keys, true
+}
+
+func TestAccountCredentialLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	s.enableSecrets(t)
+
+	var account models.ExchangeAccount
+	body := map[string]string{"label": "main", "exchange": "Binance", "key": "key-1234567890", "secret": "secret-1"}
+	if w := s.do(t, "POST", "/api/v1/accounts", body, &account); w.Code != http.StatusCreated {
+		t.Fatalf("create account: %d %s", w.Code, w.Body)
+	}
+	// Stored sealed, never returned
+	var stored models.ExchangeAccount
+	s.DB.First(&stored, "id = ?", account.ID)
+	if stored.Key == "" || strings.Contains(stored.Key, "key-1234567890") || strings.Contains(stored.Secret, "secret-1") {
+		t.Errorf("credentials stored in the clear: %q %q", stored.Key, stored.Secret)
+	}
+
+	id := s.createInstance(t, map[string]interface{}{"name": "bot", "config": v7Config, "account_id": account.ID})
+	if _, ok := s.apiKeys(t, id); ok {
+		t.Error("API keys written before the instance started")
+	}
+	if w := s.do(t, "POST", "/api/v1/instances/"+id+"/start", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	keys, ok := s.apiKeys(t, id)
+	user := keys["pbgui_"+account.ID]
+	if !ok || user["exchange"] != "binance" || user["key"] != "key-1234567890" || user["secret"] != "secret-1" {
+		t.Errorf("api keys %v", keys)
+	}
+
+	// Running instances keep the old keys until restarted
+	var updated struct {
+		RestartRequired []string `json:"restart_required"`
+	}
+	s.do(t, "PUT", "/api/v1/accounts/"+account.ID, map[string]string{"secret": "secret-2"}, &updated)
+	if !equalStrings(updated.RestartRequired, []string{id}) {
+		t.Errorf("restart_required %q, want %q", updated.RestartRequired, id)
+	}
+	if keys, _ := s.apiKeys(t, id); keys["pbgui_"+account.ID]["secret"] != "secret-1" {
+		t.Error("keys of a running instance rewritten")
+	}
+
+	if w := s.do(t, "DELETE", "/api/v1/accounts/"+account.ID, nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("deleting an account in use: status %d, want 409", w.Code)
+	}
+
+	if w := s.do(t, "POST", "/api/v1/instances/"+id+"/stop", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("stop: %d %s", w.Code, w.Body)
+	}
+	if _, ok := s.apiKeys(t, id); ok {
+		t.Error("API keys kept after stop")
+	}
+
+	// A restart picks up the new secret; deleting the instance removes
+	// its workspace
+	s.do(t, "POST", "/api/v1/instances/"+id+"/start", nil, nil)
+	if keys, _ := s.apiKeys(t, id); keys["pbgui_"+account.ID]["secret"] != "secret-2" {
+		t.Errorf("api keys after restart %v", keys)
+	}
+	if w := s.do(t, "DELETE", "/api/v1/instances/"+id, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete instance: %d %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "workspace", "instances", id)); !os.IsNotExist(err) {
+		t.Errorf("workspace kept after delete: %v", err)
+	}
+	if w := s.do(t, "DELETE", "/api/v1/accounts/"+account.ID, nil, nil); w.Code != http.StatusOK {
+		t.Errorf("delete account: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAccountsWithoutSecrets(t *testing.T) {
+	s := newTestServer(t)
+	body := map[string]string{"label": "main", "exchange": "binance", "key": "k", "secret": "s"}
+	if w := s.do(t, "POST", "/api/v1/accounts", body, nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("create account: status %d, want 503", w.Code)
+	}
+
+	// An instance whose account cannot be decrypted does not start
+	s.DB.Create(&models.ExchangeAccount{ID: "acc", Label: "main", Exchange: "binance"})
+	s.addInstance(t, models.Instance{ID: "a", Config: v7Config, AccountID: "acc"})
+	if w := s.do(t, "POST", "/api/v1/instances/a/start", nil, nil); w.Code == http.StatusOK {
+		t.Error("started without a master key")
+	}
+	if _, ok := s.PBRunner.Supervised("a"); ok {
+		t.Error("instance running without credentials")
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"pbgui-backend/internal/models"
+)
+
+const defaultAuditLimit = 100
+
+// recordAudit stores an audit entry. The actor defaults to the client's
+// address when the caller did not identify themselves.
+func (h *Handlers) recordAudit(c *gin.Context, action, actor, reason string, details interface{}) *models.AuditLog {
+	if actor == "" {
+		actor = c.ClientIP()
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		data = []byte("null")
+	}
+
+	entry := &models.AuditLog{
+		ID:         uuid.New().String(),
+		Action:     action,
+		Actor:      actor,
+		RemoteAddr: c.ClientIP(),
+		Reason:     reason,
+		Details:    string(data),
+		CreatedAt:  time.Now(),
+	}
+	if err := h.DB.Create(entry).Error; err != nil {
+		log.Printf("Failed to record audit entry %s by %s: %v", action, actor, err)
+	}
+	return entry
+}
+
+// GetAuditLogs lists audit entries, newest first (`action`, `limit`)
+func (h *Handlers) GetAuditLogs(c *gin.Context) {
+	limit, err := parseIntParam(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit == 0 {
+		limit = defaultAuditLimit
+	}
+
+	query := h.DB.Order("created_at DESC").Limit(limit)
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	var entries []models.AuditLog
+	if err := query.Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,56 +1,123 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
 
 	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/jobs"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/pbconfig"
 )
 
-// Backtest Handlers
+const (
+	defaultFillsPageSize = 500
+	maxFillsPageSize     = 5000
 
-func (h *Handlers) RunBacktest(c *gin.Context) {
-	var params models.BacktestParams
-	if err := c.ShouldBindJSON(&params); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	fillBatchSize = 500
+)
 
-	// Create job
-	job := models.Job{
-		ID:        uuid.New().String(),
-		Type:      "backtest",
-		Status:    "queued",
-		Progress:  0,
-		CreatedAt: time.Now(),
+// RegisterJobHandlers sets up the job types the queue runs, with their
+// concurrency limits from the config
+func (h *Handlers) RegisterJobHandlers() {
+	h.Jobs.Register("backtest", h.Config.BacktestConcurrency, h.processBacktest)
+	h.Jobs.Register("optimize", h.Config.OptimizeConcurrency, h.processOptimization)
+}
+
+// enqueueJob queues a job with the given params and the optional
+// `priority` from the request body
+func (h *Handlers) enqueueJob(c *gin.Context, jobType string, params interface{}) {
+	var opts struct {
+		Priority int `json:"priority"`
 	}
+	c.ShouldBindBodyWith(&opts, binding.JSON)
 
 	// Convert params to JSON
 	paramsBytes, _ := json.Marshal(params)
-	job.Params = string(paramsBytes)
-
-	// Save job to database
-	if err := h.DB.Create(&job).Error; err != nil {
+	job := models.Job{
+		Type:     jobType,
+		Priority: opts.Priority,
+		Params:   string(paramsBytes),
+	}
+	if err := h.Jobs.Enqueue(&job); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Start backtest in goroutine
-	go h.processBacktest(&job, params)
-
 	c.JSON(http.StatusAccepted, gin.H{
-		"job_id": job.ID,
-		"status": "queued",
+		"job_id":   job.ID,
+		"status":   job.Status,
+		"priority": job.Priority,
+		"position": h.Jobs.Position(job),
 	})
 }
 
+// cancelJob cancels a queued or running job of the given type, killing its
+// process if it has one
+func (h *Handlers) cancelJob(c *gin.Context, jobType string) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.Job{}, "id = ? AND type = ?", id, jobType).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	err := h.Jobs.Cancel(id)
+	if errors.Is(err, jobs.ErrNotCancellable) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled", "status": jobs.StatusCancelled})
+}
+
+// sleepContext waits for d, returning early with the context's error if it
+// is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// GetJobQueue reports running and queued jobs per type
+func (h *Handlers) GetJobQueue(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Jobs.Stats())
+}
+
+// Backtest Handlers
+
+func (h *Handlers) RunBacktest(c *gin.Context) {
+	var params models.BacktestParams
+	if err := c.ShouldBindBodyWith(&params, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pbconfig.ValidateBacktest(params); err != nil {
+		respondConfigError(c, err)
+		return
+	}
+	h.enqueueJob(c, "backtest", params)
+}
+
 func (h *Handlers) GetBacktestJobs(c *gin.Context) {
 	var jobs []models.Job
-	if err := h.DB.Where("type = ?", "backtest").Find(&jobs).Error; err != nil {
+	// Results are only served per job
+	if err := h.DB.Omit("results").Where("type = ?", "backtest").Find(&jobs).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -70,20 +137,7 @@ func (h *Handlers) GetBacktestJob(c *gin.Context) {
 }
 
 func (h *Handlers) CancelBacktestJob(c *gin.Context) {
-	id := c.Param("id")
-	var job models.Job
-	
-	if err := h.DB.First(&job, "id = ? AND type = ?", id, "backtest").Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
-		return
-	}
-
-	if job.Status == "running" || job.Status == "queued" {
-		job.Status = "cancelled"
-		h.DB.Save(&job)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled"})
+	h.cancelJob(c, "backtest")
 }
 
 func (h *Handlers) GetBacktestResults(c *gin.Context) {
@@ -100,7 +154,7 @@ func (h *Handlers) GetBacktestResults(c *gin.Context) {
 		return
 	}
 
-	var results map[string]interface{}
+	var results passivbot.BacktestResult
 	if err := json.Unmarshal([]byte(job.Results), &results); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse results"})
 		return
@@ -109,78 +163,125 @@ func (h *Handlers) GetBacktestResults(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
-func (h *Handlers) processBacktest(job *models.Job, params models.BacktestParams) {
-	// Update job status to running
-	job.Status = "running"
-	job.Progress = 10
-	h.DB.Save(job)
-
-	// Simulate progress updates
-	for i := 20; i <= 90; i += 10 {
-		time.Sleep(2 * time.Second)
-		job.Progress = i
-		h.DB.Save(job)
+// processBacktest runs a queued backtest job
+func (h *Handlers) processBacktest(ctx context.Context, job *models.Job) (interface{}, error) {
+	var params models.BacktestParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return nil, err
 	}
 
-	// Run actual backtest
-	results, err := h.PBRunner.RunBacktest(params)
+	// Progress comes from the backtest's own output
+	result, err := h.PBRunner.RunBacktest(ctx, job.ID, params, func(p passivbot.BacktestProgress) {
+		h.Jobs.Report(job.ID, jobs.Update{
+			Percent:   p.Fraction * 100,
+			Stage:     p.Stage,
+			Processed: p.Processed,
+			Total:     p.Total,
+			ETA:       p.ETA,
+		})
+	})
 	if err != nil {
-		job.Status = "failed"
-		job.Error = err.Error()
-		h.DB.Save(job)
-		return
+		return nil, err
 	}
 
-	// Save results
-	resultsBytes, _ := json.Marshal(results)
-	job.Results = string(resultsBytes)
-	job.Status = "completed"
-	job.Progress = 100
-	now := time.Now()
-	job.CompletedAt = &now
-	h.DB.Save(job)
+	if err := h.storeFills(job.ID, result.Fills); err != nil {
+		return nil, fmt.Errorf("failed to store fills: %w", err)
+	}
+	result.Fills = nil
+	return result, nil
 }
 
-// Optimization Handlers
+// storeFills replaces a backtest job's fills, e.g. from a run interrupted
+// by a restart
+func (h *Handlers) storeFills(jobID string, fills []models.BacktestFill) error {
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", jobID).Delete(&models.BacktestFill{}).Error; err != nil {
+			return err
+		}
+		if len(fills) == 0 {
+			return nil
+		}
+		for i := range fills {
+			fills[i].JobID = jobID
+			fills[i].Seq = i
+		}
+		return tx.CreateInBatches(fills, fillBatchSize).Error
+	})
+}
 
-func (h *Handlers) RunOptimization(c *gin.Context) {
-	var params models.OptimizeParams
-	if err := c.ShouldBindJSON(&params); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GetBacktestFills pages through a completed backtest's fills in the order
+// passivbot wrote them (`page`, `page_size`)
+func (h *Handlers) GetBacktestFills(c *gin.Context) {
+	id := c.Param("id")
+	var job models.Job
+
+	if err := h.DB.Select("id", "status").First(&job, "id = ? AND type = ?", id, "backtest").Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 		return
 	}
-
-	// Create job
-	job := models.Job{
-		ID:        uuid.New().String(),
-		Type:      "optimize",
-		Status:    "queued",
-		Progress:  0,
-		CreatedAt: time.Now(),
+	if job.Status != "completed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Job not completed yet"})
+		return
 	}
 
-	// Convert params to JSON
-	paramsBytes, _ := json.Marshal(params)
-	job.Params = string(paramsBytes)
+	page, err := parseIntParam(c, "page")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pageSize, err := parseIntParam(c, "page_size")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultFillsPageSize
+	}
+	if pageSize > maxFillsPageSize {
+		pageSize = maxFillsPageSize
+	}
 
-	// Save job to database
-	if err := h.DB.Create(&job).Error; err != nil {
+	var total int64
+	fills := []models.BacktestFill{}
+	query := h.DB.Model(&models.BacktestFill{}).Where("job_id = ?", id).Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := query.Order("seq").Offset((page - 1) * pageSize).Limit(pageSize).Find(&fills).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Start optimization in goroutine
-	go h.processOptimization(&job, params)
-
-	c.JSON(http.StatusAccepted, gin.H{
-		"job_id": job.ID,
-		"status": "queued",
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"fills":     fills,
 	})
 }
 
+// Optimization Handlers
+
+func (h *Handlers) RunOptimization(c *gin.Context) {
+	var params models.OptimizeParams
+	if err := c.ShouldBindBodyWith(&params, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := pbconfig.ValidateBacktest(params.BacktestParams); err != nil {
+		respondConfigError(c, err)
+		return
+	}
+	h.enqueueJob(c, "optimize", params)
+}
+
 func (h *Handlers) GetOptimizeJobs(c *gin.Context) {
 	var jobs []models.Job
-	if err := h.DB.Where("type = ?", "optimize").Find(&jobs).Error; err != nil {
+	if err := h.DB.Omit("results").Where("type = ?", "optimize").Find(&jobs).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -199,6 +300,10 @@ func (h *Handlers) GetOptimizeJob(c *gin.Context) {
 	c.JSON(http.StatusOK, job)
 }
 
+func (h *Handlers) CancelOptimizeJob(c *gin.Context) {
+	h.cancelJob(c, "optimize")
+}
+
 func (h *Handlers) GetOptimizeResults(c *gin.Context) {
 	id := c.Param("id")
 	var job models.Job
@@ -222,11 +327,13 @@ func (h *Handlers) GetOptimizeResults(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
-func (h *Handlers) processOptimization(job *models.Job, params models.OptimizeParams) {
-	// Update job status to running
-	job.Status = "running"
-	job.Progress = 5
-	h.DB.Save(job)
+// processOptimization runs a queued optimization job
+func (h *Handlers) processOptimization(ctx context.Context, job *models.Job) (interface{}, error) {
+	var params models.OptimizeParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return nil, err
+	}
+	h.Jobs.SetProgress(job.ID, 5)
 
 	// Simulate longer optimization process
 	totalIterations := params.Iterations
@@ -235,10 +342,12 @@ func (h *Handlers) processOptimization(job *models.Job, params models.OptimizePa
 	}
 
 	for i := 1; i <= totalIterations; i++ {
-		time.Sleep(200 * time.Millisecond) // Simulate work
+		// Simulate work
+		if err := sleepContext(ctx, 200*time.Millisecond); err != nil {
+			return nil, err
+		}
 		progress := int(float64(i) / float64(totalIterations) * 95)
-		job.Progress = progress + 5
-		h.DB.Save(job)
+		h.Jobs.SetProgress(job.ID, progress+5)
 	}
 
 	// Mock optimization results
@@ -253,12 +362,5 @@ func (h *Handlers) processOptimization(job *models.Job, params models.OptimizePa
 		"completed_at":    time.Now(),
 	}
 
-	// Save results
-	resultsBytes, _ := json.Marshal(results)
-	job.Results = string(resultsBytes)
-	job.Status = "completed"
-	job.Progress = 100
-	now := time.Now()
-	job.CompletedAt = &now
-	h.DB.Save(job)
+	return results, nil
 }
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/jobs"
+	"pbgui-backend/internal/services/passivbot"
+)
+
+// fakeBacktest stands in for passivbot's backtest.py: it forks a worker,
+// records both PIDs with its config path next to itself and runs until
+// killed
+const fakeBacktest = `sh -c 'while :; do sleep 0.05; done' worker "$2" &
+echo "$$ $! $2" > "$(dirname "$0")/backtest.pids"
+while :; do sleep 0.05; done
+`
+
+var backtestParams = map[string]string{"exchange": "binance", "symbol": "BTCUSDT", "start_date": "2024-01-01", "end_date": "2024-02-01"}
+
+// startJobs runs the job queue with the fake backtest
+func (s *testServer) startJobs(t *testing.T) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(s.dir, "passivbot", "backtest.py"), []byte(fakeBacktest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Jobs = jobs.NewQueue(s.DB)
+	s.RegisterJobHandlers()
+	if err := s.Jobs.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enqueue submits a job and returns its ID
+func (s *testServer) enqueue(t *testing.T, path string, body interface{}) string {
+	t.Helper()
+	var resp struct {
+		JobID string `json:"job_id"`
+	}
+	if w := s.do(t, "POST", path, body, &resp); w.Code != http.StatusAccepted {
+		t.Fatalf("POST %s: %d %s", path, w.Code, w.Body)
+	}
+	return resp.JobID
+}
+
+// waitJob waits until cond holds for a job
+func (s *testServer) waitJob(t *testing.T, id string, cond func(models.Job) bool) models.Job {
+	t.Helper()
+	var job models.Job
+	for i := 0; i < 500; i++ {
+		job = models.Job{}
+		s.DB.First(&job, "id = ?", id)
+		if cond(job) {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s: %+v", id, job)
+	return job
+}
+
+// backtestProcesses waits for the fake backtest to record its processes
+func (s *testServer) backtestProcesses(t *testing.T) []passivbot.PIDInfo {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		data, _ := os.ReadFile(filepath.Join(s.dir, "passivbot", "backtest.pids"))
+		if fields := strings.Fields(string(data)); len(fields) == 3 {
+			var infos []passivbot.PIDInfo
+			for _, f := range fields[:2] {
+				pid, _ := strconv.Atoi(f)
+				infos = append(infos, passivbot.PIDInfo{PID: pid, ConfigPath: fields[2]})
+			}
+			return infos
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("backtest did not start")
+	return nil
+}
+
+func TestCancelBacktest(t *testing.T) {
+	s := newTestServer(t)
+	s.startJobs(t)
+	id := s.enqueue(t, "/api/v1/backtest/run", backtestParams)
+	procs := s.backtestProcesses(t)
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if w := s.do(t, "DELETE", "/api/v1/backtest/jobs/"+id, nil, &resp); w.Code != http.StatusOK || resp.Status != jobs.StatusCancelled {
+		t.Fatalf("cancel: %d %s", w.Code, w.Body)
+	}
+	// The backtest and the worker it forked both die
+	for _, p := range procs {
+		for i := 0; i < 200 && p.Alive(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p.Alive() {
+			t.Errorf("process %d survived the cancel", p.PID)
+		}
+	}
+	// The handler returns, cleaning up, and the job stays cancelled
+	jobDir := filepath.Join(s.dir, "workspace", "jobs", id)
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(jobDir); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(jobDir); !os.IsNotExist(err) {
+		t.Errorf("job workspace kept: %v", err)
+	}
+	if job := s.waitJob(t, id, func(j models.Job) bool { return j.Status != jobs.StatusRunning }); job.Status != jobs.StatusCancelled {
+		t.Errorf("status %s after cancel", job.Status)
+	}
+
+	if w := s.do(t, "DELETE", "/api/v1/backtest/jobs/"+id, nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("cancelling again: status %d, want 409", w.Code)
+	}
+	if w := s.do(t, "DELETE", "/api/v1/optimize/jobs/"+id, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cancelling a backtest as an optimization: status %d, want 404", w.Code)
+	}
+}
+
+func TestCancelOptimization(t *testing.T) {
+	s := newTestServer(t)
+	s.startJobs(t)
+	params := map[string]interface{}{"exchange": "binance", "symbol": "BTCUSDT", "start_date": "2024-01-01", "end_date": "2024-02-01", "iterations": 100}
+	running := s.enqueue(t, "/api/v1/optimize/run", params)
+	queued := s.enqueue(t, "/api/v1/optimize/run", params)
+	s.waitJob(t, running, func(j models.Job) bool { return j.Status == jobs.StatusRunning && j.Progress > 5 })
+
+	for _, id := range []string{queued, running} {
+		if w := s.do(t, "DELETE", "/api/v1/optimize/jobs/"+id, nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("cancel %s: %d %s", id, w.Code, w.Body)
+		}
+	}
+	// The running optimization stops making progress; the queued one never
+	// starts
+	before := s.waitJob(t, running, func(j models.Job) bool { return j.Status == jobs.StatusCancelled })
+	time.Sleep(500 * time.Millisecond)
+	if after := s.waitJob(t, running, func(models.Job) bool { return true }); after.Progress != before.Progress || after.Status != jobs.StatusCancelled {
+		t.Errorf("cancelled optimization went on: progress %d -> %d, status %s", before.Progress, after.Progress, after.Status)
+	}
+	if job := s.waitJob(t, queued, func(models.Job) bool { return true }); job.Status != jobs.StatusCancelled || job.StartedAt != nil {
+		t.Errorf("queued optimization: status %s, started %v", job.Status, job.StartedAt)
+	}
+
+	if w := s.do(t, "DELETE", "/api/v1/optimize/jobs/"+running, nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("cancelling again: status %d, want 409", w.Code)
+	}
+	if w := s.do(t, "DELETE", "/api/v1/optimize/jobs/missing", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing job: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+var errVPSNotFound = errors.New("VPS server not found")
+
+// Bulk instance actions
+var bulkActions = []string{"start", "stop", "restart", "delete"}
+
+// instanceSelector picks instances by exchange, symbol, VPS and status.
+// Empty fields match everything.
+type instanceSelector struct {
+	Exchange string `json:"exchange"`
+	Symbol   string `json:"symbol"`
+	VPSID    string `json:"vps_id"`
+	Status   string `json:"status"`
+}
+
+func (s instanceSelector) empty() bool {
+	return s == instanceSelector{}
+}
+
+// selectInstances returns the instances matching the selector
+func (h *Handlers) selectInstances(sel instanceSelector) ([]models.Instance, error) {
+	query := h.DB.Model(&models.Instance{})
+	if sel.Exchange != "" {
+		query = query.Where("LOWER(exchange) = LOWER(?)", sel.Exchange)
+	}
+	if sel.Status != "" {
+		query = query.Where("status = ?", sel.Status)
+	}
+	if sel.VPSID != "" {
+		var server models.VPSServer
+		if err := h.DB.First(&server, "id = ?", sel.VPSID).Error; err != nil {
+			return nil, errVPSNotFound
+		}
+		query = query.Where("id IN ?", server.Instances)
+	}
+
+	var instances []models.Instance
+	if sel.Symbol != "" {
+		query = query.Preload("Symbols")
+	}
+	if err := query.Find(&instances).Error; err != nil {
+		return nil, err
+	}
+	if sel.Symbol == "" {
+		return instances, nil
+	}
+
+	matched := instances[:0]
+	for _, instance := range instances {
+		if tradesSymbol(instance, sel.Symbol) {
+			matched = append(matched, instance)
+		}
+	}
+	return matched, nil
+}
+
+// tradesSymbol reports whether an instance trades a symbol, as its own
+// symbol or one of its coins. Symbols are compared in normalized form, so
+// BTC/USDT:USDT matches BTCUSDT, and coins by name, so it matches BTC too.
+func tradesSymbol(instance models.Instance, symbol string) bool {
+	if passivbot.NormalizeSymbol(instance.Symbol) == passivbot.NormalizeSymbol(symbol) {
+		return true
+	}
+	return findSymbol(instance.Symbols, pbconfig.CoinName(symbol)) >= 0
+}
+
+type bulkRequest struct {
+	Action   string           `json:"action" binding:"required"`
+	IDs      []string         `json:"ids"`
+	Selector instanceSelector `json:"selector"`
+	Actor    string           `json:"actor"`
+	Reason   string           `json:"reason"`
+}
+
+// BulkInstances runs start, stop, restart or delete over a list of instance
+// IDs or every instance matching a selector. The work runs in the runner's
+// worker pool; the response carries an operation ID to follow progress.
+func (h *Handlers) BulkInstances(c *gin.Context) {
+	var req bulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !contains(bulkActions, req.Action) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid action %q: must be one of start, stop, restart, delete", req.Action)})
+		return
+	}
+	// An empty selector matches every instance, so IDs that are all blank
+	// must not fall through to it
+	ids := uniqueStrings(req.IDs)
+	if len(ids) > 0 == !req.Selector.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either ids or a selector"})
+		return
+	}
+
+	if len(ids) == 0 {
+		instances, err := h.selectInstances(req.Selector)
+		if err == errVPSNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, instance := range instances {
+			ids = append(ids, instance.ID)
+		}
+	}
+
+	op := h.PBRunner.RunOperation(req.Action, ids, func(id string) (string, error) {
+		return h.applyBulkAction(req.Action, id)
+	})
+	h.recordAudit(c, "bulk_"+req.Action, req.Actor, req.Reason, gin.H{
+		"operation_id": op.Snapshot().ID,
+		"ids":          ids,
+		"selector":     req.Selector,
+	})
+
+	snap := op.Snapshot()
+	c.JSON(http.StatusAccepted, gin.H{
+		"operation_id": snap.ID,
+		"action":       snap.Action,
+		"total":        snap.Total,
+		"status":       snap.Status,
+	})
+}
+
+// applyBulkAction performs one item of a bulk operation
+func (h *Handlers) applyBulkAction(action, id string) (string, error) {
+	var instance models.Instance
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		return "", fmt.Errorf("instance not found")
+	}
+	_, running := h.PBRunner.Supervised(id)
+
+	switch action {
+	case "start":
+		if running {
+			return "already running", nil
+		}
+		return "started", h.startInstance(instance)
+
+	case "stop":
+		if !running {
+			h.setInstanceStatus(id, passivbot.StatusStopped, "")
+			return "not running", nil
+		}
+		return h.stopInstance(id)
+
+	case "restart":
+		if _, err := h.restartInstance(instance, 0); err != nil {
+			return "", err
+		}
+		if !running {
+			return "started", nil
+		}
+		return "restarted", nil
+
+	case "delete":
+		if running {
+			if _, err := h.stopInstance(id); err != nil {
+				return "", err
+			}
+		}
+		if err := h.deleteInstanceRecord(id); err != nil {
+			return "", err
+		}
+		return "deleted", nil
+	}
+	return "", fmt.Errorf("unknown action %q", action)
+}
+
+func (h *Handlers) startInstance(instance models.Instance) error {
+	if err := h.prepareStart(&instance); err != nil {
+		return err
+	}
+	if err := h.PBRunner.Start(instance); err != nil {
+		return err
+	}
+	h.setInstanceStatus(instance.ID, passivbot.StatusRunning, "")
+	return nil
+}
+
+func (h *Handlers) stopInstance(id string) (string, error) {
+	result, err := h.PBRunner.Stop(id, 0)
+	if err != nil {
+		return "", err
+	}
+	h.setInstanceStatus(id, passivbot.StatusStopped, "")
+	if result.Graceful {
+		return fmt.Sprintf("stopped in %s", result.Duration.Round(time.Millisecond)), nil
+	}
+	return fmt.Sprintf("stopped with %s after %s", result.Signal, result.Duration.Round(time.Millisecond)), nil
+}
+
+// GetOperation returns the progress of a bulk operation
+func (h *Handlers) GetOperation(c *gin.Context) {
+	op, ok := h.PBRunner.Operation(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Operation not found"})
+		return
+	}
+	c.JSON(http.StatusOK, op.Snapshot())
+}
+
+// uniqueStrings drops empty and repeated values, keeping order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
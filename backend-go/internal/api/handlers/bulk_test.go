@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"pbgui-backend/internal/models"
+)
+
+func TestBulkInstancesValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no action", `{"ids":["a"]}`, http.StatusBadRequest},
+		{"unknown action", `{"action":"explode","ids":["a"]}`, http.StatusBadRequest},
+		{"neither ids nor selector", `{"action":"stop"}`, http.StatusBadRequest},
+		{"both ids and selector", `{"action":"stop","ids":["a"],"selector":{"exchange":"binance"}}`, http.StatusBadRequest},
+		{"only blank ids", `{"action":"delete","ids":[""]}`, http.StatusBadRequest},
+		{"empty ids list", `{"action":"delete","ids":[]}`, http.StatusBadRequest},
+		{"blank ids with a selector", `{"action":"stop","ids":["",""],"selector":{"exchange":"binance"}}`, http.StatusAccepted},
+		{"unknown VPS", `{"action":"stop","selector":{"vps_id":"nope"}}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.addInstance(t, models.Instance{ID: "a", Exchange: "binance"})
+			s.addInstance(t, models.Instance{ID: "b", Exchange: "bybit"})
+
+			var resp struct {
+				OperationID string `json:"operation_id"`
+			}
+			w := s.do(t, "POST", "/api/v1/instances/bulk", tt.body, &resp)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if resp.OperationID != "" {
+				s.waitOperation(t, resp.OperationID)
+			}
+
+			// Nothing was deleted by a rejected request
+			var count int64
+			s.DB.Model(&models.Instance{}).Count(&count)
+			if count != 2 {
+				t.Errorf("%d instances left, want 2", count)
+			}
+		})
+	}
+}
+
+func TestBulkInstancesSelection(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"ids", `{"action":"stop","ids":["btc","","btc","eth"]}`, []string{"btc", "eth"}},
+		{"exchange", `{"action":"stop","selector":{"exchange":"BINANCE"}}`, []string{"btc"}},
+		{"status", `{"action":"stop","selector":{"status":"error"}}`, []string{"eth"}},
+		{"native symbol", `{"action":"stop","selector":{"symbol":"BTCUSDT"}}`, []string{"btc", "multi"}},
+		{"ccxt symbol", `{"action":"stop","selector":{"symbol":"ETH/USDT:USDT"}}`, []string{"eth", "multi"}},
+		{"coin of a multi-symbol instance", `{"action":"stop","selector":{"symbol":"sol"}}`, []string{"multi"}},
+		{"symbol and exchange", `{"action":"stop","selector":{"symbol":"BTC/USDT:USDT","exchange":"binance"}}`, []string{"btc"}},
+		{"no match", `{"action":"stop","selector":{"symbol":"DOGE"}}`, nil},
+		{"vps", `{"action":"stop","selector":{"vps_id":"vps1"}}`, []string{"eth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.addInstance(t, models.Instance{ID: "btc", Exchange: "binance", Symbol: "BTCUSDT"})
+			s.addInstance(t, models.Instance{ID: "eth", Exchange: "bybit", Symbol: "ETH/USDT:USDT", Status: "error"})
+			s.addInstance(t, models.Instance{ID: "multi", Exchange: "bybit", Symbols: []models.InstanceSymbol{
+				{ID: "s1", Symbol: "BTC", Long: true}, {ID: "s2", Symbol: "ETH", Short: true}, {ID: "s3", Symbol: "SOL", Long: true},
+			}})
+			s.DB.Create(&models.VPSServer{ID: "vps1", Name: "vps", Instances: []string{"eth"}})
+
+			var resp struct {
+				OperationID string `json:"operation_id"`
+				Total       int    `json:"total"`
+			}
+			if w := s.do(t, "POST", "/api/v1/instances/bulk", tt.body, &resp); w.Code != http.StatusAccepted {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			op := s.waitOperation(t, resp.OperationID)
+
+			var got []string
+			for _, item := range op.Items {
+				got = append(got, item.InstanceID)
+				if item.Status != "succeeded" || item.Message != "not running" {
+					t.Errorf("item %s: %s %q %q", item.InstanceID, item.Status, item.Message, item.Error)
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) || resp.Total != len(tt.want) {
+				t.Errorf("selected %q (total %d), want %q", got, resp.Total, tt.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
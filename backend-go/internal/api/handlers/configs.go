@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+// Config Handlers
+
+// GetConfigSchemas describes every supported passivbot config schema
+func (h *Handlers) GetConfigSchemas(c *gin.Context) {
+	c.JSON(http.StatusOK, pbconfig.Schemas())
+}
+
+// respondConfigError reports an invalid config with the path of each problem
+func respondConfigError(c *gin.Context, err error) {
+	var invalid *pbconfig.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          err.Error(),
+			"schema_version": invalid.Version,
+			"fields":         invalid.Errors,
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// Largest accepted import request
+const maxImportSize = 64 << 20
+
+// importedItem is a template or instance created from an imported config
+type importedItem struct {
+	pbconfig.ImportedConfig
+	Kind string `json:"kind"` // template or instance
+	ID   string `json:"id"`
+}
+
+// ImportConfigs imports native passivbot configs and optimizer results as
+// templates (default) or stopped instances (`as=instance`). Files are sent
+// as multipart `files`, or a zip or JSON file as the request body. Every
+// file is reported as imported or with the reason it was not.
+func (h *Handlers) ImportConfigs(c *gin.Context) {
+	kind := c.DefaultQuery("as", "template")
+	if kind != "template" && kind != "instance" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as must be template or instance"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var configs []pbconfig.ImportedConfig
+	errs := []pbconfig.ImportError{}
+	add := func(name string, data []byte) {
+		cs, es := pbconfig.ParseFile(name, data)
+		configs = append(configs, cs...)
+		errs = append(errs, es...)
+	}
+
+	switch contentType := c.ContentType(); contentType {
+	case "multipart/form-data":
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		files := append(form.File["files"], form.File["file"]...)
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no files uploaded; use the files field"})
+			return
+		}
+		for _, fh := range files {
+			data, err := readUpload(fh)
+			if err != nil {
+				errs = append(errs, pbconfig.ImportError{Source: fh.Filename, Error: err.Error()})
+				continue
+			}
+			add(fh.Filename, data)
+		}
+
+	case "application/zip", "application/json":
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		name := c.DefaultQuery("filename", "upload."+strings.TrimPrefix(contentType, "application/"))
+		add(name, data)
+
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "send multipart/form-data, application/zip or application/json"})
+		return
+	}
+
+	imported := []importedItem{}
+	for _, cfg := range configs {
+		id, err := h.createImported(c, kind, &cfg)
+		if err != nil {
+			errs = append(errs, pbconfig.ImportError{Source: cfg.Source, Error: err.Error()})
+			continue
+		}
+		imported = append(imported, importedItem{ImportedConfig: cfg, Kind: kind, ID: id})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imported": imported, "errors": errs})
+}
+
+func readUpload(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// createImported stores one imported config and returns the new record's
+// ID. Template names are made unique, which updates cfg.Name.
+func (h *Handlers) createImported(c *gin.Context, kind string, cfg *pbconfig.ImportedConfig) (string, error) {
+	now := time.Now()
+	if kind == "template" {
+		cfg.Name = h.uniqueTemplateName(cfg.Name)
+		template := models.ConfigTemplate{
+			ID:          uuid.New().String(),
+			Name:        cfg.Name,
+			Description: "Imported from " + cfg.Source,
+			Config:      cfg.Config,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		return template.ID, h.DB.Create(&template).Error
+	}
+
+	instance := models.Instance{
+		ID:            uuid.New().String(),
+		Name:          cfg.Name,
+		Exchange:      cfg.Exchange,
+		Symbol:        cfg.Symbol,
+		Status:        passivbot.StatusStopped,
+		Config:        cfg.Config,
+		RestartPolicy: passivbot.RestartNever,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	meta := revisionMeta{Author: c.ClientIP(), Message: "Imported from " + cfg.Source}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&instance).Error; err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, instance, meta)
+		return err
+	})
+	return instance.ID, err
+}
+
+// uniqueTemplateName appends a counter to name until no template has it
+func (h *Handlers) uniqueTemplateName(name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		var count int64
+		h.DB.Model(&models.ConfigTemplate{}).Where("name = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
+
+// ExportInstanceConfig downloads the exact config file the instance is
+// started with
+func (h *Handlers) ExportInstanceConfig(c *gin.Context) {
+	var instance models.Instance
+	if err := h.DB.First(&instance, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+	if err := h.attachConfigSources(&instance); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := h.PBRunner.ConfigFile(instance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setAttachment(c, "application/json", instance.ID+".json")
+	c.Writer.Write(data)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/pkg/config"
+)
+
+// fakePassivbot stands in for passivbot's main.py, run with sh: it prints
+// its arguments and runs until interrupted
+const fakePassivbot = `trap 'echo "shutting down"; exit 0' INT TERM
+echo "started $*"
+while :; do sleep 0.05; done
+`
+
+// v7Config is a minimal valid passivbot 7 config
+const v7Config = `{"bot":{"long":{"n_positions":1,"total_wallet_exposure_limit":1},"short":{"n_positions":0,"total_wallet_exposure_limit":0}},"live":{"leverage":5}}`
+
+type testServer struct {
+	*Handlers
+	router *gin.Engine
+	dir    string // holds the database, passivbot and the run directory
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	dsn := filepath.Join(dir, "pbgui.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Instance{}, &models.Job{}, &models.VPSServer{}, &models.AuditLog{}, &models.ConfigRevision{}, &models.ConfigTemplate{}, &models.InstanceSymbol{}, &models.ExchangeAccount{}, &models.BacktestFill{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pbPath := filepath.Join(dir, "passivbot")
+	os.MkdirAll(pbPath, 0755)
+	if err := os.WriteFile(filepath.Join(pbPath, "main.py"), []byte(fakePassivbot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs := passivbot.NewLogStore(filepath.Join(dir, "logs"), passivbot.RotationPolicy{})
+	runner := passivbot.NewRunner(pbPath, "/bin/sh", filepath.Join(dir, "run"), logs)
+	runner.SetWorkspaceDir(filepath.Join(dir, "workspace"))
+	runner.SetStopGracePeriod(2 * time.Second)
+
+	h := &Handlers{DB: db, PBRunner: runner, Config: &config.Config{}}
+	runner.OnStateChange(h.RecordInstanceState)
+	t.Cleanup(func() {
+		var ids []string
+		db.Model(&models.Instance{}).Pluck("id", &ids)
+		for _, id := range ids {
+			if _, running := runner.Supervised(id); running {
+				runner.Stop(id, time.Second)
+			}
+		}
+	})
+
+	s := &testServer{Handlers: h, router: gin.New(), dir: dir}
+	api := s.router.Group("/api/v1")
+	api.POST("/instances", h.CreateInstance)
+	api.POST("/instances/bulk", h.BulkInstances)
+	api.POST("/instances/stop-all", h.StopAllInstances)
+	api.PUT("/instances/:id", h.UpdateInstance)
+	api.DELETE("/instances/:id", h.DeleteInstance)
+	api.POST("/instances/:id/start", h.StartInstance)
+	api.POST("/instances/:id/stop", h.StopInstance)
+	api.GET("/instances/:id/config/revisions", h.ListConfigRevisions)
+	api.GET("/instances/:id/config/diff", h.DiffConfigRevisions)
+	api.POST("/instances/:id/config/rollback", h.RollbackConfig)
+	api.POST("/instances/:id/symbols", h.AddInstanceSymbol)
+	api.PUT("/instances/:id/symbols/:symbol", h.UpdateInstanceSymbol)
+	api.DELETE("/instances/:id/symbols/:symbol", h.RemoveInstanceSymbol)
+	api.POST("/accounts", h.CreateAccount)
+	api.PUT("/accounts/:id", h.UpdateAccount)
+	api.DELETE("/accounts/:id", h.DeleteAccount)
+	api.POST("/templates", h.CreateTemplate)
+	api.PUT("/templates/:id", h.UpdateTemplate)
+	api.GET("/operations/:id", h.GetOperation)
+	api.POST("/backtest/run", h.RunBacktest)
+	api.DELETE("/backtest/jobs/:id", h.CancelBacktestJob)
+	api.POST("/optimize/run", h.RunOptimization)
+	api.DELETE("/optimize/jobs/:id", h.CancelOptimizeJob)
+	return s
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out, if not nil
+func (s *testServer) do(t *testing.T, method, path string, body interface{}, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+// addInstance stores an instance directly, bypassing validation
+func (s *testServer) addInstance(t *testing.T, instance models.Instance) models.Instance {
+	t.Helper()
+	if instance.Status == "" {
+		instance.Status = passivbot.StatusStopped
+	}
+	if instance.RestartPolicy == "" {
+		instance.RestartPolicy = passivbot.RestartNever
+	}
+	if err := s.DB.Create(&instance).Error; err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func (s *testServer) instance(t *testing.T, id string) models.Instance {
+	t.Helper()
+	var instance models.Instance
+	if err := s.DB.Preload("Symbols").First(&instance, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+// pidInfo returns the PID file of a running instance
+func (s *testServer) pidInfo(t *testing.T, id string) passivbot.PIDInfo {
+	t.Helper()
+	infos, err := s.PBRunner.PIDFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := infos[id]
+	if !ok {
+		t.Fatalf("no PID file for instance %s", id)
+	}
+	return info
+}
+
+// waitOperation waits for a bulk operation to complete
+func (s *testServer) waitOperation(t *testing.T, id string) passivbot.OperationSnapshot {
+	t.Helper()
+	op, ok := s.PBRunner.Operation(id)
+	if !ok {
+		t.Fatalf("operation %s not found", id)
+	}
+	_, updates, cancel := op.Watch()
+	defer cancel()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				return op.Snapshot()
+			}
+		case <-timeout:
+			t.Fatalf("operation %s did not complete: %+v", id, op.Snapshot())
+		}
+	}
+}
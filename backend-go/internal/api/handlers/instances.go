@@ -1,15 +1,23 @@
 package handlers
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/jobs"
 	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/secrets"
 	"pbgui-backend/pkg/config"
 )
 
@@ -17,32 +25,66 @@ type Handlers struct {
 	DB       *gorm.DB
 	PBRunner *passivbot.Runner
 	Config   *config.Config
+	Secrets  *secrets.Box // nil without a master key
+	Jobs     *jobs.Queue
 }
 
 // Instance Management Handlers
 
 func (h *Handlers) GetInstances(c *gin.Context) {
 	var instances []models.Instance
-	if err := h.DB.Find(&instances).Error; err != nil {
+	if err := h.DB.Preload("Symbols").Find(&instances).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range instances {
+		instances[i].Usage = h.PBRunner.Usage(instances[i].ID)
+	}
 	c.JSON(http.StatusOK, instances)
 }
 
 func (h *Handlers) CreateInstance(c *gin.Context) {
 	var instance models.Instance
-	if err := c.ShouldBindJSON(&instance); err != nil {
+	if err := c.ShouldBindBodyWith(&instance, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateInstance(instance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	normalizeSymbols(instance.Symbols)
+	if err := validateSymbols(instance.Symbols); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.checkAccount(instance.AccountID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.validateRenderedConfig(&instance); err != nil {
+		respondConfigError(c, err)
+		return
+	}
 
 	instance.ID = uuid.New().String()
 	instance.Status = "stopped"
+	if instance.RestartPolicy == "" {
+		instance.RestartPolicy = passivbot.RestartNever
+	}
 	instance.CreatedAt = time.Now()
 	instance.UpdatedAt = time.Now()
 
-	if err := h.DB.Create(&instance).Error; err != nil {
+	meta := bindRevisionMeta(c, "Initial config")
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&instance).Error; err != nil {
+			return err
+		}
+		_, err := recordRevision(tx, instance, meta)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -54,10 +96,11 @@ func (h *Handlers) GetInstance(c *gin.Context) {
 	id := c.Param("id")
 	var instance models.Instance
 	
-	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+	if err := h.DB.Preload("Symbols").First(&instance, "id = ?", id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
 		return
 	}
+	instance.Usage = h.PBRunner.Usage(id)
 	
 	c.JSON(http.StatusOK, instance)
 }
@@ -71,27 +114,182 @@ func (h *Handlers) UpdateInstance(c *gin.Context) {
 		return
 	}
 
-	if err := c.ShouldBindJSON(&instance); err != nil {
+	before := instance
+	var req instanceUpdateRequest
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	updates := req.apply(&instance)
+	if err := validateInstance(instance); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if instance.AccountID != before.AccountID {
+		if err := h.checkAccount(instance.AccountID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// Configs saved before validation existed stay editable
+	if configSourcesChanged(before, instance) {
+		if err := h.validateRenderedConfig(&instance); err != nil {
+			respondConfigError(c, err)
+			return
+		}
+		if err := h.attachConfigSources(&before); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
+	// Only the edited columns are written, so a status recorded by the
+	// runner meanwhile is kept
 	instance.UpdatedAt = time.Now()
-	if err := h.DB.Save(&instance).Error; err != nil {
+	updates["updated_at"] = instance.UpdatedAt
+	meta := bindRevisionMeta(c, "Config updated")
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Instance{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+		if !configSourcesChanged(before, instance) {
+			return nil
+		}
+		_, err := recordConfigChange(tx, before, instance, meta)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, instance)
+	// A running bot only sees new settings after a restart; ?apply=true
+	// restarts it gracefully with the rewritten config
+	resp := instanceUpdate{Instance: instance}
+	if _, running := h.PBRunner.Supervised(id); running && processSettingsChanged(before, instance) {
+		if c.Query("apply") != "true" {
+			resp.RestartRequired = true
+		} else {
+			if _, err := h.restartInstance(instance, 0); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("saved, but restart failed: %v", err)})
+				return
+			}
+			resp.Status = passivbot.StatusRunning
+			resp.Restarted = true
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// instanceUpdateRequest holds the instance fields clients may change. Fields
+// left out keep their value; status and exit details belong to the runner
+// and symbols have their own endpoints.
+type instanceUpdateRequest struct {
+	Name          *string  `json:"name"`
+	Exchange      *string  `json:"exchange"`
+	Symbol        *string  `json:"symbol"`
+	Strategy      *string  `json:"strategy"`
+	Config        *string  `json:"config"`
+	RestartPolicy *string  `json:"restart_policy"`
+	MaxRestarts   *int     `json:"max_restarts"`
+	RestartWindow *int     `json:"restart_window"`
+	CPULimit      *float64 `json:"cpu_limit"`
+	MemoryLimitMB *int     `json:"memory_limit_mb"`
+	MaxOpenFiles  *int     `json:"max_open_files"`
+	TemplateID    *string  `json:"template_id"`
+	Overrides     *string  `json:"overrides"`
+	AccountID     *string  `json:"account_id"`
+}
+
+// apply sets the requested fields on instance and returns them by column
+func (req instanceUpdateRequest) apply(instance *models.Instance) map[string]interface{} {
+	updates := map[string]interface{}{}
+	texts := []struct {
+		column string
+		value  *string
+		field  *string
+	}{
+		{"name", req.Name, &instance.Name},
+		{"exchange", req.Exchange, &instance.Exchange},
+		{"symbol", req.Symbol, &instance.Symbol},
+		{"strategy", req.Strategy, &instance.Strategy},
+		{"config", req.Config, &instance.Config},
+		{"restart_policy", req.RestartPolicy, &instance.RestartPolicy},
+		{"template_id", req.TemplateID, &instance.TemplateID},
+		{"overrides", req.Overrides, &instance.Overrides},
+		{"account_id", req.AccountID, &instance.AccountID},
+	}
+	for _, f := range texts {
+		if f.value != nil {
+			*f.field = *f.value
+			updates[f.column] = *f.value
+		}
+	}
+	counts := []struct {
+		column string
+		value  *int
+		field  *int
+	}{
+		{"max_restarts", req.MaxRestarts, &instance.MaxRestarts},
+		{"restart_window", req.RestartWindow, &instance.RestartWindow},
+		{"memory_limit_mb", req.MemoryLimitMB, &instance.MemoryLimitMB},
+		{"max_open_files", req.MaxOpenFiles, &instance.MaxOpenFiles},
+	}
+	for _, f := range counts {
+		if f.value != nil {
+			*f.field = *f.value
+			updates[f.column] = *f.value
+		}
+	}
+	if req.CPULimit != nil {
+		instance.CPULimit = *req.CPULimit
+		updates["cpu_limit"] = *req.CPULimit
+	}
+	return updates
+}
+
+// instanceUpdate is an updated instance plus what happened to its process
+type instanceUpdate struct {
+	models.Instance
+	Restarted       bool `json:"restarted"`
+	RestartRequired bool `json:"restart_required"` // running with settings not yet applied
+}
+
+// processSettingsChanged reports whether an update touches anything the
+// running process was started with
+func processSettingsChanged(before, after models.Instance) bool {
+	return before.Config != after.Config ||
+		before.Exchange != after.Exchange ||
+		before.Symbol != after.Symbol ||
+		before.Strategy != after.Strategy ||
+		before.RestartPolicy != after.RestartPolicy ||
+		before.MaxRestarts != after.MaxRestarts ||
+		before.RestartWindow != after.RestartWindow ||
+		before.CPULimit != after.CPULimit ||
+		before.MemoryLimitMB != after.MemoryLimitMB ||
+		before.MaxOpenFiles != after.MaxOpenFiles ||
+		before.TemplateID != after.TemplateID ||
+		before.Overrides != after.Overrides ||
+		before.AccountID != after.AccountID
 }
 
 func (h *Handlers) DeleteInstance(c *gin.Context) {
 	id := c.Param("id")
-	
+	if err := h.DB.First(&models.Instance{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+
 	// First stop the instance if running
-	h.StopInstance(c)
-	
-	if err := h.DB.Delete(&models.Instance{}, "id = ?", id).Error; err != nil {
+	if _, running := h.PBRunner.Supervised(id); running {
+		if _, err := h.stopInstance(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.deleteInstanceRecord(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -108,17 +306,18 @@ func (h *Handlers) StartInstance(c *gin.Context) {
 		return
 	}
 
+	if err := h.prepareStart(&instance); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Start the instance using PBRunner
 	if err := h.PBRunner.Start(instance); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Update status
-	instance.Status = "running"
-	instance.UpdatedAt = time.Now()
-	h.DB.Save(&instance)
-
+	// Status is recorded by RecordInstanceState, including an immediate exit
 	c.JSON(http.StatusOK, gin.H{"message": "Instance started", "status": "running"})
 }
 
@@ -131,18 +330,219 @@ func (h *Handlers) StopInstance(c *gin.Context) {
 		return
 	}
 
+	// Optional grace period in seconds before escalating to SIGTERM
+	grace, err := parseIntParam(c, "grace")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Stop the instance using PBRunner
-	if err := h.PBRunner.Stop(id); err != nil {
+	result, err := h.PBRunner.Stop(id, time.Duration(grace)*time.Second)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Update status
-	instance.Status = "stopped"
-	instance.UpdatedAt = time.Now()
-	h.DB.Save(&instance)
+	// Update status; exit details are recorded by RecordInstanceState
+	h.DB.Model(&instance).Updates(map[string]interface{}{"status": "stopped", "updated_at": time.Now()})
 
-	c.JSON(http.StatusOK, gin.H{"message": "Instance stopped", "status": "stopped"})
+	resp := stopSummary(result)
+	resp["message"] = "Instance stopped"
+	resp["status"] = "stopped"
+	c.JSON(http.StatusOK, resp)
+}
+
+// RestartInstance stops a running instance gracefully (`grace` seconds
+// before escalating) and starts it again with its current settings
+func (h *Handlers) RestartInstance(c *gin.Context) {
+	id := c.Param("id")
+	var instance models.Instance
+
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+	grace, err := parseIntParam(c, "grace")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.restartInstance(instance, time.Duration(grace)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{"message": "Instance restarted", "status": "running", "restarted": true, "was_running": result != nil}
+	if result != nil {
+		resp["stop"] = stopSummary(result)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) restartInstance(instance models.Instance, grace time.Duration) (*passivbot.StopResult, error) {
+	if err := h.prepareStart(&instance); err != nil {
+		return nil, err
+	}
+	result, err := h.PBRunner.Restart(instance, grace)
+	if err != nil {
+		return nil, err
+	}
+	h.setInstanceStatus(instance.ID, passivbot.StatusRunning, "")
+	return result, nil
+}
+
+// stopSummary describes how a process was stopped
+func stopSummary(result *passivbot.StopResult) gin.H {
+	return gin.H{
+		"graceful":    result.Graceful,
+		"signal":      result.Signal,
+		"exit_code":   result.ExitCode,
+		"duration_ms": result.Duration.Milliseconds(),
+	}
+}
+
+type stopAllRequest struct {
+	instanceSelector
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+	Grace  int    `json:"grace"` // seconds before escalating to SIGTERM
+}
+
+type stopOutcome struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	Stopped    bool   `json:"stopped"`
+	WasRunning bool   `json:"was_running"`
+	Graceful   bool   `json:"graceful"`
+	Signal     string `json:"signal,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// StopAllInstances is the emergency kill switch: it stops every running
+// instance matching the optional exchange, symbol and VPS filters
+// concurrently, and records who triggered it and why in the audit log
+func (h *Handlers) StopAllInstances(c *gin.Context) {
+	var req stopAllRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Grace < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "grace must not be negative"})
+		return
+	}
+
+	instances, err := h.selectInstances(req.instanceSelector)
+	if err == errVPSNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Anything the runner supervises or the database believes is running
+	var targets []models.Instance
+	for _, instance := range instances {
+		_, supervised := h.PBRunner.Supervised(instance.ID)
+		if supervised || instance.Status == passivbot.StatusRunning || instance.Status == passivbot.StatusRestarting {
+			targets = append(targets, instance)
+		}
+	}
+
+	outcomes := make([]stopOutcome, len(targets))
+	var wg sync.WaitGroup
+	for i, instance := range targets {
+		wg.Add(1)
+		go func(i int, instance models.Instance) {
+			defer wg.Done()
+			outcomes[i] = h.stopForStopAll(instance, time.Duration(req.Grace)*time.Second)
+		}(i, instance)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, o := range outcomes {
+		if !o.Stopped {
+			failed++
+		}
+	}
+
+	entry := h.recordAudit(c, "stop_all", req.Actor, req.Reason, gin.H{
+		"filters": req.instanceSelector,
+		"matched": len(targets),
+		"failed":  failed,
+		"results": outcomes,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("Stopped %d of %d instances", len(targets)-failed, len(targets)),
+		"matched":  len(targets),
+		"stopped":  len(targets) - failed,
+		"failed":   failed,
+		"results":  outcomes,
+		"audit_id": entry.ID,
+	})
+}
+
+func (h *Handlers) stopForStopAll(instance models.Instance, grace time.Duration) stopOutcome {
+	outcome := stopOutcome{InstanceID: instance.ID, Name: instance.Name}
+
+	if _, supervised := h.PBRunner.Supervised(instance.ID); supervised {
+		outcome.WasRunning = true
+		result, err := h.PBRunner.Stop(instance.ID, grace)
+		if err != nil {
+			outcome.Error = err.Error()
+			return outcome
+		}
+		outcome.Graceful = result.Graceful
+		outcome.Signal = result.Signal
+		outcome.ExitCode = result.ExitCode
+		outcome.DurationMS = result.Duration.Milliseconds()
+	}
+
+	// Not supervised means the stored status was stale; correct it either way
+	h.DB.Model(&instance).Updates(map[string]interface{}{"status": "stopped", "updated_at": time.Now()})
+	outcome.Stopped = true
+	return outcome
+}
+
+// RecordInstanceState persists status changes reported by the runner, such
+// as crashes, automatic restarts and crash-loop detection
+func (h *Handlers) RecordInstanceState(ev passivbot.StateEvent) {
+	updates := map[string]interface{}{
+		"status":        ev.Status,
+		"restart_count": ev.Restarts,
+		"updated_at":    ev.Time,
+	}
+	if ev.ExitCode != nil {
+		updates["exit_code"] = *ev.ExitCode
+		updates["last_error"] = ev.Error
+		updates["last_exit_at"] = ev.Time
+	}
+
+	if err := h.DB.Model(&models.Instance{}).Where("id = ?", ev.InstanceID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record state of instance %s: %v", ev.InstanceID, err)
+	}
+}
+
+func validateInstance(instance models.Instance) error {
+	if instance.RestartPolicy != "" && !contains(passivbot.RestartPolicies, instance.RestartPolicy) {
+		return fmt.Errorf("invalid restart_policy %q: must be one of %s", instance.RestartPolicy, strings.Join(passivbot.RestartPolicies, ", "))
+	}
+	if instance.MaxRestarts < 0 || instance.RestartWindow < 0 {
+		return fmt.Errorf("max_restarts and restart_window must not be negative")
+	}
+	if instance.CPULimit < 0 || instance.MemoryLimitMB < 0 || instance.MaxOpenFiles < 0 {
+		return fmt.Errorf("cpu_limit, memory_limit_mb and max_open_files must not be negative")
+	}
+	return nil
 }
 
 // Dashboard Handlers
@@ -157,6 +557,8 @@ func (h *Handlers) GetDashboardStats(c *gin.Context) {
 	var totalPNL float64
 	h.DB.Model(&models.Instance{}).Select("COALESCE(SUM(pnl), 0)").Scan(&totalPNL)
 
+	cpuUsage, memoryUsage := h.PBRunner.TotalUsage()
+
 	stats := models.DashboardStats{
 		TotalInstances:   int(totalInstances),
 		RunningInstances: int(runningInstances),
@@ -164,6 +566,9 @@ func (h *Handlers) GetDashboardStats(c *gin.Context) {
 		DailyPNL:         0, // TODO: Calculate daily PNL
 		ActiveJobs:       0, // TODO: Count active jobs
 		SystemUptime:     "24h 30m", // TODO: Calculate real uptime
+		CPUUsage:         cpuUsage,
+		MemoryUsage:      passivbot.MemoryPercent(memoryUsage),
+		MemoryUsageBytes: memoryUsage,
 	}
 
 	c.JSON(http.StatusOK, stats)
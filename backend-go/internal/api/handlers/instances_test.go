@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+)
+
+func TestUpdateInstanceEditableFields(t *testing.T) {
+	s := newTestServer(t)
+	exitCode := 3
+	s.addInstance(t, models.Instance{
+		ID: "a", Name: "old", Exchange: "binance", Symbol: "BTCUSDT", Config: v7Config,
+		Status: "error", ExitCode: &exitCode, RestartCount: 2, LastError: "boom",
+	})
+
+	body := `{"id":"b","name":"new","status":"running","exit_code":0,"restart_count":0,"last_error":"","pnl":99,"max_restarts":5}`
+	var resp instanceUpdate
+	if w := s.do(t, "PUT", "/api/v1/instances/a", body, &resp); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if resp.ID != "a" || resp.RestartRequired || resp.Restarted {
+		t.Errorf("response %+v", resp)
+	}
+
+	got := s.instance(t, "a")
+	if got.Name != "new" || got.MaxRestarts != 5 {
+		t.Errorf("edited fields not saved: name %q, max_restarts %d", got.Name, got.MaxRestarts)
+	}
+	if got.Exchange != "binance" || got.Symbol != "BTCUSDT" || got.Config != v7Config {
+		t.Errorf("fields left out changed: %+v", got)
+	}
+	if got.Status != "error" || got.ExitCode == nil || *got.ExitCode != 3 || got.RestartCount != 2 || got.LastError != "boom" || got.PNL != 0 {
+		t.Errorf("runner fields overwritten: %+v", got)
+	}
+	var count int64
+	s.DB.Model(&models.Instance{}).Where("id = ?", "b").Count(&count)
+	if count != 0 {
+		t.Error("the request created or renamed an instance with its id")
+	}
+
+	// A name change is not a config change
+	if revs := s.revisions(t, "a"); len(revs) != 0 {
+		t.Errorf("%d revisions recorded, want 0", len(revs))
+	}
+}
+
+func TestUpdateInstanceInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not json", `{`},
+		{"wrong type", `{"max_restarts":"many"}`},
+		{"negative limit", `{"memory_limit_mb":-1}`},
+		{"unknown restart policy", `{"restart_policy":"sometimes"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.addInstance(t, models.Instance{ID: "a", Name: "old", Config: v7Config})
+			if w := s.do(t, "PUT", "/api/v1/instances/a", tt.body, nil); w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", w.Code, w.Body)
+			}
+			if got := s.instance(t, "a"); got.Name != "old" || got.MemoryLimitMB != 0 || got.RestartPolicy != passivbot.RestartNever {
+				t.Errorf("rejected update saved: %+v", got)
+			}
+		})
+	}
+
+	s := newTestServer(t)
+	if w := s.do(t, "PUT", "/api/v1/instances/missing", `{"name":"x"}`, nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing instance: status %d, want 404", w.Code)
+	}
+}
+
+func TestUpdateRunningInstance(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "a", Name: "bot", Exchange: "binance", Symbol: "BTCUSDT", Config: v7Config})
+	if w := s.do(t, "POST", "/api/v1/instances/a/start", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("start: status %d: %s", w.Code, w.Body)
+	}
+	first := s.pidInfo(t, "a")
+
+	// Renaming needs no restart
+	var resp instanceUpdate
+	s.do(t, "PUT", "/api/v1/instances/a?apply=true", `{"name":"renamed"}`, &resp)
+	if resp.RestartRequired || resp.Restarted {
+		t.Errorf("rename: restart_required %v, restarted %v", resp.RestartRequired, resp.Restarted)
+	}
+
+	// A config change is saved but only reported without apply
+	changed := strings.Replace(v7Config, `"leverage":5`, `"leverage":7`, 1)
+	resp = instanceUpdate{}
+	s.do(t, "PUT", "/api/v1/instances/a", map[string]string{"config": changed}, &resp)
+	if !resp.RestartRequired || resp.Restarted {
+		t.Errorf("without apply: restart_required %v, restarted %v", resp.RestartRequired, resp.Restarted)
+	}
+	if info := s.pidInfo(t, "a"); info.PID != first.PID {
+		t.Error("the process was restarted without apply")
+	}
+
+	// With apply the bot restarts on the rewritten config
+	changed = strings.Replace(v7Config, `"leverage":5`, `"leverage":9`, 1)
+	resp = instanceUpdate{}
+	if w := s.do(t, "PUT", "/api/v1/instances/a?apply=true", map[string]string{"config": changed}, &resp); w.Code != http.StatusOK {
+		t.Fatalf("apply: status %d: %s", w.Code, w.Body)
+	}
+	if resp.RestartRequired || !resp.Restarted || resp.Status != passivbot.StatusRunning {
+		t.Errorf("with apply: restart_required %v, restarted %v, status %s", resp.RestartRequired, resp.Restarted, resp.Status)
+	}
+	info := s.pidInfo(t, "a")
+	if info.PID == first.PID {
+		t.Error("the process was not restarted")
+	}
+	data, err := os.ReadFile(info.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"leverage": 9`) {
+		t.Errorf("config file not rewritten:\n%s", data)
+	}
+	if got := s.instance(t, "a"); got.Name != "renamed" || got.Config != changed {
+		t.Errorf("saved %q with config %s", got.Name, got.Config)
+	}
+}
+
+func TestStopAllBySymbol(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "single", Symbol: "SOLUSDT", Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "multi", Config: v7Config, Symbols: []models.InstanceSymbol{
+		{ID: "s1", Symbol: "BTC", Long: true}, {ID: "s2", Symbol: "SOL", Long: true},
+	}})
+	s.addInstance(t, models.Instance{ID: "other", Symbol: "ETHUSDT", Config: v7Config})
+	for _, id := range []string{"single", "multi", "other"} {
+		if w := s.do(t, "POST", "/api/v1/instances/"+id+"/start", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("start %s: status %d: %s", id, w.Code, w.Body)
+		}
+	}
+
+	var resp struct {
+		Matched int           `json:"matched"`
+		Stopped int           `json:"stopped"`
+		Results []stopOutcome `json:"results"`
+	}
+	if w := s.do(t, "POST", "/api/v1/instances/stop-all", `{"symbol":"SOL/USDT:USDT","reason":"test"}`, &resp); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if resp.Matched != 2 || resp.Stopped != 2 {
+		t.Errorf("matched %d, stopped %d, want 2: %+v", resp.Matched, resp.Stopped, resp.Results)
+	}
+	for id, want := range map[string]bool{"single": false, "multi": false, "other": true} {
+		if _, running := s.PBRunner.Supervised(id); running != want {
+			t.Errorf("%s running = %v, want %v", id, running, want)
+		}
+	}
+}
+
+// The kill switch stops everything at once: bots ignoring SIGINT are
+// escalated, pending restarts cancelled and stale statuses corrected
+func TestStopAllEscalates(t *testing.T) {
+	s := newTestServer(t)
+	script := `case "$2" in
+*/stubborn/*) trap '' INT; trap 'exit 0' TERM ;;
+*/crashing/*) exit 1 ;;
+*) trap 'exit 0' INT TERM ;;
+esac
+while :; do sleep 0.05; done
+`
+	if err := os.WriteFile(filepath.Join(s.dir, "passivbot", "main.py"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.addInstance(t, models.Instance{ID: "polite", Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "stubborn", Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "crashing", Config: v7Config, RestartPolicy: passivbot.RestartAlways})
+	s.addInstance(t, models.Instance{ID: "stale", Status: passivbot.StatusRunning, Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "idle", Config: v7Config})
+	var procs []passivbot.PIDInfo
+	for _, id := range []string{"polite", "stubborn", "crashing"} {
+		if w := s.do(t, "POST", "/api/v1/instances/"+id+"/start", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("start %s: %d %s", id, w.Code, w.Body)
+		}
+		if id != "crashing" {
+			procs = append(procs, s.pidInfo(t, id))
+		}
+	}
+	for i := 0; i < 200 && s.instance(t, "crashing").Status != passivbot.StatusRestarting; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var resp struct {
+		Matched int           `json:"matched"`
+		Stopped int           `json:"stopped"`
+		Results []stopOutcome `json:"results"`
+		AuditID string        `json:"audit_id"`
+	}
+	body := `{"grace":1,"actor":"ops","reason":"exchange outage"}`
+	if w := s.do(t, "POST", "/api/v1/instances/stop-all", body, &resp); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if resp.Matched != 4 || resp.Stopped != 4 {
+		t.Errorf("matched %d, stopped %d, want 4", resp.Matched, resp.Stopped)
+	}
+	want := map[string]stopOutcome{
+		"polite":   {Stopped: true, WasRunning: true, Graceful: true, Signal: "interrupt"},
+		"stubborn": {Stopped: true, WasRunning: true, Signal: "terminated"},
+		"crashing": {Stopped: true, WasRunning: true, Graceful: true},
+		"stale":    {Stopped: true},
+	}
+	for _, o := range resp.Results {
+		w := want[o.InstanceID]
+		if o.Stopped != w.Stopped || o.WasRunning != w.WasRunning || o.Graceful != w.Graceful || o.Signal != w.Signal || o.Error != "" {
+			t.Errorf("%s: %+v, want %+v", o.InstanceID, o, w)
+		}
+	}
+
+	for _, p := range procs {
+		if p.Alive() {
+			t.Errorf("%s (pid %d) still running", p.InstanceID, p.PID)
+		}
+	}
+	for _, id := range []string{"polite", "stubborn", "crashing", "stale", "idle"} {
+		if got := s.instance(t, id); got.Status != passivbot.StatusStopped {
+			t.Errorf("%s is %s, want stopped", id, got.Status)
+		}
+		if _, ok := s.PBRunner.Supervised(id); ok {
+			t.Errorf("%s still supervised", id)
+		}
+	}
+
+	var entry models.AuditLog
+	if err := s.DB.First(&entry, "id = ?", resp.AuditID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entry.Action != "stop_all" || entry.Actor != "ops" || entry.Reason != "exchange outage" || !strings.Contains(entry.Details, `"signal":"terminated"`) {
+		t.Errorf("audit entry %+v", entry)
+	}
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+)
+
+const defaultLogTail = 200
+
+// GetInstanceLogs returns persisted log lines for an instance.
+// Query params: tail, limit, since, until (RFC3339), and comma-separated
+// level, symbol and event filters.
+func (h *Handlers) GetInstanceLogs(c *gin.Context) {
+	id := c.Param("id")
+	var instance models.Instance
+
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	q, err := parseLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines, err := h.PBRunner.GetLogs(id, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"instance_id": id,
+		"lines":       lines,
+	})
+}
+
+const (
+	defaultSearchPageSize = 50
+	maxSearchPageSize     = 500
+	maxSearchContext      = 20
+)
+
+// SearchLogs searches persisted logs across instances.
+// Query params: q (required), regex, case_sensitive, instance_id
+// (comma-separated, default all), since, until, level, symbol, event,
+// context, page, page_size.
+func (h *Handlers) SearchLogs(c *gin.Context) {
+	q, page, pageSize, err := parseSearchQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.PBRunner.SearchLogs(q)
+	if err != nil {
+		if errors.Is(err, passivbot.ErrInvalidInstanceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":     result.Total,
+		"page":      page,
+		"page_size": pageSize,
+		"matches":   result.Matches,
+	})
+}
+
+// DownloadInstanceLogs streams an instance's logs for a time window along
+// with its rendered config, for attaching to bug reports.
+// Query params: since, until (RFC3339), format (text, gzip or zip).
+func (h *Handlers) DownloadInstanceLogs(c *gin.Context) {
+	id := c.Param("id")
+	var instance models.Instance
+
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	q, err := parseExportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := exportName(id)
+	switch format := c.DefaultQuery("format", "text"); format {
+	case "text":
+		setAttachment(c, "text/plain; charset=utf-8", name+".txt")
+		err = h.writeLogBundle(c.Writer, instance, q)
+	case "gzip":
+		setAttachment(c, "application/gzip", name+".txt.gz")
+		gz := gzip.NewWriter(c.Writer)
+		err = h.writeLogBundle(gz, instance, q)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	case "zip":
+		setAttachment(c, "application/zip", name+".zip")
+		err = h.writeLogZip(c.Writer, []models.Instance{instance}, q)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format: must be text, gzip or zip"})
+		return
+	}
+
+	// Headers are already sent, so failures can only be logged
+	if err != nil {
+		log.Printf("Failed to export logs for instance %s: %v", id, err)
+	}
+}
+
+// DownloadLogs streams a zip with the logs and rendered configs of several
+// instances. Query params: instance_id (comma-separated, default all),
+// since, until. Instances deleted from the database are included by ID.
+func (h *Handlers) DownloadLogs(c *gin.Context) {
+	q, err := parseExportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var instances []models.Instance
+	ids := splitParam(c, "instance_id")
+	if len(ids) == 0 {
+		err = h.DB.Find(&instances).Error
+	} else {
+		err = h.DB.Where("id IN ?", ids).Find(&instances).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	found := make(map[string]bool)
+	for _, instance := range instances {
+		found[instance.ID] = true
+	}
+	for _, id := range ids {
+		if found[id] {
+			continue
+		}
+		if !h.PBRunner.HasLogs(id) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No instance or logs found for " + id})
+			return
+		}
+		instances = append(instances, models.Instance{ID: id})
+	}
+
+	setAttachment(c, "application/zip", exportName("instances")+".zip")
+	if err := h.writeLogZip(c.Writer, instances, q); err != nil {
+		log.Printf("Failed to export logs: %v", err)
+	}
+}
+
+// writeLogBundle writes a plain-text bundle: a header, the rendered config
+// and then the log lines
+func (h *Handlers) writeLogBundle(w io.Writer, instance models.Instance, q passivbot.LogQuery) error {
+	path, config, err := h.PBRunner.RenderedConfig(instance.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "# pbgui log export\n")
+	fmt.Fprintf(w, "# instance: %s (%s)\n", instance.ID, instance.Name)
+	fmt.Fprintf(w, "# exchange: %s, symbol: %s\n", instance.Exchange, instance.Symbol)
+	fmt.Fprintf(w, "# range: %s - %s\n", formatBound(q.Since), formatBound(q.Until))
+	fmt.Fprintf(w, "# exported at: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if config != nil {
+		fmt.Fprintf(w, "#\n# ---- config: %s ----\n%s\n", path, config)
+	} else {
+		fmt.Fprintf(w, "#\n# ---- config: not rendered yet ----\n")
+	}
+	fmt.Fprintf(w, "# ---- logs ----\n")
+
+	return h.PBRunner.ExportLogs(instance.ID, q, w)
+}
+
+// writeLogZip writes <id>/instance.json, <id>/config.json and
+// <id>/passivbot.log for each instance
+func (h *Handlers) writeLogZip(w io.Writer, instances []models.Instance, q passivbot.LogQuery) error {
+	zw := zip.NewWriter(w)
+
+	for _, instance := range instances {
+		meta, _ := json.MarshalIndent(instance, "", "  ")
+		if err := writeZipFile(zw, instance.ID+"/instance.json", meta); err != nil {
+			return err
+		}
+
+		_, config, err := h.PBRunner.RenderedConfig(instance.ID)
+		if err != nil {
+			return err
+		}
+		if config != nil {
+			if err := writeZipFile(zw, instance.ID+"/config.json", config); err != nil {
+				return err
+			}
+		}
+
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: instance.ID + "/passivbot.log", Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := h.PBRunner.ExportLogs(instance.ID, q, f); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func parseExportQuery(c *gin.Context) (passivbot.LogQuery, error) {
+	var q passivbot.LogQuery
+	var err error
+
+	if q.Since, err = parseTimeParam(c, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseTimeParam(c, "until"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func setAttachment(c *gin.Context, contentType, filename string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+}
+
+func exportName(prefix string) string {
+	return fmt.Sprintf("%s-logs-%s", prefix, time.Now().UTC().Format("20060102T150405Z"))
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return "*"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseSearchQuery(c *gin.Context) (q passivbot.SearchQuery, page, pageSize int, err error) {
+	q = passivbot.SearchQuery{
+		Pattern:       c.Query("q"),
+		Regex:         c.Query("regex") == "true",
+		CaseSensitive: c.Query("case_sensitive") == "true",
+		InstanceIDs:   splitParam(c, "instance_id"),
+	}
+	if q.Pattern == "" {
+		return q, 0, 0, errors.New("q is required")
+	}
+	if q.Regex {
+		if _, err = regexp.Compile(q.Pattern); err != nil {
+			return q, 0, 0, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+
+	if q.Since, err = parseTimeParam(c, "since"); err != nil {
+		return
+	}
+	if q.Until, err = parseTimeParam(c, "until"); err != nil {
+		return
+	}
+	if q.Filter, err = ParseLogFilter(c); err != nil {
+		return
+	}
+	if q.Context, err = parseIntParam(c, "context"); err != nil {
+		return
+	}
+	if page, err = parseIntParam(c, "page"); err != nil {
+		return
+	}
+	if pageSize, err = parseIntParam(c, "page_size"); err != nil {
+		return
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+	if q.Context > maxSearchContext {
+		q.Context = maxSearchContext
+	}
+	q.Offset = (page - 1) * pageSize
+	q.Limit = pageSize
+	return q, page, pageSize, nil
+}
+
+func parseLogQuery(c *gin.Context) (passivbot.LogQuery, error) {
+	var q passivbot.LogQuery
+	var err error
+
+	if q.Since, err = parseTimeParam(c, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseTimeParam(c, "until"); err != nil {
+		return q, err
+	}
+	if q.Tail, err = parseIntParam(c, "tail"); err != nil {
+		return q, err
+	}
+	if q.Limit, err = parseIntParam(c, "limit"); err != nil {
+		return q, err
+	}
+	if q.Filter, err = ParseLogFilter(c); err != nil {
+		return q, err
+	}
+
+	// Without any bounds, default to the most recent lines
+	if q.Tail == 0 && q.Limit == 0 && q.Since.IsZero() {
+		q.Tail = defaultLogTail
+	}
+	return q, nil
+}
+
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: must be an RFC3339 timestamp", name)
+	}
+	return t, nil
+}
+
+func parseIntParam(c *gin.Context, name string) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// ParseLogFilter reads the level, symbol and event query params
+func ParseLogFilter(c *gin.Context) (passivbot.LogFilter, error) {
+	f := passivbot.LogFilter{
+		Levels:  splitParam(c, "level"),
+		Symbols: splitParam(c, "symbol"),
+		Events:  splitParam(c, "event"),
+	}
+	for i, level := range f.Levels {
+		f.Levels[i] = strings.ToUpper(level)
+		if f.Levels[i] == "WARN" {
+			f.Levels[i] = passivbot.LevelWarning
+		}
+		if !contains(passivbot.LogLevels, f.Levels[i]) {
+			return f, fmt.Errorf("invalid level %q: must be one of %s", level, strings.Join(passivbot.LogLevels, ", "))
+		}
+	}
+	for _, event := range f.Events {
+		if !contains(passivbot.LogEvents, event) {
+			return f, fmt.Errorf("invalid event %q: must be one of %s", event, strings.Join(passivbot.LogEvents, ", "))
+		}
+	}
+	return f, nil
+}
+
+// splitParam returns the non-empty values of a comma-separated query param
+func splitParam(c *gin.Context, name string) []string {
+	var values []string
+	for _, v := range strings.Split(c.Query(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
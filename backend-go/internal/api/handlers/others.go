@@ -202,8 +202,8 @@ func (h *Handlers) GetPaths(c *gin.Context) {
 	paths := map[string]string{
 		"passivbot_path": h.Config.PassivbotPath,
 		"python_path":    h.Config.PythonPath,
-		"data_directory": "/opt/pbgui/data",
-		"logs_directory": "/opt/pbgui/logs",
+		"data_directory": h.Config.DataDir,
+		"logs_directory": h.Config.LogsDir,
 	}
 
 	c.JSON(http.StatusOK, paths)
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+)
+
+// ReconcileReport summarizes one pass comparing stored instance state with
+// the processes actually running
+type ReconcileReport struct {
+	Adopted   []string            `json:"adopted"`   // live processes taken over from an earlier backend run
+	Restarted []string            `json:"restarted"` // meant to be running, started again
+	Lost      []string            `json:"lost"`      // meant to be running, no process found
+	Corrected []string            `json:"corrected"` // stored status fixed to match the runner
+	Orphans   []passivbot.PIDInfo `json:"orphans"`   // live processes with no instance record
+	Time      time.Time           `json:"time"`
+}
+
+// ReconcileInstances brings stored instance statuses in line with reality.
+// Live processes recorded in PID files are re-adopted; instances stored as
+// running without a process are restarted when RECONCILE_RESTART is set or
+// their restart policy allows it, and marked as errored otherwise.
+func (h *Handlers) ReconcileInstances() (*ReconcileReport, error) {
+	var instances []models.Instance
+	if err := h.DB.Find(&instances).Error; err != nil {
+		return nil, err
+	}
+	pidFiles, err := h.PBRunner.PIDFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Adopted:   []string{},
+		Restarted: []string{},
+		Lost:      []string{},
+		Corrected: []string{},
+		Orphans:   []passivbot.PIDInfo{},
+		Time:      time.Now(),
+	}
+	for _, instance := range instances {
+		_, hasPID := pidFiles[instance.ID]
+		delete(pidFiles, instance.ID)
+
+		if status, ok := h.PBRunner.Supervised(instance.ID); ok {
+			if instance.Status != status {
+				h.setInstanceStatus(instance.ID, status, "")
+				report.Corrected = append(report.Corrected, instance.ID)
+			}
+			continue
+		}
+
+		// Adopted processes may be restarted later, which renders the config
+		// and writes the API keys again
+		wanted := instance.Status == passivbot.StatusRunning || instance.Status == passivbot.StatusRestarting
+		if hasPID || wanted {
+			if err := h.prepareStart(&instance); err != nil {
+				log.Printf("Reconcile: instance %s: %v", instance.ID, err)
+			}
+		}
+		adopted, err := h.PBRunner.Adopt(instance)
+		if err != nil {
+			log.Printf("Reconcile: failed to adopt instance %s: %v", instance.ID, err)
+		}
+		if adopted {
+			h.setInstanceStatus(instance.ID, passivbot.StatusRunning, "")
+			report.Adopted = append(report.Adopted, instance.ID)
+			continue
+		}
+
+		if !wanted {
+			continue
+		}
+		// The status read above may be stale by now: the instance may have
+		// been stopped, started or crashed since. The runner re-checks it
+		// while no start, stop or exit can interleave.
+		id := instance.ID
+		if h.Config.ReconcileRestart || instance.RestartPolicy == passivbot.RestartAlways || instance.RestartPolicy == passivbot.RestartOnFailure {
+			started, err := h.PBRunner.Resume(instance, func() bool { return h.stillWanted(id) })
+			if started {
+				report.Restarted = append(report.Restarted, id)
+				continue
+			}
+			if err == nil {
+				continue
+			}
+			log.Printf("Reconcile: failed to restart instance %s: %v", id, err)
+		}
+		lost := false
+		h.PBRunner.IfIdle(id, func() {
+			lost = h.markLost(id)
+		})
+		if lost {
+			report.Lost = append(report.Lost, id)
+		}
+	}
+
+	// Whatever is left has no instance record
+	for id, info := range pidFiles {
+		if !info.Alive() {
+			h.PBRunner.RemoveStalePIDFile(id)
+			continue
+		}
+		log.Printf("Reconcile: orphaned passivbot process %d for unknown instance %s", info.PID, id)
+		report.Orphans = append(report.Orphans, info)
+	}
+
+	if n := len(report.Adopted) + len(report.Restarted) + len(report.Lost) + len(report.Corrected); n > 0 {
+		log.Printf("Reconcile: %d adopted, %d restarted, %d lost, %d corrected, %d orphans",
+			len(report.Adopted), len(report.Restarted), len(report.Lost), len(report.Corrected), len(report.Orphans))
+	}
+	return report, nil
+}
+
+// RunReconciler reconciles on every interval; it never returns
+func (h *Handlers) RunReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := h.ReconcileInstances(); err != nil {
+			log.Printf("Reconcile failed: %v", err)
+		}
+	}
+}
+
+// stillWanted re-reads whether an instance is meant to be running
+func (h *Handlers) stillWanted(id string) bool {
+	var instance models.Instance
+	if err := h.DB.Select("status").First(&instance, "id = ?", id).Error; err != nil {
+		return false
+	}
+	return instance.Status == passivbot.StatusRunning || instance.Status == passivbot.StatusRestarting
+}
+
+// markLost puts an instance meant to be running whose process is gone in
+// error, unless its status changed meanwhile
+func (h *Handlers) markLost(id string) bool {
+	result := h.DB.Model(&models.Instance{}).
+		Where("id = ? AND status IN ?", id, []string{passivbot.StatusRunning, passivbot.StatusRestarting}).
+		Updates(map[string]interface{}{
+			"status":     passivbot.StatusError,
+			"last_error": "process not found; it exited while the backend was not supervising it",
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		log.Printf("Failed to update status of instance %s: %v", id, result.Error)
+	}
+	return result.RowsAffected > 0
+}
+
+func (h *Handlers) setInstanceStatus(id, status, lastError string) {
+	updates := map[string]interface{}{"status": status, "updated_at": time.Now()}
+	if lastError != "" {
+		updates["last_error"] = lastError
+	}
+	if err := h.DB.Model(&models.Instance{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update status of instance %s: %v", id, err)
+	}
+}
+
+// Reconcile runs a reconciliation pass on demand and returns its report
+func (h *Handlers) Reconcile(c *gin.Context) {
+	report, err := h.ReconcileInstances()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
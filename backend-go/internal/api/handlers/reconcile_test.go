@@ -0,0 +1,188 @@
+//go:build unix
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+)
+
+// startOrphan starts the fake passivbot as an earlier run of the backend
+// would have left it: in its own session, writing to its spool files and
+// recorded in a PID file
+func (s *testServer) startOrphan(t *testing.T, id string) passivbot.PIDInfo {
+	t.Helper()
+	runDir := filepath.Join(s.dir, "run")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(s.dir, id+".json")
+	if err := os.WriteFile(configPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.Create(filepath.Join(runDir, id+".stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	cmd := exec.Command("/bin/sh", filepath.Join(s.dir, "passivbot", "main.py"), "--config", configPath)
+	cmd.Stdout, cmd.Stderr = stdout, stdout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go cmd.Wait()
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+
+	info := passivbot.PIDInfo{InstanceID: id, PID: cmd.Process.Pid, StartedAt: time.Now(), ConfigPath: configPath, Command: cmd.Args}
+	data, _ := json.Marshal(info)
+	if err := os.WriteFile(filepath.Join(runDir, id+".pid"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestReconcileInstances(t *testing.T) {
+	s := newTestServer(t)
+	running := passivbot.StatusRunning
+	s.addInstance(t, models.Instance{ID: "lost", Status: running, Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "resumed", Status: passivbot.StatusRestarting, RestartPolicy: passivbot.RestartOnFailure, Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "stopped", Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "adopted", Status: running, Config: v7Config})
+	s.addInstance(t, models.Instance{ID: "corrected", Config: v7Config})
+	if w := s.do(t, "POST", "/api/v1/instances/corrected/start", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("start: %d %s", w.Code, w.Body)
+	}
+	s.DB.Model(&models.Instance{}).Where("id = ?", "corrected").Update("status", passivbot.StatusError)
+
+	adopted := s.startOrphan(t, "adopted")
+	orphan := s.startOrphan(t, "ghost")
+	gone := s.startOrphan(t, "gone")
+	syscall.Kill(-gone.PID, syscall.SIGKILL)
+	for i := 0; i < 100 && gone.Alive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	report, err := s.ReconcileInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := []struct {
+		name      string
+		got, want []string
+	}{
+		{"adopted", report.Adopted, []string{"adopted"}},
+		{"restarted", report.Restarted, []string{"resumed"}},
+		{"lost", report.Lost, []string{"lost"}},
+		{"corrected", report.Corrected, []string{"corrected"}},
+	}
+	for _, l := range lists {
+		sort.Strings(l.got)
+		if !equalStrings(l.got, l.want) {
+			t.Errorf("%s = %q, want %q", l.name, l.got, l.want)
+		}
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].PID != orphan.PID {
+		t.Errorf("orphans = %+v, want ghost (pid %d)", report.Orphans, orphan.PID)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "run", "gone.pid")); !os.IsNotExist(err) {
+		t.Errorf("PID file of an exited unknown process kept: %v", err)
+	}
+
+	wantStatus := map[string]string{
+		"lost":      passivbot.StatusError,
+		"resumed":   running,
+		"stopped":   passivbot.StatusStopped,
+		"adopted":   running,
+		"corrected": running,
+	}
+	for id, want := range wantStatus {
+		got := s.instance(t, id)
+		if got.Status != want {
+			t.Errorf("%s: status %s, want %s", id, got.Status, want)
+		}
+		_, supervised := s.PBRunner.Supervised(id)
+		if supervised != (want == running) {
+			t.Errorf("%s: supervised %v with status %s", id, supervised, want)
+		}
+	}
+	if got := s.instance(t, "lost"); !strings.Contains(got.LastError, "process not found") {
+		t.Errorf("lost instance error %q", got.LastError)
+	}
+
+	// The runner supervises the process left behind, not a new one
+	if info := s.pidInfo(t, "adopted"); info.PID != adopted.PID {
+		t.Errorf("adopted pid %d, want %d", info.PID, adopted.PID)
+	}
+
+	// A second pass finds nothing to do
+	report, err = s.ReconcileInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(report.Adopted) + len(report.Restarted) + len(report.Lost) + len(report.Corrected); n != 0 {
+		t.Errorf("second pass changed %d instances: %+v", n, report)
+	}
+
+	var stopped struct {
+		Graceful bool `json:"graceful"`
+	}
+	if w := s.do(t, "POST", "/api/v1/instances/adopted/stop", nil, &stopped); w.Code != http.StatusOK {
+		t.Fatalf("stop adopted: %d %s", w.Code, w.Body)
+	}
+	if adopted.Alive() {
+		t.Error("adopted process still running after stop")
+	}
+	if !stopped.Graceful {
+		t.Error("adopted process did not stop on SIGINT")
+	}
+}
+
+func TestReconcileRestartSetting(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "a", Status: passivbot.StatusRunning, Config: v7Config})
+
+	s.Config.ReconcileRestart = true
+	report, err := s.ReconcileInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(report.Restarted, []string{"a"}) || len(report.Lost) != 0 {
+		t.Errorf("restarted %q, lost %q; want a restarted", report.Restarted, report.Lost)
+	}
+	if _, ok := s.PBRunner.Supervised("a"); !ok {
+		t.Error("not running after reconcile")
+	}
+}
+
+func TestMarkLost(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "running", Status: passivbot.StatusRunning})
+	s.addInstance(t, models.Instance{ID: "stopped"})
+
+	if !s.markLost("running") {
+		t.Error("running instance not marked lost")
+	}
+	if got := s.instance(t, "running"); got.Status != passivbot.StatusError {
+		t.Errorf("status %s, want error", got.Status)
+	}
+	// Stopped meanwhile: left alone
+	if s.markLost("stopped") {
+		t.Error("stopped instance marked lost")
+	}
+	if got := s.instance(t, "stopped"); got.Status != passivbot.StatusStopped || got.LastError != "" {
+		t.Errorf("stopped instance changed: %s %q", got.Status, got.LastError)
+	}
+}
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+// revisionMeta says who changed a config and why. It is sent alongside the
+// instance fields in create and update requests.
+type revisionMeta struct {
+	Author  string `json:"author"`
+	Message string `json:"message"`
+}
+
+// bindRevisionMeta reads the author and message from a JSON body that was
+// bound with ShouldBindBodyWith. The author defaults to the client address.
+func bindRevisionMeta(c *gin.Context, defaultMessage string) revisionMeta {
+	var meta revisionMeta
+	c.ShouldBindBodyWith(&meta, binding.JSON)
+	if meta.Author == "" {
+		meta.Author = c.ClientIP()
+	}
+	if meta.Message == "" {
+		meta.Message = defaultMessage
+	}
+	return meta
+}
+
+// Attempts at numbering a revision when concurrent updates take the same
+// number
+const revisionAttempts = 5
+
+// configSourcesChanged reports whether an update changes what the
+// instance's config is rendered from
+func configSourcesChanged(before, after models.Instance) bool {
+	return before.Config != after.Config || before.TemplateID != after.TemplateID || before.Overrides != after.Overrides
+}
+
+// recordRevision appends the next revision of an instance's config: its own
+// config, or the template and overrides it is rendered from, its symbols
+// and the config they render. The template and symbols must be attached.
+// A config that cannot be rendered is recorded with an empty Rendered.
+func recordRevision(tx *gorm.DB, instance models.Instance, meta revisionMeta) (*models.ConfigRevision, error) {
+	rendered, err := pbconfig.Render(instance)
+	if err != nil {
+		rendered = ""
+	}
+	symbols := []models.RevisionSymbol{}
+	for _, s := range instance.Symbols {
+		symbols = append(symbols, models.RevisionSymbol{Symbol: s.Symbol, Long: s.Long, Short: s.Short, Overrides: s.Overrides})
+	}
+	rev := &models.ConfigRevision{
+		InstanceID: instance.ID,
+		Config:     instance.Config,
+		TemplateID: instance.TemplateID,
+		Overrides:  instance.Overrides,
+		Symbols:    symbols,
+		Rendered:   rendered,
+		Author:     meta.Author,
+		Message:    meta.Message,
+		CreatedAt:  time.Now(),
+	}
+
+	// Numbers are MAX+1, so a concurrent update may take the same one
+	// first; the unique index rejects the loser, which tries again
+	for attempt := 1; ; attempt++ {
+		var last int
+		err := tx.Model(&models.ConfigRevision{}).
+			Where("instance_id = ?", instance.ID).
+			Select("COALESCE(MAX(revision), 0)").
+			Scan(&last).Error
+		if err != nil {
+			return nil, err
+		}
+		rev.ID = uuid.New().String()
+		rev.Revision = last + 1
+
+		if err := tx.SavePoint("revision").Error; err != nil {
+			return nil, err
+		}
+		err = tx.Create(rev).Error
+		if err == nil {
+			return rev, nil
+		}
+		if !isUniqueViolation(err) || attempt == revisionAttempts {
+			return nil, err
+		}
+		if err := tx.RollbackTo("revision").Error; err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isUniqueViolation reports whether err is a unique index conflict
+func isUniqueViolation(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// recordConfigChange records a config update. Instances created before
+// revisions were tracked first get their previous config as a baseline.
+// Both states must have their template and symbols attached.
+func recordConfigChange(tx *gorm.DB, before, after models.Instance, meta revisionMeta) (*models.ConfigRevision, error) {
+	var count int64
+	if err := tx.Model(&models.ConfigRevision{}).Where("instance_id = ?", after.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		baseline := revisionMeta{Author: "system", Message: "Config before revision tracking"}
+		if _, err := recordRevision(tx, before, baseline); err != nil {
+			return nil, err
+		}
+	}
+	return recordRevision(tx, after, meta)
+}
+
+// ListConfigRevisions lists an instance's config revisions, newest first
+func (h *Handlers) ListConfigRevisions(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.Instance{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	var revisions []models.ConfigRevision
+	if err := h.DB.Where("instance_id = ?", id).Order("revision DESC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GetConfigRevision returns one revision of an instance's config
+func (h *Handlers) GetConfigRevision(c *gin.Context) {
+	n, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision"})
+		return
+	}
+	rev, err := h.findRevision(c.Param("id"), n)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
+
+// DiffConfigRevisions compares the rendered configs of two revisions
+// structurally (`from`, `to`). `to` defaults to the latest revision and
+// `from` to the one before it.
+func (h *Handlers) DiffConfigRevisions(c *gin.Context) {
+	id := c.Param("id")
+	to, err := parseIntParam(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, err := parseIntParam(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if to == 0 {
+		h.DB.Model(&models.ConfigRevision{}).Where("instance_id = ?", id).Select("COALESCE(MAX(revision), 0)").Scan(&to)
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	toRev, err := h.findRevision(id, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	fromRev, err := h.findRevision(id, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	changes, err := pbconfig.Diff(revisionConfig(fromRev), revisionConfig(toRev))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{
+		"instance_id": id,
+		"from":        from,
+		"to":          to,
+		"changes":     changes,
+	}
+	// Template-based revisions differ in their template and overrides
+	if fromRev.TemplateID != toRev.TemplateID {
+		resp["template_id"] = gin.H{"from": fromRev.TemplateID, "to": toRev.TemplateID}
+	}
+	if fromRev.Overrides != "" || toRev.Overrides != "" {
+		overrideChanges, err := pbconfig.Diff(fromRev.Overrides, toRev.Overrides)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		resp["overrides_changes"] = overrideChanges
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type rollbackRequest struct {
+	Revision int    `json:"revision" binding:"required"`
+	Restart  bool   `json:"restart"` // restart a running instance with the restored config
+	Author   string `json:"author"`
+	Message  string `json:"message"`
+}
+
+// RollbackConfig restores the config an instance ran with at a previous
+// revision, including its template, overrides and symbols. If the template
+// has changed since, the instance is detached from it and runs the
+// revision's rendered config as its own. The rollback is itself recorded
+// as a new revision, so history is never rewritten.
+func (h *Handlers) RollbackConfig(c *gin.Context) {
+	id := c.Param("id")
+	var instance models.Instance
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+	if err := h.attachConfigSources(&instance); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req rollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	target, err := h.findRevision(id, req.Revision)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check the config the instance would actually run with
+	before := instance
+	detached, err := h.restoreRevision(&instance, target)
+	if err != nil {
+		respondConfigError(c, err)
+		return
+	}
+	rendered, err := pbconfig.Render(instance)
+	if err == nil {
+		err = pbconfig.Validate(rendered)
+	}
+	if err != nil {
+		respondConfigError(c, err)
+		return
+	}
+
+	meta := revisionMeta{Author: req.Author, Message: req.Message}
+	if meta.Author == "" {
+		meta.Author = c.ClientIP()
+	}
+	if meta.Message == "" {
+		meta.Message = fmt.Sprintf("Rollback to revision %d", target.Revision)
+	}
+
+	instance.UpdatedAt = time.Now()
+	var rev *models.ConfigRevision
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"config":      instance.Config,
+			"template_id": instance.TemplateID,
+			"overrides":   instance.Overrides,
+			"updated_at":  instance.UpdatedAt,
+		}
+		if err := tx.Model(&models.Instance{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+		if target.Symbols != nil {
+			if err := replaceSymbols(tx, instance.ID, instance.Symbols); err != nil {
+				return err
+			}
+		}
+		rev, err = recordConfigChange(tx, before, instance, meta)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	restarted := false
+	if _, running := h.PBRunner.Supervised(id); running && req.Restart {
+		if _, err := h.restartInstance(instance, 0); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("rolled back, but restart failed: %v", err)})
+			return
+		}
+		restarted = true
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   fmt.Sprintf("Rolled back to revision %d", target.Revision),
+		"revision":  rev,
+		"detached":  detached,
+		"restarted": restarted,
+	})
+}
+
+// restoreRevision sets an instance's config sources to a revision's. A
+// revision with a snapshot also restores the symbols; if its template no
+// longer renders the snapshot, the instance is detached from the template
+// and takes the snapshot as its own config, which the symbols render to
+// unchanged. It reports whether the instance was detached.
+func (h *Handlers) restoreRevision(instance *models.Instance, rev *models.ConfigRevision) (bool, error) {
+	instance.Config = rev.Config
+	instance.TemplateID = rev.TemplateID
+	instance.Overrides = rev.Overrides
+	if rev.Symbols == nil {
+		// Recorded before revisions kept a snapshot; symbols stay as they are
+		return false, h.attachConfigSources(instance)
+	}
+
+	instance.Symbols = make([]models.InstanceSymbol, len(rev.Symbols))
+	for i, s := range rev.Symbols {
+		instance.Symbols[i] = models.InstanceSymbol{
+			ID:         uuid.New().String(),
+			InstanceID: instance.ID,
+			Symbol:     s.Symbol,
+			Long:       s.Long,
+			Short:      s.Short,
+			Overrides:  s.Overrides,
+			CreatedAt:  time.Now(),
+		}
+	}
+	instance.Template = nil
+	if instance.TemplateID != "" {
+		var template models.ConfigTemplate
+		if err := h.DB.First(&template, "id = ?", instance.TemplateID).Error; err == nil {
+			instance.Template = &template
+		}
+	}
+	if rendered, err := pbconfig.Render(*instance); err == nil && sameConfig(rendered, rev.Rendered) {
+		return false, nil
+	}
+	if instance.TemplateID == "" || rev.Rendered == "" {
+		return false, nil
+	}
+	instance.Config = rev.Rendered
+	instance.TemplateID, instance.Overrides, instance.Template = "", "", nil
+	return true, nil
+}
+
+// replaceSymbols stores symbols as an instance's complete symbol list
+func replaceSymbols(tx *gorm.DB, instanceID string, symbols []models.InstanceSymbol) error {
+	if err := tx.Delete(&models.InstanceSymbol{}, "instance_id = ?", instanceID).Error; err != nil {
+		return err
+	}
+	if len(symbols) == 0 {
+		return nil
+	}
+	return tx.Create(&symbols).Error
+}
+
+// revisionConfig is the config a revision ran with. Revisions recorded
+// before snapshots were kept only have the instance's own config.
+func revisionConfig(rev *models.ConfigRevision) string {
+	if rev.Symbols == nil {
+		return rev.Config
+	}
+	return rev.Rendered
+}
+
+// sameConfig reports whether two configs are structurally equal
+func sameConfig(a, b string) bool {
+	changes, err := pbconfig.Diff(a, b)
+	return err == nil && len(changes) == 0
+}
+
+// deleteInstanceRecord removes an instance together with its config history
+// and symbols
+func (h *Handlers) deleteInstanceRecord(id string) error {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.ConfigRevision{}, "instance_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.InstanceSymbol{}, "instance_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Instance{}, "id = ?", id).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := h.PBRunner.RemoveInstanceFiles(id); err != nil {
+		log.Printf("Failed to remove files of instance %s: %v", id, err)
+	}
+	return nil
+}
+
+func (h *Handlers) findRevision(instanceID string, n int) (*models.ConfigRevision, error) {
+	var rev models.ConfigRevision
+	if err := h.DB.Where("instance_id = ? AND revision = ?", instanceID, n).First(&rev).Error; err != nil {
+		return nil, fmt.Errorf("revision %d not found", n)
+	}
+	return &rev, nil
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+// leverageConfig is v7Config with another leverage
+func leverageConfig(leverage int) string {
+	return fmt.Sprintf(`{"bot":{"long":{"n_positions":1,"total_wallet_exposure_limit":1},"short":{"n_positions":0,"total_wallet_exposure_limit":0}},"live":{"leverage":%d}}`, leverage)
+}
+
+// createTemplate stores a template through the API and returns its ID
+func (s *testServer) createTemplate(t *testing.T, name, config string) string {
+	t.Helper()
+	var template models.ConfigTemplate
+	if w := s.do(t, "POST", "/api/v1/templates", map[string]string{"name": name, "config": config}, &template); w.Code != http.StatusCreated {
+		t.Fatalf("create template: %d %s", w.Code, w.Body)
+	}
+	return template.ID
+}
+
+// createInstance creates an instance through the API and returns its ID
+func (s *testServer) createInstance(t *testing.T, body map[string]interface{}) string {
+	t.Helper()
+	var instance models.Instance
+	if w := s.do(t, "POST", "/api/v1/instances", body, &instance); w.Code != http.StatusCreated {
+		t.Fatalf("create instance: %d %s", w.Code, w.Body)
+	}
+	return instance.ID
+}
+
+func (s *testServer) revisions(t *testing.T, id string) []models.ConfigRevision {
+	t.Helper()
+	var revisions []models.ConfigRevision
+	if w := s.do(t, "GET", "/api/v1/instances/"+id+"/config/revisions", nil, &revisions); w.Code != http.StatusOK {
+		t.Fatalf("list revisions: %d %s", w.Code, w.Body)
+	}
+	return revisions
+}
+
+// renderedNow renders an instance's stored config sources
+func (s *testServer) renderedNow(t *testing.T, id string) string {
+	t.Helper()
+	instance := s.instance(t, id)
+	if err := s.attachConfigSources(&instance); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := pbconfig.Render(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered
+}
+
+func TestRevisionSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	templateID := s.createTemplate(t, "base", leverageConfig(5))
+	id := s.createInstance(t, map[string]interface{}{
+		"name": "bot", "template_id": templateID, "overrides": `{"live":{"market_orders_allowed":true}}`,
+		"symbols": []map[string]interface{}{{"symbol": "BTCUSDT"}},
+	})
+
+	revisions := s.revisions(t, id)
+	if len(revisions) != 1 {
+		t.Fatalf("%d revisions, want 1", len(revisions))
+	}
+	rev := revisions[0]
+	if rev.Config != "" || rev.TemplateID != templateID {
+		t.Errorf("revision sources: config %q, template %q", rev.Config, rev.TemplateID)
+	}
+	want := `{"bot":{"long":{"n_positions":1,"total_wallet_exposure_limit":1},"short":{"n_positions":0,"total_wallet_exposure_limit":0}},` +
+		`"live":{"leverage":5,"market_orders_allowed":true,"approved_coins":{"long":["BTC"],"short":["BTC"]}}}`
+	if !sameConfig(rev.Rendered, want) {
+		t.Errorf("rendered = %s, want %s", rev.Rendered, want)
+	}
+	if len(rev.Symbols) != 1 || rev.Symbols[0] != (models.RevisionSymbol{Symbol: "BTC", Long: true, Short: true}) {
+		t.Errorf("symbols = %+v", rev.Symbols)
+	}
+}
+
+func TestRevisionDiff(t *testing.T) {
+	s := newTestServer(t)
+	templateID := s.createTemplate(t, "base", leverageConfig(5))
+	id := s.createInstance(t, map[string]interface{}{"name": "bot", "template_id": templateID})
+	s.do(t, "PUT", "/api/v1/instances/"+id, map[string]interface{}{"overrides": `{"live":{"leverage":10}}`}, nil)
+
+	var diff struct {
+		Changes          []pbconfig.Change `json:"changes"`
+		OverridesChanges []pbconfig.Change `json:"overrides_changes"`
+	}
+	if w := s.do(t, "GET", "/api/v1/instances/"+id+"/config/diff", nil, &diff); w.Code != http.StatusOK {
+		t.Fatalf("diff: %d %s", w.Code, w.Body)
+	}
+	// Template-based revisions differ in the config they render
+	if len(diff.Changes) != 1 || diff.Changes[0].Path != "/live/leverage" {
+		t.Errorf("changes = %+v, want /live/leverage", diff.Changes)
+	}
+	if len(diff.OverridesChanges) != 1 {
+		t.Errorf("overrides changes = %+v", diff.OverridesChanges)
+	}
+}
+
+func TestRollbackConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		changeBase    bool // the template changes after revision 1
+		wantDetached  bool
+		wantTemplate  bool
+		wantOverrides string
+	}{
+		{"template unchanged", false, false, true, `{"live":{"leverage":7}}`},
+		{"template changed", true, true, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			templateID := s.createTemplate(t, "base", leverageConfig(5))
+			id := s.createInstance(t, map[string]interface{}{
+				"name": "bot", "template_id": templateID, "overrides": `{"live":{"leverage":7}}`,
+				"symbols": []map[string]interface{}{{"symbol": "BTC"}},
+			})
+			original := s.revisions(t, id)[0].Rendered
+
+			// Revision 2 changes the overrides and the symbols
+			s.do(t, "PUT", "/api/v1/instances/"+id, map[string]interface{}{"overrides": `{"live":{"leverage":9}}`}, nil)
+			s.do(t, "POST", "/api/v1/instances/"+id+"/symbols", map[string]interface{}{"symbol": "ETH"}, nil)
+			if tt.changeBase {
+				s.DB.Model(&models.ConfigTemplate{}).Where("id = ?", templateID).
+					Update("config", `{"bot":{"long":{"n_positions":2,"total_wallet_exposure_limit":1},"short":{"n_positions":0,"total_wallet_exposure_limit":0}}}`)
+			}
+
+			var resp struct {
+				Detached bool                  `json:"detached"`
+				Revision models.ConfigRevision `json:"revision"`
+			}
+			if w := s.do(t, "POST", "/api/v1/instances/"+id+"/config/rollback", map[string]interface{}{"revision": 1}, &resp); w.Code != http.StatusOK {
+				t.Fatalf("rollback: %d %s", w.Code, w.Body)
+			}
+			if resp.Detached != tt.wantDetached {
+				t.Errorf("detached = %v, want %v", resp.Detached, tt.wantDetached)
+			}
+
+			// The instance runs what it ran at revision 1, whatever its
+			// template says now
+			if got := s.renderedNow(t, id); !sameConfig(got, original) {
+				t.Errorf("rendered after rollback = %s, want %s", got, original)
+			}
+			if !sameConfig(resp.Revision.Rendered, original) {
+				t.Errorf("rollback revision rendered %s, want %s", resp.Revision.Rendered, original)
+			}
+			instance := s.instance(t, id)
+			if (instance.TemplateID != "") != tt.wantTemplate || instance.Overrides != tt.wantOverrides {
+				t.Errorf("template %q, overrides %q", instance.TemplateID, instance.Overrides)
+			}
+			if len(instance.Symbols) != 1 || instance.Symbols[0].Symbol != "BTC" {
+				t.Errorf("symbols = %+v, want only BTC", instance.Symbols)
+			}
+		})
+	}
+}
+
+func TestRollbackLegacyRevision(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "old", Config: leverageConfig(9), Symbols: []models.InstanceSymbol{{ID: "s1", Symbol: "BTC", Long: true}}})
+	// Recorded before revisions kept snapshots: no symbols, no rendered config
+	s.DB.Create(&models.ConfigRevision{ID: "r1", InstanceID: "old", Revision: 1, Config: leverageConfig(3)})
+
+	if w := s.do(t, "POST", "/api/v1/instances/old/config/rollback", map[string]interface{}{"revision": 1}, nil); w.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", w.Code, w.Body)
+	}
+	instance := s.instance(t, "old")
+	if !sameConfig(instance.Config, leverageConfig(3)) || len(instance.Symbols) != 1 {
+		t.Errorf("config %s, symbols %+v", instance.Config, instance.Symbols)
+	}
+
+	var revisions []models.ConfigRevision
+	s.DB.Where("instance_id = ?", "old").Order("revision").Find(&revisions)
+	raw, _ := json.Marshal(revisions[len(revisions)-1].Symbols)
+	if len(revisions) != 2 || string(raw) != `[{"symbol":"BTC","long":true,"short":false}]` {
+		t.Errorf("%d revisions, last with symbols %s", len(revisions), raw)
+	}
+}
+
+func TestTemplateUpdateRecordsRevisions(t *testing.T) {
+	s := newTestServer(t)
+	templateID := s.createTemplate(t, "base", leverageConfig(5))
+	inherits := s.createInstance(t, map[string]interface{}{"name": "inherits", "template_id": templateID})
+	// Overrides the leverage, so the update does not change its config
+	pinned := s.createInstance(t, map[string]interface{}{"name": "pinned", "template_id": templateID, "overrides": `{"live":{"leverage":3}}`})
+
+	body := map[string]interface{}{"name": "base", "config": leverageConfig(8), "message": "raise leverage"}
+	if w := s.do(t, "PUT", "/api/v1/templates/"+templateID+"?dry_run=true", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("dry run: %d %s", w.Code, w.Body)
+	}
+	if n := len(s.revisions(t, inherits)); n != 1 {
+		t.Errorf("dry run recorded revisions: %d", n)
+	}
+
+	if w := s.do(t, "PUT", "/api/v1/templates/"+templateID, body, nil); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	revisions := s.revisions(t, inherits)
+	if len(revisions) != 2 || revisions[0].Message != "raise leverage" || !sameConfig(revisions[0].Rendered, leverageConfig(8)) {
+		t.Errorf("revisions of the inheriting instance: %+v", revisions)
+	}
+	if n := len(s.revisions(t, pinned)); n != 1 {
+		t.Errorf("unaffected instance has %d revisions, want 1", n)
+	}
+}
+
+func TestSymbolChangesRecordRevisions(t *testing.T) {
+	s := newTestServer(t)
+	id := s.createInstance(t, map[string]interface{}{"name": "multi", "config": v7Config})
+	base := "/api/v1/instances/" + id + "/symbols"
+
+	steps := []struct {
+		method, path string
+		body         interface{}
+		wantMessage  string // of a new revision, empty for none
+		wantLong     []string
+	}{
+		{"POST", base, map[string]interface{}{"symbol": "BTCUSDT"}, "Symbol BTC added", []string{"BTC"}},
+		{"POST", base, map[string]interface{}{"symbol": "ETH", "message": "add eth"}, "add eth", []string{"BTC", "ETH"}},
+		{"PUT", base + "/ETH", map[string]interface{}{"long": false}, "Symbol ETH updated", []string{"BTC"}},
+		{"PUT", base + "/ETH", map[string]interface{}{"long": false}, "", []string{"BTC"}},
+		{"DELETE", base + "/BTC", nil, "Symbol BTC removed", []string{}},
+	}
+	count := len(s.revisions(t, id))
+	for i, step := range steps {
+		if w := s.do(t, step.method, step.path, step.body, nil); w.Code != http.StatusOK {
+			t.Fatalf("step %d: %d %s", i, w.Code, w.Body)
+		}
+		revisions := s.revisions(t, id)
+		if step.wantMessage == "" {
+			if len(revisions) != count {
+				t.Errorf("step %d recorded a revision without a config change", i)
+			}
+			continue
+		}
+		if len(revisions) != count+1 || revisions[0].Message != step.wantMessage {
+			t.Fatalf("step %d: %d revisions, latest %q; want %q", i, len(revisions), revisions[0].Message, step.wantMessage)
+		}
+		count++
+
+		var rendered struct {
+			Live struct {
+				ApprovedCoins struct {
+					Long []string `json:"long"`
+				} `json:"approved_coins"`
+			} `json:"live"`
+		}
+		json.Unmarshal([]byte(revisions[0].Rendered), &rendered)
+		if !equalStrings(rendered.Live.ApprovedCoins.Long, step.wantLong) {
+			t.Errorf("step %d: long coins %q, want %q", i, rendered.Live.ApprovedCoins.Long, step.wantLong)
+		}
+	}
+}
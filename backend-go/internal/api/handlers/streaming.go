@@ -1,81 +1,117 @@
 package handlers
 
 import (
-	"bufio"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+
+	"pbgui-backend/internal/services/passivbot"
 )
 
-// StreamLogs handles Server-Sent Events for log streaming
+// StreamLogs handles Server-Sent Events for log streaming.
+// Each event carries the line's sequence number as its id; a reconnecting
+// client's Last-Event-ID header replays the lines it missed. The level,
+// symbol and event query params filter the stream.
 func (h *Handlers) StreamLogs(c *gin.Context) {
 	instanceID := c.Param("id")
-	
+
+	filter, err := ParseLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, backlog, err := h.SubscribeLogs(instanceID, c.GetHeader("Last-Event-ID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer sub.Close()
+
 	// Set headers for SSE
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 
-	// Create a channel for logs
-	logChan := make(chan string, 100)
-	done := make(chan bool)
-
-	// Start log streaming in goroutine
-	go func() {
-		defer close(logChan)
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-
-		logCount := 0
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				logCount++
-				timestamp := time.Now().Format("15:04:05")
-				
-				// Simulate different types of logs
-				var logMessage string
-				switch logCount % 4 {
-				case 0:
-					logMessage = fmt.Sprintf("[%s] Instance %s: Market data received", timestamp, instanceID)
-				case 1:
-					logMessage = fmt.Sprintf("[%s] Instance %s: Position check completed", timestamp, instanceID)
-				case 2:
-					logMessage = fmt.Sprintf("[%s] Instance %s: Order book updated", timestamp, instanceID)
-				case 3:
-					logMessage = fmt.Sprintf("[%s] Instance %s: PnL: +$%.2f", timestamp, instanceID, float64(logCount)*0.15)
-				}
+	var lastSeq uint64
+	var reported int64
+	send := func(w io.Writer, line passivbot.LogLine) {
+		if !filter.Matches(line) {
+			return
+		}
+		data, _ := json.Marshal(LogMessage(instanceID, line))
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.Seq, data)
+	}
 
-				select {
-				case logChan <- logMessage:
-				case <-done:
-					return
-				}
+	c.Stream(func(w io.Writer) bool {
+		// Replay missed lines before switching to the live tail
+		if len(backlog) > 0 {
+			for _, line := range backlog {
+				send(w, line)
+				lastSeq = line.Seq
 			}
+			backlog = nil
+			return true
 		}
-	}()
 
-	// Stream logs to client
-	c.Stream(func(w gin.ResponseWriter) bool {
 		select {
-		case logMessage, ok := <-logChan:
+		case line, ok := <-sub.C:
 			if !ok {
+				// The hub disconnected us for falling too far behind
+				fmt.Fprintf(w, "event: error\ndata: log stream closed: client too slow\n\n")
 				return false
 			}
-			
+			if line.Seq <= lastSeq {
+				// Already sent as part of the replay
+				return true
+			}
+
+			if dropped := sub.Dropped(); dropped > reported {
+				fmt.Fprintf(w, "event: dropped\ndata: %d\n\n", dropped-reported)
+				reported = dropped
+			}
+
 			// Send SSE formatted message
-			fmt.Fprintf(w, "data: %s\n\n", logMessage)
+			send(w, line)
+			lastSeq = line.Seq
 			return true
-			
+
 		case <-c.Request.Context().Done():
-			close(done)
 			return false
 		}
 	})
 }
+
+// SubscribeLogs subscribes to an instance's live output. When after is a
+// sequence number the lines emitted since then are returned for replay.
+func (h *Handlers) SubscribeLogs(instanceID, after string) (*passivbot.Subscription, []passivbot.LogLine, error) {
+	if after == "" {
+		return h.PBRunner.SubscribeLogs(instanceID), nil, nil
+	}
+	seq, err := strconv.ParseUint(after, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid log sequence id %q", after)
+	}
+	return h.PBRunner.ResumeLogs(instanceID, seq)
+}
+
+// LogMessage is the payload sent to log stream clients for one line
+func LogMessage(instanceID string, line passivbot.LogLine) map[string]interface{} {
+	return map[string]interface{}{
+		"seq":         line.Seq,
+		"timestamp":   line.Time,
+		"logged_at":   line.LoggedAt,
+		"instance_id": instanceID,
+		"stream":      line.Stream,
+		"level":       line.Level,
+		"symbol":      line.Symbol,
+		"event":       line.Event,
+		"message":     line.Message,
+		"raw":         line.Text,
+	}
+}
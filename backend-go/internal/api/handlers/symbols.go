@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/passivbot"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+var coinPattern = regexp.MustCompile(`^[A-Z0-9]{1,20}$`)
+
+// Symbol Handlers
+
+// GetInstanceSymbols lists the coins a multi-symbol instance trades
+func (h *Handlers) GetInstanceSymbols(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.Instance{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	symbols := []models.InstanceSymbol{}
+	if err := h.DB.Where("instance_id = ?", id).Order("symbol").Find(&symbols).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, symbols)
+}
+
+// symbolRequest adds or changes a symbol. Sides left out default to enabled
+// when adding and stay unchanged when updating.
+type symbolRequest struct {
+	Symbol    string  `json:"symbol"`
+	Long      *bool   `json:"long"`
+	Short     *bool   `json:"short"`
+	Overrides *string `json:"overrides"`
+}
+
+// AddInstanceSymbol adds a coin to an instance
+func (h *Handlers) AddInstanceSymbol(c *gin.Context) {
+	var req symbolRequest
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	instance, ok := h.loadSymbolInstance(c)
+	if !ok {
+		return
+	}
+
+	symbol := models.InstanceSymbol{
+		ID:         uuid.New().String(),
+		InstanceID: instance.ID,
+		Symbol:     pbconfig.CoinName(req.Symbol),
+		Long:       true,
+		Short:      true,
+		CreatedAt:  time.Now(),
+	}
+	applySymbolRequest(&symbol, req)
+	if findSymbol(instance.Symbols, symbol.Symbol) >= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("symbol %s already exists", symbol.Symbol)})
+		return
+	}
+
+	symbols := append(append([]models.InstanceSymbol(nil), instance.Symbols...), symbol)
+	h.changeSymbols(c, instance, symbols, "Symbol "+symbol.Symbol+" added", func(tx *gorm.DB) error {
+		return tx.Create(&symbol).Error
+	})
+}
+
+// UpdateInstanceSymbol changes a coin's sides or overrides
+func (h *Handlers) UpdateInstanceSymbol(c *gin.Context) {
+	var req symbolRequest
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	instance, ok := h.loadSymbolInstance(c)
+	if !ok {
+		return
+	}
+	i := findSymbol(instance.Symbols, pbconfig.CoinName(c.Param("symbol")))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Symbol not found"})
+		return
+	}
+
+	symbols := append([]models.InstanceSymbol(nil), instance.Symbols...)
+	symbol := &symbols[i]
+	req.Symbol = symbol.Symbol
+	applySymbolRequest(symbol, req)
+	h.changeSymbols(c, instance, symbols, "Symbol "+symbol.Symbol+" updated", func(tx *gorm.DB) error {
+		return tx.Save(symbol).Error
+	})
+}
+
+// RemoveInstanceSymbol removes a coin from an instance
+func (h *Handlers) RemoveInstanceSymbol(c *gin.Context) {
+	instance, ok := h.loadSymbolInstance(c)
+	if !ok {
+		return
+	}
+	i := findSymbol(instance.Symbols, pbconfig.CoinName(c.Param("symbol")))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Symbol not found"})
+		return
+	}
+
+	removed := instance.Symbols[i]
+	symbols := append(append([]models.InstanceSymbol(nil), instance.Symbols[:i]...), instance.Symbols[i+1:]...)
+	h.changeSymbols(c, instance, symbols, "Symbol "+removed.Symbol+" removed", func(tx *gorm.DB) error {
+		return tx.Delete(&models.InstanceSymbol{}, "id = ?", removed.ID).Error
+	})
+}
+
+func (h *Handlers) loadSymbolInstance(c *gin.Context) (models.Instance, bool) {
+	var instance models.Instance
+	if err := h.DB.First(&instance, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+		return instance, false
+	}
+	if err := h.attachConfigSources(&instance); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return instance, false
+	}
+	return instance, true
+}
+
+func applySymbolRequest(symbol *models.InstanceSymbol, req symbolRequest) {
+	if req.Long != nil {
+		symbol.Long = *req.Long
+	}
+	if req.Short != nil {
+		symbol.Short = *req.Short
+	}
+	if req.Overrides != nil {
+		symbol.Overrides = *req.Overrides
+	}
+}
+
+// changeSymbols validates an instance's new symbol list, stores the change
+// with save, records a config revision if the rendered config changes and,
+// unless `apply=false`, restarts a running instance gracefully (`grace`
+// seconds) so it trades the new list
+func (h *Handlers) changeSymbols(c *gin.Context, instance models.Instance, symbols []models.InstanceSymbol, message string, save func(tx *gorm.DB) error) {
+	grace, err := parseIntParam(c, "grace")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateSymbols(symbols); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before := instance
+	instance.Symbols = symbols
+	rendered, err := pbconfig.Render(instance)
+	if err == nil {
+		err = pbconfig.Validate(rendered)
+	}
+	if err != nil {
+		respondConfigError(c, err)
+		return
+	}
+	oldRendered, err := pbconfig.Render(before)
+	changed := err != nil || !sameConfig(oldRendered, rendered)
+
+	meta := bindRevisionMeta(c, message)
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := save(tx); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Instance{}).Where("id = ?", instance.ID).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+		_, err := recordConfigChange(tx, before, instance, meta)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{"symbols": symbols, "restarted": false, "restart_required": false}
+	if _, running := h.PBRunner.Supervised(instance.ID); running {
+		if c.Query("apply") == "false" {
+			resp["restart_required"] = true
+		} else {
+			if _, err := h.restartInstance(instance, time.Duration(grace)*time.Second); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("saved, but restart failed: %v", err)})
+				return
+			}
+			resp["restarted"] = true
+			resp["status"] = passivbot.StatusRunning
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// validateSymbols checks symbol names, duplicates and override syntax
+func validateSymbols(symbols []models.InstanceSymbol) error {
+	seen := map[string]bool{}
+	for _, s := range symbols {
+		if !coinPattern.MatchString(s.Symbol) {
+			return fmt.Errorf("invalid symbol %q", s.Symbol)
+		}
+		if seen[s.Symbol] {
+			return fmt.Errorf("duplicate symbol %s", s.Symbol)
+		}
+		seen[s.Symbol] = true
+		if s.Overrides == "" {
+			continue
+		}
+		var override map[string]interface{}
+		if err := json.Unmarshal([]byte(s.Overrides), &override); err != nil {
+			return fmt.Errorf("symbol %s: overrides must be a JSON object", s.Symbol)
+		}
+	}
+	return nil
+}
+
+// normalizeSymbols prepares symbols sent with a new instance: coin names
+// are normalized and a symbol without sides trades both
+func normalizeSymbols(symbols []models.InstanceSymbol) {
+	for i := range symbols {
+		s := &symbols[i]
+		s.ID = uuid.New().String()
+		s.Symbol = pbconfig.CoinName(s.Symbol)
+		if !s.Long && !s.Short {
+			s.Long, s.Short = true, true
+		}
+		s.CreatedAt = time.Now()
+	}
+}
+
+func findSymbol(symbols []models.InstanceSymbol, symbol string) int {
+	for i, s := range symbols {
+		if s.Symbol == symbol {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"pbgui-backend/internal/models"
+)
+
+func TestCreateInstanceWithSymbols(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		wantCode int
+	}{
+		{"v7 config", v7Config, http.StatusCreated},
+		{"no config", "", http.StatusCreated},
+		{"legacy config", `{"symbol":"BTCUSDT","leverage":5,"wallet_exposure_limit":0.5}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			body := map[string]interface{}{
+				"name":    "multi",
+				"config":  tt.config,
+				"symbols": []map[string]interface{}{{"symbol": "btc/usdt:usdt"}, {"symbol": "ETHUSDT", "short": true}},
+			}
+			var instance models.Instance
+			w := s.do(t, "POST", "/api/v1/instances", body, &instance)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+
+			// Coins are normalized and a symbol without sides trades both
+			got := s.instance(t, instance.ID).Symbols
+			if len(got) != 2 || got[0].Symbol != "BTC" || !got[0].Long || !got[0].Short || got[1].Symbol != "ETH" || got[1].Long || !got[1].Short {
+				t.Errorf("symbols %+v", got)
+			}
+			var rendered struct {
+				Live struct {
+					ApprovedCoins map[string][]string `json:"approved_coins"`
+				} `json:"live"`
+			}
+			if err := json.Unmarshal([]byte(s.renderedNow(t, instance.ID)), &rendered); err != nil {
+				t.Fatal(err)
+			}
+			coins := rendered.Live.ApprovedCoins
+			if !equalStrings(coins["long"], []string{"BTC"}) || !equalStrings(coins["short"], []string{"BTC", "ETH"}) {
+				t.Errorf("approved coins %v", coins)
+			}
+		})
+	}
+}
+
+func TestInstanceSymbolsValidation(t *testing.T) {
+	tests := []struct {
+		name, method, path string
+		body               interface{}
+		want               int
+	}{
+		{"duplicate", "POST", "/api/v1/instances/a/symbols", `{"symbol":"BTCUSDT"}`, http.StatusConflict},
+		{"invalid name", "POST", "/api/v1/instances/a/symbols", `{"symbol":"BTC-PERP"}`, http.StatusBadRequest},
+		{"overrides not an object", "POST", "/api/v1/instances/a/symbols", `{"symbol":"ETH","overrides":"[1]"}`, http.StatusBadRequest},
+		{"overrides invalid for v7", "POST", "/api/v1/instances/a/symbols", `{"symbol":"ETH","overrides":"{\"live\":{\"leverage\":-1}}"}`, http.StatusBadRequest},
+		{"not json", "POST", "/api/v1/instances/a/symbols", `{`, http.StatusBadRequest},
+		{"unknown instance", "POST", "/api/v1/instances/nope/symbols", `{"symbol":"ETH"}`, http.StatusNotFound},
+		{"update unknown symbol", "PUT", "/api/v1/instances/a/symbols/ETH", `{"long":false}`, http.StatusNotFound},
+		{"remove unknown symbol", "DELETE", "/api/v1/instances/a/symbols/ETH", nil, http.StatusNotFound},
+		{"legacy config", "POST", "/api/v1/instances/legacy/symbols", `{"symbol":"ETH"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.addInstance(t, models.Instance{ID: "a", Config: v7Config, Symbols: []models.InstanceSymbol{{ID: "s1", Symbol: "BTC", Long: true}}})
+			s.addInstance(t, models.Instance{ID: "legacy", Config: `{"symbol":"BTCUSDT","leverage":5,"wallet_exposure_limit":0.5}`})
+			if w := s.do(t, tt.method, tt.path, tt.body, nil); w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := s.instance(t, "a").Symbols; len(got) != 1 || got[0].Symbol != "BTC" || !got[0].Long {
+				t.Errorf("symbols changed: %+v", got)
+			}
+			if got := s.instance(t, "legacy").Symbols; len(got) != 0 {
+				t.Errorf("legacy instance got symbols %+v", got)
+			}
+		})
+	}
+}
+
+func TestInstanceSymbolsRunning(t *testing.T) {
+	s := newTestServer(t)
+	s.addInstance(t, models.Instance{ID: "a", Config: v7Config, Symbols: []models.InstanceSymbol{{ID: "s1", Symbol: "BTC", Long: true}}})
+	if w := s.do(t, "POST", "/api/v1/instances/a/start", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("start: status %d: %s", w.Code, w.Body)
+	}
+	first := s.pidInfo(t, "a")
+
+	type response struct {
+		Symbols         []models.InstanceSymbol `json:"symbols"`
+		Restarted       bool                    `json:"restarted"`
+		RestartRequired bool                    `json:"restart_required"`
+	}
+	var resp response
+	s.do(t, "POST", "/api/v1/instances/a/symbols?apply=false", `{"symbol":"ETH"}`, &resp)
+	if resp.Restarted || !resp.RestartRequired || len(resp.Symbols) != 2 {
+		t.Errorf("apply=false: %+v", resp)
+	}
+	if s.pidInfo(t, "a").PID != first.PID {
+		t.Error("restarted with apply=false")
+	}
+
+	resp = response{}
+	s.do(t, "PUT", "/api/v1/instances/a/symbols/eth", `{"short":false,"overrides":"{\"live\":{\"leverage\":3}}"}`, &resp)
+	if !resp.Restarted || resp.RestartRequired {
+		t.Errorf("update: %+v", resp)
+	}
+	info := s.pidInfo(t, "a")
+	if info.PID == first.PID {
+		t.Fatal("not restarted on the new symbols")
+	}
+	data, err := os.ReadFile(info.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Live struct {
+			ApprovedCoins map[string][]string `json:"approved_coins"`
+		} `json:"live"`
+		CoinOverrides map[string]interface{} `json:"coin_overrides"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(config.Live.ApprovedCoins["long"], []string{"BTC", "ETH"}) || len(config.Live.ApprovedCoins["short"]) != 0 || config.CoinOverrides["ETH"] == nil {
+		t.Errorf("config file:\n%s", data)
+	}
+
+	resp = response{}
+	s.do(t, "DELETE", "/api/v1/instances/a/symbols/BTCUSDT", nil, &resp)
+	if !resp.Restarted || len(resp.Symbols) != 1 || resp.Symbols[0].Symbol != "ETH" {
+		t.Errorf("remove: %+v", resp)
+	}
+	if data, _ := os.ReadFile(s.pidInfo(t, "a").ConfigPath); strings.Contains(string(data), `"BTC"`) {
+		t.Errorf("BTC still in the config file:\n%s", data)
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+var errTemplateNotFound = errors.New("config template not found")
+
+// Template Handlers
+
+func (h *Handlers) GetTemplates(c *gin.Context) {
+	var templates []models.ConfigTemplate
+	if err := h.DB.Order("name").Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+func (h *Handlers) CreateTemplate(c *gin.Context) {
+	var template models.ConfigTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if template.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if err := pbconfig.Validate(template.Config); err != nil {
+		respondConfigError(c, err)
+		return
+	}
+
+	template.ID = uuid.New().String()
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	if err := h.DB.Create(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, template)
+}
+
+func (h *Handlers) GetTemplate(c *gin.Context) {
+	var template models.ConfigTemplate
+	if err := h.DB.First(&template, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errTemplateNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// GetTemplateInstances lists the instances that inherit from a template
+func (h *Handlers) GetTemplateInstances(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.ConfigTemplate{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errTemplateNotFound.Error()})
+		return
+	}
+
+	var instances []models.Instance
+	if err := h.DB.Where("template_id = ?", id).Find(&instances).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, instances)
+}
+
+// affectedInstance is an instance whose rendered config a template update
+// changes
+type affectedInstance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Status  string            `json:"status"`
+	Running bool              `json:"running"`
+	Changes []pbconfig.Change `json:"changes"`
+
+	// The instance rendered with the old and the new template
+	before, after models.Instance
+}
+
+type templateUpdate struct {
+	models.ConfigTemplate
+	Affected        []affectedInstance `json:"affected"`
+	DryRun          bool               `json:"dry_run,omitempty"`
+	RestartRequired []string           `json:"restart_required,omitempty"`
+	OperationID     string             `json:"operation_id,omitempty"`
+}
+
+// UpdateTemplate changes a template and reports every instance whose
+// rendered config changes as a result; each of them gets a config revision.
+// `dry_run=true` only reports; `rollout=true` restarts the affected running
+// instances on the new config in the worker pool, otherwise they pick it up
+// on their next start.
+func (h *Handlers) UpdateTemplate(c *gin.Context) {
+	id := c.Param("id")
+	var template models.ConfigTemplate
+	if err := h.DB.First(&template, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errTemplateNotFound.Error()})
+		return
+	}
+
+	before := template
+	if err := c.ShouldBindBodyWith(&template, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template.ID = id
+	template.CreatedAt = before.CreatedAt
+	if template.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if err := pbconfig.Validate(template.Config); err != nil {
+		respondConfigError(c, err)
+		return
+	}
+
+	affected, err := h.templateChanges(before, template)
+	if err != nil {
+		respondConfigError(c, err)
+		return
+	}
+	resp := templateUpdate{ConfigTemplate: template, Affected: affected}
+	if c.Query("dry_run") == "true" {
+		resp.DryRun = true
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	template.UpdatedAt = time.Now()
+	revMeta := bindRevisionMeta(c, fmt.Sprintf("Template %s updated", template.Name))
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&template).Error; err != nil {
+			return err
+		}
+		for _, a := range affected {
+			if _, err := recordConfigChange(tx, a.before, a.after, revMeta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp.ConfigTemplate = template
+
+	var running []string
+	for _, a := range affected {
+		if a.Running {
+			running = append(running, a.ID)
+		}
+	}
+	if len(running) == 0 {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if c.Query("rollout") != "true" {
+		resp.RestartRequired = running
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	op := h.PBRunner.RunOperation("rollout", running, h.rolloutInstance)
+	resp.OperationID = op.Snapshot().ID
+	var meta struct {
+		Actor  string `json:"actor"`
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindBodyWith(&meta, binding.JSON)
+	h.recordAudit(c, "template_rollout", meta.Actor, meta.Reason, gin.H{
+		"operation_id": resp.OperationID,
+		"template_id":  id,
+		"ids":          running,
+	})
+	c.JSON(http.StatusAccepted, resp)
+}
+
+// templateChanges renders every instance of a template before and after
+// an update and returns those whose config changes. It fails if any of
+// them would render an invalid config.
+func (h *Handlers) templateChanges(before, after models.ConfigTemplate) ([]affectedInstance, error) {
+	var instances []models.Instance
+	if err := h.DB.Preload("Symbols").Where("template_id = ?", before.ID).Find(&instances).Error; err != nil {
+		return nil, err
+	}
+
+	affected := []affectedInstance{}
+	for _, instance := range instances {
+		old := instance
+		old.Template = &before
+		oldRendered, err := pbconfig.Render(old)
+		if err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+		instance.Template = &after
+		rendered, err := pbconfig.Render(instance)
+		if err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+		if err := pbconfig.Validate(rendered); err != nil {
+			return nil, fmt.Errorf("instance %s: %w", instance.Name, err)
+		}
+
+		changes, err := pbconfig.Diff(oldRendered, rendered)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		_, running := h.PBRunner.Supervised(instance.ID)
+		affected = append(affected, affectedInstance{
+			ID:      instance.ID,
+			Name:    instance.Name,
+			Status:  instance.Status,
+			Running: running,
+			Changes: changes,
+			before:  old,
+			after:   instance,
+		})
+	}
+	return affected, nil
+}
+
+// rolloutInstance restarts one instance of a template rollout
+func (h *Handlers) rolloutInstance(id string) (string, error) {
+	var instance models.Instance
+	if err := h.DB.First(&instance, "id = ?", id).Error; err != nil {
+		return "", fmt.Errorf("instance not found")
+	}
+	if _, running := h.PBRunner.Supervised(id); !running {
+		return "not running", nil
+	}
+	if _, err := h.restartInstance(instance, 0); err != nil {
+		return "", err
+	}
+	return "restarted", nil
+}
+
+// DeleteTemplate removes a template no instance inherits from
+func (h *Handlers) DeleteTemplate(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.DB.First(&models.ConfigTemplate{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errTemplateNotFound.Error()})
+		return
+	}
+
+	var ids []string
+	h.DB.Model(&models.Instance{}).Where("template_id = ?", id).Pluck("id", &ids)
+	if len(ids) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "template is used by instances", "instances": ids})
+		return
+	}
+
+	if err := h.DB.Delete(&models.ConfigTemplate{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+}
+
+// attachConfigSources loads what an instance's config is rendered from
+// besides its own fields: the template it inherits from and its symbols.
+// Instances not stored yet keep the symbols they were given.
+func (h *Handlers) attachConfigSources(instance *models.Instance) error {
+	if instance.ID != "" {
+		instance.Symbols = nil
+		if err := h.DB.Where("instance_id = ?", instance.ID).Order("symbol").Find(&instance.Symbols).Error; err != nil {
+			return err
+		}
+	}
+
+	if instance.TemplateID == "" {
+		instance.Template = nil
+		return nil
+	}
+	var template models.ConfigTemplate
+	if err := h.DB.First(&template, "id = ?", instance.TemplateID).Error; err != nil {
+		return errTemplateNotFound
+	}
+	instance.Template = &template
+	return nil
+}
+
+// validateRenderedConfig checks the config an instance would run with
+func (h *Handlers) validateRenderedConfig(instance *models.Instance) error {
+	if err := h.attachConfigSources(instance); err != nil {
+		return err
+	}
+	rendered, err := pbconfig.Render(*instance)
+	if err != nil {
+		return err
+	}
+	return pbconfig.Validate(rendered)
+}
@@ -14,14 +14,67 @@ func SetupRoutes(r *gin.Engine, h *handlers.Handlers) {
 	{
 		instances.GET("", h.GetInstances)
 		instances.POST("", h.CreateInstance)
+		instances.POST("/reconcile", h.Reconcile)
+		instances.POST("/stop-all", h.StopAllInstances)
+		instances.POST("/bulk", h.BulkInstances)
 		instances.GET("/:id", h.GetInstance)
 		instances.PUT("/:id", h.UpdateInstance)
 		instances.DELETE("/:id", h.DeleteInstance)
 		instances.POST("/:id/start", h.StartInstance)
 		instances.POST("/:id/stop", h.StopInstance)
+		instances.POST("/:id/restart", h.RestartInstance)
+		instances.GET("/:id/config/revisions", h.ListConfigRevisions)
+		instances.GET("/:id/config/revisions/:revision", h.GetConfigRevision)
+		instances.GET("/:id/config/diff", h.DiffConfigRevisions)
+		instances.POST("/:id/config/rollback", h.RollbackConfig)
+		instances.GET("/:id/config/export", h.ExportInstanceConfig)
+		instances.GET("/:id/symbols", h.GetInstanceSymbols)
+		instances.POST("/:id/symbols", h.AddInstanceSymbol)
+		instances.PUT("/:id/symbols/:symbol", h.UpdateInstanceSymbol)
+		instances.DELETE("/:id/symbols/:symbol", h.RemoveInstanceSymbol)
 		instances.GET("/:id/logs", h.StreamLogs) // SSE endpoint
+		instances.GET("/:id/logs/history", h.GetInstanceLogs)
+		instances.GET("/:id/logs/download", h.DownloadInstanceLogs)
 	}
 	
+	// Logs across instances
+	logs := api.Group("/logs")
+	{
+		logs.GET("/search", h.SearchLogs)
+		logs.GET("/download", h.DownloadLogs)
+	}
+	
+	// Passivbot config schemas and import
+	api.GET("/configs/schemas", h.GetConfigSchemas)
+	api.POST("/configs/import", h.ImportConfigs)
+	
+	// Config templates inherited by instances
+	templates := api.Group("/templates")
+	{
+		templates.GET("", h.GetTemplates)
+		templates.POST("", h.CreateTemplate)
+		templates.GET("/:id", h.GetTemplate)
+		templates.PUT("/:id", h.UpdateTemplate)
+		templates.DELETE("/:id", h.DeleteTemplate)
+		templates.GET("/:id/instances", h.GetTemplateInstances)
+	}
+
+	// Exchange accounts; credentials are write-only
+	accounts := api.Group("/accounts")
+	{
+		accounts.GET("", h.GetAccounts)
+		accounts.POST("", h.CreateAccount)
+		accounts.GET("/:id", h.GetAccount)
+		accounts.PUT("/:id", h.UpdateAccount)
+		accounts.DELETE("/:id", h.DeleteAccount)
+	}
+	
+	// Bulk operation progress
+	api.GET("/operations/:id", h.GetOperation)
+	
+	// Audit trail
+	api.GET("/audit", h.GetAuditLogs)
+	
 	// Dashboard
 	dashboard := api.Group("/dashboard")
 	{
@@ -37,14 +90,19 @@ func SetupRoutes(r *gin.Engine, h *handlers.Handlers) {
 		backtest.GET("/jobs/:id", h.GetBacktestJob)
 		backtest.DELETE("/jobs/:id", h.CancelBacktestJob)
 		backtest.GET("/results/:id", h.GetBacktestResults)
+		backtest.GET("/results/:id/fills", h.GetBacktestFills)
 	}
 	
+	// Job queue load per type
+	api.GET("/jobs/queue", h.GetJobQueue)
+
 	// Optimization
 	optimize := api.Group("/optimize")
 	{
 		optimize.POST("/run", h.RunOptimization)
 		optimize.GET("/jobs", h.GetOptimizeJobs)
 		optimize.GET("/jobs/:id", h.GetOptimizeJob)
+		optimize.DELETE("/jobs/:id", h.CancelOptimizeJob)
 		optimize.GET("/results/:id", h.GetOptimizeResults)
 	}
 	
@@ -81,6 +139,7 @@ func SetupRoutes(r *gin.Engine, h *handlers.Handlers) {
 	{
 		ws.GET("/instances/:id/logs", websocket.HandleLogStream(h))
 		ws.GET("/jobs/:id/progress", websocket.HandleJobProgress(h))
+		ws.GET("/operations/:id", websocket.HandleOperationProgress(h))
 		ws.GET("/dashboard/metrics", websocket.HandleDashboardMetrics(h))
 	}
 	
@@ -11,24 +11,147 @@ type Instance struct {
 	Exchange  string    `json:"exchange"`
 	Symbol    string    `json:"symbol"`
 	Strategy  string    `json:"strategy"`
-	Status    string    `json:"status"` // running, stopped, error
+	Status    string    `json:"status"` // running, restarting, stopped, error
 	PNL       float64   `json:"pnl"`
 	Position  float64   `json:"position"`
 	Config    string    `json:"config" gorm:"type:text"` // JSON config
+
+	// Restart policy: never, on-failure, always. MaxRestarts within
+	// RestartWindow (seconds) marks the instance as crash-looping.
+	RestartPolicy string `json:"restart_policy" gorm:"default:never"`
+	MaxRestarts   int    `json:"max_restarts"`
+	RestartWindow int    `json:"restart_window"`
+
+	// Last exit as observed by the runner
+	RestartCount int        `json:"restart_count"`
+	ExitCode     *int       `json:"exit_code"`
+	LastError    string     `json:"last_error"`
+	LastExitAt   *time.Time `json:"last_exit_at"`
+
+	// Resource limits applied at start; zero means unlimited
+	CPULimit      float64 `json:"cpu_limit"` // CPU cores, e.g. 0.5
+	MemoryLimitMB int     `json:"memory_limit_mb"`
+	MaxOpenFiles  int     `json:"max_open_files"`
+
+	// Live resource use sampled by the runner, not stored
+	Usage *ResourceUsage `json:"usage,omitempty" gorm:"-"`
+
+	// With a template, the instance runs the template's config with
+	// Overrides (a JSON merge patch) applied, and Config is unused
+	TemplateID string          `json:"template_id,omitempty" gorm:"index"`
+	Overrides  string          `json:"overrides,omitempty" gorm:"type:text"`
+	Template   *ConfigTemplate `json:"-" gorm:"-"` // attached before the instance is started
+
+	// Exchange account the instance trades with. Its decrypted credentials
+	// are attached before a start and written to passivbot's api-keys.json.
+	AccountID   string          `json:"account_id,omitempty" gorm:"index"`
+	Credentials *APICredentials `json:"-" gorm:"-"`
+
+	// Coins traded by one passivbot process; rendered into approved_coins
+	// and coin_overrides. Managed through the symbols endpoints.
+	Symbols []InstanceSymbol `json:"symbols,omitempty" gorm:"foreignKey:InstanceID"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// InstanceSymbol is one coin of a multi-symbol instance. Symbol holds the
+// coin as passivbot names it, e.g. "BTC" for BTCUSDT.
+type InstanceSymbol struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	InstanceID string    `json:"instance_id" gorm:"uniqueIndex:idx_instance_symbol"`
+	Symbol     string    `json:"symbol" gorm:"uniqueIndex:idx_instance_symbol"`
+	Long       bool      `json:"long"`
+	Short      bool      `json:"short"`
+	Overrides  string    `json:"overrides,omitempty" gorm:"type:text"` // JSON coin override: {"bot": {"long": {...}}, "live": {...}}
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ExchangeAccount holds API credentials for one exchange account. Key,
+// Secret and Passphrase are encrypted at rest and never serialized.
+type ExchangeAccount struct {
+	ID            string    `json:"id" gorm:"primaryKey"`
+	Label         string    `json:"label" gorm:"uniqueIndex"`
+	Exchange      string    `json:"exchange"`
+	Subaccount    string    `json:"subaccount,omitempty"`
+	Key           string    `json:"-" gorm:"type:text"`
+	Secret        string    `json:"-" gorm:"type:text"`
+	Passphrase    string    `json:"-" gorm:"type:text"`
+	KeyHint       string    `json:"key_hint"` // last characters of the API key, for telling keys apart
+	HasPassphrase bool      `json:"has_passphrase"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// APICredentials are an account's decrypted credentials, only ever held in
+// memory. User is the name the instance's config refers to them by.
+type APICredentials struct {
+	User       string
+	Exchange   string
+	Key        string
+	Secret     string
+	Passphrase string
+	Subaccount string
+}
+
+// ConfigTemplate is a shared base config that instances inherit
+type ConfigTemplate struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"uniqueIndex"`
+	Description string    `json:"description"`
+	Config      string    `json:"config" gorm:"type:text"` // JSON config
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ConfigRevision is an immutable snapshot of an instance's config, recorded
+// on every change. Rendered is the config the instance ran with, so it
+// stays valid after the template changes.
+type ConfigRevision struct {
+	ID         string           `json:"id" gorm:"primaryKey"`
+	InstanceID string           `json:"instance_id" gorm:"uniqueIndex:idx_instance_revision"`
+	Revision   int              `json:"revision" gorm:"uniqueIndex:idx_instance_revision"` // 1, 2, ... per instance
+	Config     string           `json:"config" gorm:"type:text"`                           // JSON config
+	TemplateID string           `json:"template_id,omitempty"`                             // template the config was rendered from
+	Overrides  string           `json:"overrides,omitempty" gorm:"type:text"`              // merge patch applied to the template
+	Symbols    []RevisionSymbol `json:"symbols" gorm:"type:text;serializer:json"`          // nil in revisions recorded without a snapshot
+	Rendered   string           `json:"rendered" gorm:"type:text"`                         // template, overrides and symbols applied
+	Author     string           `json:"author"`
+	Message    string           `json:"message"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// RevisionSymbol is a coin of a multi-symbol instance as recorded in a
+// config revision
+type RevisionSymbol struct {
+	Symbol    string `json:"symbol"`
+	Long      bool   `json:"long"`
+	Short     bool   `json:"short"`
+	Overrides string `json:"overrides,omitempty"`
+}
+
+// ResourceUsage is a sample of a running instance's resource use
+type ResourceUsage struct {
+	CPUPercent    float64   `json:"cpu_percent"` // of one core, over the last sample interval
+	RSSBytes      uint64    `json:"rss_bytes"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Limits        string    `json:"limits,omitempty"` // how limits are enforced: cgroup, rlimit
+	SampledAt     time.Time `json:"sampled_at"`
+}
+
 // Job represents a background job (backtest, optimization)
 type Job struct {
 	ID          string     `json:"id" gorm:"primaryKey"`
-	Type        string     `json:"type"`   // backtest, optimize
-	Status      string     `json:"status"` // queued, running, completed, failed
+	Type        string     `json:"type"`                // backtest, optimize
+	Status      string     `json:"status" gorm:"index"` // queued, running, completed, failed, cancelled
+	Priority    int        `json:"priority"`            // higher runs first
 	Progress    int        `json:"progress"`
-	Results     string     `json:"results" gorm:"type:text"` // JSON results
+	Results     string     `json:"results,omitempty" gorm:"type:text"` // JSON results; left out of job lists
 	Error       string     `json:"error"`
 	Params      string     `json:"params" gorm:"type:text"` // JSON params
+	Attempts    int        `json:"attempts"`                // starts, including ones interrupted by a restart
 	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
 	CompletedAt *time.Time `json:"completed_at"`
 }
 
@@ -43,11 +166,22 @@ type VPSServer struct {
 	CPU       float64   `json:"cpu"`
 	Memory    float64   `json:"memory"`
 	Disk      float64   `json:"disk"`
-	Instances []string  `json:"instances" gorm:"type:text"` // JSON array
+	Instances []string  `json:"instances" gorm:"type:text;serializer:json"` // JSON array
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// AuditLog records who triggered a sensitive operation and why
+type AuditLog struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	Action     string    `json:"action" gorm:"index"` // e.g. stop_all
+	Actor      string    `json:"actor"`
+	RemoteAddr string    `json:"remote_addr"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details" gorm:"type:text"` // JSON: filters and outcome
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 // BacktestParams represents backtest configuration
 type BacktestParams struct {
 	Symbol     string                 `json:"symbol"`
@@ -58,6 +192,26 @@ type BacktestParams struct {
 	Parameters map[string]interface{} `json:"parameters"`
 }
 
+// BacktestFill is one simulated order fill of a backtest. Backtests can
+// produce hundreds of thousands, so they are kept in their own table rather
+// than in the job's results.
+type BacktestFill struct {
+	ID            uint       `json:"-" gorm:"primaryKey"`
+	JobID         string     `json:"-" gorm:"index:idx_backtest_fills_job_seq,priority:1"`
+	Seq           int        `json:"-" gorm:"index:idx_backtest_fills_job_seq,priority:2"` // order in fills.csv
+	Minute        int64      `json:"minute"`
+	Timestamp     *time.Time `json:"timestamp,omitempty"`
+	Coin          string     `json:"coin,omitempty"`
+	Type          string     `json:"type"` // e.g. entry_initial_normal_long, close_grid_short
+	Qty           float64    `json:"qty"`
+	Price         float64    `json:"price"`
+	PNL           float64    `json:"pnl"`
+	Fee           float64    `json:"fee"`
+	Balance       float64    `json:"balance"`
+	PositionSize  float64    `json:"position_size"`
+	PositionPrice float64    `json:"position_price"`
+}
+
 // OptimizeParams represents optimization configuration
 type OptimizeParams struct {
 	BacktestParams
@@ -74,4 +228,7 @@ type DashboardStats struct {
 	DailyPNL         float64 `json:"daily_pnl"`
 	ActiveJobs       int     `json:"active_jobs"`
 	SystemUptime     string  `json:"system_uptime"`
+	CPUUsage         float64 `json:"cpu_usage"`          // summed over running instances, percent of one core
+	MemoryUsage      float64 `json:"memory_usage"`       // percent of host memory used by running instances
+	MemoryUsageBytes uint64  `json:"memory_usage_bytes"` // summed RSS of running instances
 }
@@ -0,0 +1,194 @@
+package jobs
+
+import (
+	"errors"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// Buffered progress events per watcher; a slow watcher misses intermediate
+// events, never the final one
+const progressBuffer = 16
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Progress is a job's state as published to watchers
+type Progress struct {
+	JobID      string    `json:"id"`
+	Status     string    `json:"status"`
+	Progress   int       `json:"progress"` // percent
+	Stage      string    `json:"stage,omitempty"`
+	Processed  int64     `json:"processed,omitempty"`
+	Total      int64     `json:"total,omitempty"`
+	ETASeconds *int64    `json:"eta_seconds,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Update is progress reported by a running job's handler
+type Update struct {
+	Percent   float64
+	Stage     string
+	Processed int64
+	Total     int64
+	ETA       time.Duration // 0 when unknown
+}
+
+// watch is the latest progress of a queued or running job and who is
+// watching it
+type watch struct {
+	snap      Progress
+	persisted int // percent last written to the jobs table
+	subs      map[chan Progress]struct{}
+}
+
+func progressOf(job models.Job) Progress {
+	return Progress{
+		JobID:     job.ID,
+		Status:    job.Status,
+		Progress:  job.Progress,
+		Error:     job.Error,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func terminal(status string) bool {
+	return status == StatusCompleted || status == StatusFailed || status == StatusCancelled
+}
+
+// Watch returns a job's current progress and a channel receiving every
+// update. The channel is closed after the final update once the job ends,
+// or at once for a job that already ended. cancel stops watching.
+func (q *Queue) Watch(jobID string) (Progress, <-chan Progress, func(), error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The jobs table is the authority on whether a job ended
+	var job models.Job
+	if err := q.db.First(&job, "id = ?", jobID).Error; err != nil {
+		return Progress{}, nil, nil, ErrJobNotFound
+	}
+	if terminal(job.Status) {
+		q.endWatch(jobID, job.Status, job.Error)
+		ch := make(chan Progress)
+		close(ch)
+		return progressOf(job), ch, func() {}, nil
+	}
+
+	w, ok := q.watches[jobID]
+	if !ok {
+		w = &watch{snap: progressOf(job), persisted: job.Progress, subs: map[chan Progress]struct{}{}}
+		q.watches[jobID] = w
+	}
+
+	ch := make(chan Progress, progressBuffer)
+	w.subs[ch] = struct{}{}
+	cancel := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := w.subs[ch]; ok {
+			delete(w.subs, ch)
+			close(ch)
+		}
+	}
+	return w.snap, ch, cancel, nil
+}
+
+// Report publishes a running job's progress to its watchers and records
+// the percentage in the jobs table when it changes. Reports for jobs that
+// are not running, including cancelled jobs still being killed, are
+// ignored.
+func (q *Queue) Report(jobID string, u Update) {
+	q.mu.Lock()
+	if _, running := q.cancels[jobID]; !running {
+		q.mu.Unlock()
+		return
+	}
+	w := q.watchLocked(jobID, StatusRunning)
+	w.snap.Status = StatusRunning
+	w.snap.Progress = int(u.Percent)
+	w.snap.Stage = u.Stage
+	w.snap.Processed = u.Processed
+	w.snap.Total = u.Total
+	w.snap.ETASeconds = nil
+	if u.ETA > 0 {
+		eta := int64(u.ETA.Round(time.Second) / time.Second)
+		w.snap.ETASeconds = &eta
+	}
+	w.snap.UpdatedAt = time.Now()
+	w.publish(w.snap)
+
+	percent := w.snap.Progress
+	persist := percent != w.persisted
+	w.persisted = percent
+	q.mu.Unlock()
+
+	if persist {
+		q.db.Model(&models.Job{}).
+			Where("id = ? AND status = ?", jobID, StatusRunning).
+			Update("progress", percent)
+	}
+}
+
+// SetProgress reports a running job's progress in percent
+func (q *Queue) SetProgress(jobID string, progress int) {
+	q.Report(jobID, Update{Percent: float64(progress)})
+}
+
+// watchLocked returns the job's watch, creating it. q.mu must be held.
+func (q *Queue) watchLocked(jobID, status string) *watch {
+	w, ok := q.watches[jobID]
+	if !ok {
+		w = &watch{
+			snap:      Progress{JobID: jobID, Status: status, UpdatedAt: time.Now()},
+			persisted: -1,
+			subs:      map[chan Progress]struct{}{},
+		}
+		q.watches[jobID] = w
+	}
+	return w
+}
+
+// endWatch publishes a job's final state and closes its watchers. q.mu
+// must be held.
+func (q *Queue) endWatch(jobID, status, errMsg string) {
+	w, ok := q.watches[jobID]
+	if !ok {
+		return
+	}
+	delete(q.watches, jobID)
+
+	w.snap.Status = status
+	w.snap.Error = errMsg
+	w.snap.ETASeconds = nil
+	if status == StatusCompleted {
+		w.snap.Progress = 100
+	}
+	w.snap.UpdatedAt = time.Now()
+	w.publish(w.snap)
+	for ch := range w.subs {
+		close(ch)
+	}
+	w.subs = nil
+}
+
+// publish sends p to every watcher, dropping a slow watcher's oldest
+// event to make room
+func (w *watch) publish(p Progress) {
+	for ch := range w.subs {
+		select {
+		case ch <- p:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package jobs runs backtests, optimizations and other long jobs from a
+// queue stored in the jobs table, so queued work survives restarts and
+// only a bounded number of jobs of each type run at once.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"pbgui-backend/internal/models"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Handler runs one job and returns its results, which are stored as JSON.
+// ctx is cancelled when the job is; the handler must then stop its work,
+// including any process it started, and return.
+type Handler func(ctx context.Context, job *models.Job) (interface{}, error)
+
+// ErrNotCancellable is returned when cancelling a job that already ended
+var ErrNotCancellable = errors.New("job has already finished")
+
+// Queue dispatches queued jobs to handlers. Within a type, jobs with a
+// higher priority run first and jobs of equal priority in the order they
+// were queued.
+type Queue struct {
+	db      *gorm.DB
+	mu      sync.Mutex
+	types   map[string]*jobType
+	cancels map[string]context.CancelFunc // running, not cancelled job ID -> cancel
+	watches map[string]*watch             // job ID -> progress watchers
+	wake    chan struct{}
+	started bool
+}
+
+type jobType struct {
+	name    string
+	handler Handler
+	limit   int // concurrent jobs
+	running int
+}
+
+// TypeStats is the current load of one job type
+type TypeStats struct {
+	Type        string `json:"type"`
+	Concurrency int    `json:"concurrency"`
+	Running     int    `json:"running"`
+	Queued      int64  `json:"queued"`
+}
+
+func NewQueue(db *gorm.DB) *Queue {
+	return &Queue{
+		db:      db,
+		types:   make(map[string]*jobType),
+		cancels: make(map[string]context.CancelFunc),
+		watches: make(map[string]*watch),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler and concurrency limit for a job type. It must
+// be called before Start.
+func (q *Queue) Register(name string, concurrency int, handler Handler) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.types[name] = &jobType{name: name, handler: handler, limit: concurrency}
+}
+
+// Start recovers jobs left over from a previous run and begins dispatching.
+// Jobs that were running when the backend stopped lost their process, so
+// they are queued again ahead of newer jobs of the same priority.
+func (q *Queue) Start() error {
+	q.mu.Lock()
+	if q.started {
+		q.mu.Unlock()
+		return nil
+	}
+	q.started = true
+	names := q.typeNames()
+	q.mu.Unlock()
+
+	result := q.db.Model(&models.Job{}).
+		Where("status = ? AND type IN ?", StatusRunning, names).
+		Updates(map[string]interface{}{"status": StatusQueued, "progress": 0, "started_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Jobs: re-queued %d job(s) interrupted by a restart", result.RowsAffected)
+	}
+	var queued int64
+	q.db.Model(&models.Job{}).Where("status = ? AND type IN ?", StatusQueued, names).Count(&queued)
+	if queued > 0 {
+		log.Printf("Jobs: %d job(s) queued", queued)
+	}
+
+	go q.dispatch()
+	q.notify()
+	return nil
+}
+
+// Enqueue stores a new job as queued and wakes the dispatcher
+func (q *Queue) Enqueue(job *models.Job) error {
+	q.mu.Lock()
+	_, ok := q.types[job.Type]
+	q.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	job.Status = StatusQueued
+	job.Progress = 0
+	job.CreatedAt = time.Now()
+	if err := q.db.Create(job).Error; err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// Cancel stops a job. A queued job never starts; a running job's context
+// is cancelled, which ends its process. The job is marked cancelled at once
+// and keeps that status whatever its handler returns.
+func (q *Queue) Cancel(jobID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := q.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", jobID, []string{StatusQueued, StatusRunning}).
+		Updates(map[string]interface{}{"status": StatusCancelled, "completed_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotCancellable
+	}
+	// Dropping the entry makes Report ignore output the job's process
+	// writes while it is being killed
+	if cancel, ok := q.cancels[jobID]; ok {
+		cancel()
+		delete(q.cancels, jobID)
+	}
+	q.endWatch(jobID, StatusCancelled, "")
+	return nil
+}
+
+// Position returns how many queued jobs of the same type run before a
+// queued job, or -1 if the job is not queued
+func (q *Queue) Position(job models.Job) int {
+	if job.Status != StatusQueued {
+		return -1
+	}
+	var ahead int64
+	q.db.Model(&models.Job{}).
+		Where("type = ? AND status = ? AND id <> ?", job.Type, StatusQueued, job.ID).
+		Where("priority > ? OR (priority = ? AND created_at < ?)", job.Priority, job.Priority, job.CreatedAt).
+		Count(&ahead)
+	return int(ahead)
+}
+
+// Stats reports the load of every job type
+func (q *Queue) Stats() []TypeStats {
+	q.mu.Lock()
+	stats := make([]TypeStats, 0, len(q.types))
+	for _, name := range q.typeNames() {
+		t := q.types[name]
+		stats = append(stats, TypeStats{Type: name, Concurrency: t.limit, Running: t.running})
+	}
+	q.mu.Unlock()
+
+	for i := range stats {
+		q.db.Model(&models.Job{}).Where("type = ? AND status = ?", stats[i].Type, StatusQueued).Count(&stats[i].Queued)
+	}
+	return stats
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) dispatch() {
+	for range q.wake {
+		q.schedule()
+	}
+}
+
+// schedule starts queued jobs while their types have free slots
+func (q *Queue) schedule() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, name := range q.typeNames() {
+		t := q.types[name]
+		free := t.limit - t.running
+		if free <= 0 {
+			continue
+		}
+		var next []models.Job
+		err := q.db.Where("type = ? AND status = ?", name, StatusQueued).
+			Order("priority DESC, created_at ASC, id ASC").
+			Limit(free).
+			Find(&next).Error
+		if err != nil {
+			log.Printf("Jobs: failed to load queued %s jobs: %v", name, err)
+			continue
+		}
+		for i := range next {
+			job := next[i]
+			if !q.claim(&job) {
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			q.cancels[job.ID] = cancel
+			w := q.watchLocked(job.ID, StatusRunning)
+			w.snap.Status = StatusRunning
+			w.snap.UpdatedAt = time.Now()
+			w.publish(w.snap)
+			t.running++
+			go q.run(ctx, cancel, t, job)
+		}
+	}
+}
+
+// claim marks a queued job as running, unless it was cancelled meanwhile
+func (q *Queue) claim(job *models.Job) bool {
+	now := time.Now()
+	result := q.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, StatusQueued).
+		Updates(map[string]interface{}{
+			"status":     StatusRunning,
+			"started_at": now,
+			"attempts":   gorm.Expr("attempts + 1"),
+		})
+	if result.Error != nil {
+		log.Printf("Jobs: failed to start job %s: %v", job.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	job.Attempts++
+	return true
+}
+
+func (q *Queue) run(ctx context.Context, cancel context.CancelFunc, t *jobType, job models.Job) {
+	defer func() {
+		q.mu.Lock()
+		cancel()
+		delete(q.cancels, job.ID)
+		t.running--
+		q.mu.Unlock()
+		q.notify()
+	}()
+
+	results, err := q.call(ctx, t.handler, &job)
+	q.finish(job, results, err)
+}
+
+// call runs a handler, turning a panic into a failed job
+func (q *Queue) call(ctx context.Context, handler Handler, job *models.Job) (results interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// finish stores a job's outcome. A job cancelled while it ran keeps its
+// cancelled status.
+func (q *Queue) finish(job models.Job, results interface{}, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{"completed_at": now}
+	if err != nil {
+		updates["status"] = StatusFailed
+		updates["error"] = err.Error()
+	} else {
+		data, merr := json.Marshal(results)
+		if merr != nil {
+			updates["status"] = StatusFailed
+			updates["error"] = fmt.Sprintf("failed to store results: %v", merr)
+		} else {
+			updates["status"] = StatusCompleted
+			updates["progress"] = 100
+			updates["results"] = string(data)
+		}
+	}
+	result := q.db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, StatusRunning).Updates(updates)
+	if result.Error != nil {
+		log.Printf("Jobs: failed to record outcome of job %s: %v", job.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// Cancelled meanwhile; Cancel already told the watchers, but close
+		// any watch opened since
+		q.mu.Lock()
+		q.endWatch(job.ID, StatusCancelled, "")
+		q.mu.Unlock()
+		return
+	}
+
+	errMsg, _ := updates["error"].(string)
+	q.mu.Lock()
+	q.endWatch(job.ID, updates["status"].(string), errMsg)
+	q.mu.Unlock()
+}
+
+// typeNames returns the registered types in a stable order. q.mu must be
+// held.
+func (q *Queue) typeNames() []string {
+	names := make([]string, 0, len(q.types))
+	for name := range q.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
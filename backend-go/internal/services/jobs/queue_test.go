@@ -0,0 +1,409 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"pbgui-backend/internal/models"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "jobs.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// waitStatus waits until a job has the given status
+func waitStatus(t *testing.T, db *gorm.DB, jobID, status string) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job models.Job
+		if err := db.First(&job, "id = ?", jobID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", jobID, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// blockingHandler runs until released or cancelled, recording which jobs
+// started
+type blockingHandler struct {
+	mu      sync.Mutex
+	started []string
+	running int
+	maxRun  int
+	release chan struct{}
+	entered chan string
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{release: make(chan struct{}), entered: make(chan string, 100)}
+}
+
+func (h *blockingHandler) handle(ctx context.Context, job *models.Job) (interface{}, error) {
+	h.mu.Lock()
+	h.started = append(h.started, job.ID)
+	h.running++
+	if h.running > h.maxRun {
+		h.maxRun = h.running
+	}
+	h.mu.Unlock()
+	h.entered <- job.ID
+
+	defer func() {
+		h.mu.Lock()
+		h.running--
+		h.mu.Unlock()
+	}()
+	select {
+	case <-h.release:
+		return map[string]string{"job": job.ID}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *blockingHandler) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case id := <-h.entered:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job started")
+		return ""
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int // of jobs "0", "1", ... queued in this order
+		want       []string
+	}{
+		{"first in, first out", []int{0, 0, 0}, []string{"0", "1", "2"}},
+		{"higher priority first", []int{0, 5, 1}, []string{"1", "2", "0"}},
+		{"equal priority keeps queue order", []int{0, 5, 0, 5}, []string{"1", "3", "0", "2"}},
+		{"negative priority last", []int{-1, 0}, []string{"1", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewQueue(db)
+			var mu sync.Mutex
+			var order []string
+			q.Register("backtest", 1, func(ctx context.Context, job *models.Job) (interface{}, error) {
+				mu.Lock()
+				order = append(order, job.ID)
+				mu.Unlock()
+				return nil, nil
+			})
+			for i, p := range tt.priorities {
+				if err := q.Enqueue(&models.Job{ID: fmt.Sprint(i), Type: "backtest", Priority: p}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := q.Start(); err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.priorities {
+				waitStatus(t, db, fmt.Sprint(i), StatusCompleted)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("ran %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		jobs        int
+	}{
+		{"one at a time", 1, 3},
+		{"two at a time", 2, 5},
+		{"below the limit", 4, 2},
+		{"limit raised to one", 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewQueue(db)
+			h := newBlockingHandler()
+			q.Register("optimize", tt.concurrency, h.handle)
+			for i := 0; i < tt.jobs; i++ {
+				if err := q.Enqueue(&models.Job{ID: fmt.Sprint(i), Type: "optimize"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := q.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			limit := tt.concurrency
+			if limit < 1 {
+				limit = 1
+			}
+			running := limit
+			if tt.jobs < running {
+				running = tt.jobs
+			}
+			for i := 0; i < running; i++ {
+				h.wait(t)
+			}
+			stats := q.Stats()
+			want := []TypeStats{{Type: "optimize", Concurrency: limit, Running: running, Queued: int64(tt.jobs - running)}}
+			if !reflect.DeepEqual(stats, want) {
+				t.Errorf("stats = %+v, want %+v", stats, want)
+			}
+
+			close(h.release)
+			for i := 0; i < tt.jobs; i++ {
+				waitStatus(t, db, fmt.Sprint(i), StatusCompleted)
+			}
+			if h.maxRun > limit {
+				t.Errorf("%d jobs ran at once, limit %d", h.maxRun, limit)
+			}
+		})
+	}
+}
+
+func TestQueueOutcome(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     Handler
+		wantStatus  string
+		wantError   string
+		wantResults string
+	}{
+		{
+			name: "results stored",
+			handler: func(ctx context.Context, job *models.Job) (interface{}, error) {
+				return map[string]int{"trades": 3}, nil
+			},
+			wantStatus: StatusCompleted, wantResults: `{"trades":3}`,
+		},
+		{
+			name:       "error",
+			handler:    func(ctx context.Context, job *models.Job) (interface{}, error) { return nil, errors.New("boom") },
+			wantStatus: StatusFailed, wantError: "boom",
+		},
+		{
+			name:       "panic",
+			handler:    func(ctx context.Context, job *models.Job) (interface{}, error) { panic("oops") },
+			wantStatus: StatusFailed, wantError: "job panicked: oops",
+		},
+		{
+			name:       "unstorable results",
+			handler:    func(ctx context.Context, job *models.Job) (interface{}, error) { return make(chan int), nil },
+			wantStatus: StatusFailed, wantError: "failed to store results: json: unsupported type: chan int",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewQueue(db)
+			q.Register("backtest", 1, tt.handler)
+			if err := q.Start(); err != nil {
+				t.Fatal(err)
+			}
+			job := &models.Job{Type: "backtest"}
+			if err := q.Enqueue(job); err != nil {
+				t.Fatal(err)
+			}
+
+			got := waitStatus(t, db, job.ID, tt.wantStatus)
+			if got.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", got.Error, tt.wantError)
+			}
+			if got.Results != tt.wantResults {
+				t.Errorf("results = %q, want %q", got.Results, tt.wantResults)
+			}
+			if got.Attempts != 1 || got.StartedAt == nil || got.CompletedAt == nil {
+				t.Errorf("attempts %d, started %v, completed %v", got.Attempts, got.StartedAt, got.CompletedAt)
+			}
+			if tt.wantStatus == StatusCompleted && got.Progress != 100 {
+				t.Errorf("progress = %d, want 100", got.Progress)
+			}
+		})
+	}
+}
+
+func TestQueueUnknownType(t *testing.T) {
+	q := NewQueue(newTestDB(t))
+	if err := q.Enqueue(&models.Job{Type: "nope"}); err == nil {
+		t.Error("job of an unregistered type was queued")
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	db := newTestDB(t)
+	q := NewQueue(db)
+	h := newBlockingHandler()
+	q.Register("backtest", 1, h.handle)
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	running := &models.Job{ID: "running", Type: "backtest"}
+	queued := &models.Job{ID: "queued", Type: "backtest"}
+	next := &models.Job{ID: "next", Type: "backtest"}
+	for _, job := range []*models.Job{running, queued, next} {
+		if err := q.Enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id := h.wait(t); id != "running" {
+		t.Fatalf("started %s first", id)
+	}
+
+	// A queued job never starts
+	if err := q.Cancel("queued"); err != nil {
+		t.Fatal(err)
+	}
+	// A running job's context is cancelled and it keeps the cancelled
+	// status although its handler returns an error
+	if err := q.Cancel("running"); err != nil {
+		t.Fatal(err)
+	}
+	if id := h.wait(t); id != "next" {
+		t.Fatalf("started %s after cancelling, want next", id)
+	}
+	close(h.release)
+	waitStatus(t, db, "next", StatusCompleted)
+
+	for _, id := range []string{"running", "queued"} {
+		job := waitStatus(t, db, id, StatusCancelled)
+		if job.Error != "" || job.CompletedAt == nil {
+			t.Errorf("%s: error %q, completed %v", id, job.Error, job.CompletedAt)
+		}
+	}
+	h.mu.Lock()
+	if want := []string{"running", "next"}; !reflect.DeepEqual(h.started, want) {
+		t.Errorf("started %v, want %v", h.started, want)
+	}
+	h.mu.Unlock()
+
+	for _, id := range []string{"running", "next"} {
+		if err := q.Cancel(id); err != ErrNotCancellable {
+			t.Errorf("Cancel(%s) = %v, want ErrNotCancellable", id, err)
+		}
+	}
+	q.mu.Lock()
+	if len(q.cancels) != 0 || len(q.watches) != 0 {
+		t.Errorf("%d cancels and %d watches left", len(q.cancels), len(q.watches))
+	}
+	q.mu.Unlock()
+}
+
+func TestQueueRecovery(t *testing.T) {
+	db := newTestDB(t)
+	created := time.Now().Add(-time.Hour)
+	started := created.Add(time.Minute)
+	leftover := []models.Job{
+		{ID: "interrupted", Type: "backtest", Status: StatusRunning, Progress: 40, Attempts: 1, CreatedAt: created, StartedAt: &started},
+		{ID: "waiting", Type: "backtest", Status: StatusQueued, CreatedAt: created.Add(time.Second)},
+		{ID: "urgent", Type: "backtest", Status: StatusQueued, Priority: 1, CreatedAt: created.Add(2 * time.Second)},
+		{ID: "done", Type: "backtest", Status: StatusCompleted, CreatedAt: created},
+		{ID: "other type", Type: "unregistered", Status: StatusRunning, CreatedAt: created},
+	}
+	if err := db.Create(&leftover).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueue(db)
+	var mu sync.Mutex
+	var order []string
+	q.Register("backtest", 1, func(ctx context.Context, job *models.Job) (interface{}, error) {
+		mu.Lock()
+		order = append(order, job.ID)
+		mu.Unlock()
+		return nil, nil
+	})
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"interrupted", "waiting", "urgent"} {
+		waitStatus(t, db, id, StatusCompleted)
+	}
+
+	mu.Lock()
+	if want := []string{"urgent", "interrupted", "waiting"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("ran %v, want %v", order, want)
+	}
+	mu.Unlock()
+
+	var interrupted, other models.Job
+	db.First(&interrupted, "id = ?", "interrupted")
+	if interrupted.Attempts != 2 {
+		t.Errorf("interrupted job has %d attempts, want 2", interrupted.Attempts)
+	}
+	db.First(&other, "id = ?", "other type")
+	if other.Status != StatusRunning {
+		t.Errorf("job of an unregistered type is %s, want it left alone", other.Status)
+	}
+}
+
+func TestQueuePosition(t *testing.T) {
+	db := newTestDB(t)
+	q := NewQueue(db)
+	q.Register("backtest", 1, func(ctx context.Context, job *models.Job) (interface{}, error) { return nil, nil })
+	q.Register("optimize", 1, func(ctx context.Context, job *models.Job) (interface{}, error) { return nil, nil })
+
+	// Not started, so everything stays queued
+	jobs := map[string]*models.Job{
+		"a": {ID: "a", Type: "backtest"},
+		"b": {ID: "b", Type: "backtest", Priority: 2},
+		"c": {ID: "c", Type: "backtest"},
+		"d": {ID: "d", Type: "optimize"},
+	}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := q.Enqueue(jobs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		job  models.Job
+		want int
+	}{
+		{*jobs["b"], 0},
+		{*jobs["a"], 1},
+		{*jobs["c"], 2},
+		{*jobs["d"], 0},
+		{models.Job{ID: "x", Type: "backtest", Status: StatusRunning}, -1},
+	}
+	for _, tt := range tests {
+		if got := q.Position(tt.job); got != tt.want {
+			t.Errorf("Position(%s) = %d, want %d", tt.job.ID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package passivbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"pbgui-backend/internal/models"
+)
+
+// apiKeyEntry is one user in passivbot's api-keys.json
+type apiKeyEntry struct {
+	Exchange   string `json:"exchange"`
+	Key        string `json:"key"`
+	Secret     string `json:"secret"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Subaccount string `json:"subaccount,omitempty"`
+}
+
+// AccountUser is the api-keys.json user an account's credentials are
+// written under
+func AccountUser(accountID string) string {
+	return "pbgui_" + accountID
+}
+
+// apiKeysPath is where an instance's api-keys.json is written. It lives in
+// the instance's workspace rather than the passivbot checkout, so
+// instances never share or overwrite each other's keys.
+func (r *Runner) apiKeysPath(instanceID string) string {
+	return r.workspace.instancePath(instanceID, "api-keys.json")
+}
+
+// writeAPIKeys writes the instance's credentials, readable only by the
+// backend's user
+func (r *Runner) writeAPIKeys(instance models.Instance) error {
+	creds := instance.Credentials
+	if creds == nil {
+		return fmt.Errorf("credentials for account %s are not attached", instance.AccountID)
+	}
+	data, err := json.MarshalIndent(map[string]apiKeyEntry{
+		creds.User: {
+			Exchange:   creds.Exchange,
+			Key:        creds.Key,
+			Secret:     creds.Secret,
+			Passphrase: creds.Passphrase,
+			Subaccount: creds.Subaccount,
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := r.workspace.InstanceDir(instance.ID); err != nil {
+		return err
+	}
+	return writeFileAtomic(r.apiKeysPath(instance.ID), data)
+}
+
+func (r *Runner) removeAPIKeys(instanceID string) {
+	if err := os.Remove(r.apiKeysPath(instanceID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove API keys for instance %s: %v", instanceID, err)
+	}
+}
+
+// injectAccount points a rendered config at the instance's api-keys.json
+func (r *Runner) injectAccount(config map[string]interface{}, instance models.Instance) {
+	user := AccountUser(instance.AccountID)
+	path := r.apiKeysPath(instance.ID)
+	if live, ok := config["live"].(map[string]interface{}); ok {
+		live["user"] = user
+		live["api_keys_filepath"] = path
+		return
+	}
+	if _, v7 := config["bot"]; v7 {
+		config["live"] = map[string]interface{}{"user": user, "api_keys_filepath": path}
+		return
+	}
+	// Older releases take the user at the top level
+	config["user"] = user
+	config["api_keys_filepath"] = path
+}
@@ -0,0 +1,218 @@
+package passivbot
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultSubscriberBuffer = 256
+	defaultMaxDropped       = 1024
+	defaultBacklogSize      = 1000
+)
+
+// LogHub fans out captured output lines to live subscribers, per instance.
+//
+// Each subscriber has a bounded buffer. Publishing never blocks: when a
+// subscriber's buffer is full the line is dropped for that subscriber only,
+// and a subscriber that keeps dropping maxDropped lines in a row is treated
+// as a slow consumer and disconnected by closing its channel.
+//
+// The hub also keeps the most recent backlogSize lines per instance so
+// reconnecting clients can resume without touching the log files.
+type LogHub struct {
+	bufferSize  int
+	maxDropped  int64
+	backlogSize int
+
+	mu      sync.RWMutex
+	subs    map[string]map[*Subscription]struct{}
+	backlog map[string]*lineRing
+}
+
+func NewLogHub(bufferSize, maxDropped, backlogSize int) *LogHub {
+	return &LogHub{
+		bufferSize:  bufferSize,
+		maxDropped:  int64(maxDropped),
+		backlogSize: backlogSize,
+		subs:        make(map[string]map[*Subscription]struct{}),
+		backlog:     make(map[string]*lineRing),
+	}
+}
+
+// Subscription receives live lines for one instance on C until closed
+type Subscription struct {
+	C <-chan LogLine
+
+	ch         chan LogLine
+	instanceID string
+	hub        *LogHub
+
+	dropped     atomic.Int64
+	consecutive atomic.Int64
+	evicted     atomic.Bool
+}
+
+// Dropped reports how many lines were discarded because the buffer was full
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Evicted reports whether the hub closed the subscription as a slow consumer
+func (s *Subscription) Evicted() bool {
+	return s.evicted.Load()
+}
+
+// Close unsubscribes; it is safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// Subscribe registers a new subscriber for an instance's output
+func (h *LogHub) Subscribe(instanceID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.subscribe(instanceID)
+}
+
+// SubscribeAfter registers a new subscriber and atomically returns the
+// buffered lines with Seq greater than after, so nothing published in
+// between is missed. covered reports whether the buffer reaches back far
+// enough that no older lines are needed from the log files.
+func (h *LogHub) SubscribeAfter(instanceID string, after uint64) (sub *Subscription, backlog []LogLine, covered bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ring := h.backlog[instanceID]; ring != nil && ring.len() > 0 {
+		backlog = ring.after(after)
+		covered = ring.oldest().Seq <= after+1
+	}
+	return h.subscribe(instanceID), backlog, covered
+}
+
+func (h *LogHub) subscribe(instanceID string) *Subscription {
+	ch := make(chan LogLine, h.bufferSize)
+	sub := &Subscription{C: ch, ch: ch, instanceID: instanceID, hub: h}
+
+	if h.subs[instanceID] == nil {
+		h.subs[instanceID] = make(map[*Subscription]struct{})
+	}
+	h.subs[instanceID][sub] = struct{}{}
+	return sub
+}
+
+// Publish records a line in the instance's backlog and delivers it to every
+// subscriber without blocking
+func (h *LogHub) Publish(instanceID string, line LogLine) {
+	var slow []*Subscription
+
+	h.mu.Lock()
+	ring := h.backlog[instanceID]
+	if ring == nil {
+		ring = newLineRing(h.backlogSize)
+		h.backlog[instanceID] = ring
+	}
+	ring.push(line)
+
+	for sub := range h.subs[instanceID] {
+		select {
+		case sub.ch <- line:
+			sub.consecutive.Store(0)
+		default:
+			sub.dropped.Add(1)
+			if n := sub.consecutive.Add(1); h.maxDropped > 0 && n >= h.maxDropped {
+				slow = append(slow, sub)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range slow {
+		sub.evicted.Store(true)
+		h.remove(sub)
+	}
+}
+
+// Forget drops an instance's backlog and closes its subscriptions, for
+// instances that were deleted
+func (h *LogHub) Forget(instanceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.backlog, instanceID)
+	for sub := range h.subs[instanceID] {
+		close(sub.ch)
+	}
+	delete(h.subs, instanceID)
+}
+
+// Subscribers returns the number of live subscribers for an instance
+func (h *LogHub) Subscribers(instanceID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs[instanceID])
+}
+
+func (h *LogHub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subs[sub.instanceID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.instanceID)
+	}
+	// Sends happen under the lock, so closing here cannot race with Publish
+	close(sub.ch)
+}
+
+// lineRing is a fixed-size circular buffer of the most recent lines
+type lineRing struct {
+	buf   []LogLine
+	start int
+	n     int
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{buf: make([]LogLine, size)}
+}
+
+func (r *lineRing) len() int {
+	return r.n
+}
+
+func (r *lineRing) push(line LogLine) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = line
+		r.n++
+		return
+	}
+	r.buf[r.start] = line
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+func (r *lineRing) at(i int) LogLine {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+func (r *lineRing) oldest() LogLine {
+	return r.at(0)
+}
+
+// after returns the buffered lines with Seq greater than seq, oldest first
+func (r *lineRing) after(seq uint64) []LogLine {
+	var out []LogLine
+	for i := 0; i < r.n; i++ {
+		if line := r.at(i); line.Seq > seq {
+			out = append(out, line)
+		}
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package passivbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+// publishSeqs publishes lines with the given sequence numbers
+func publishSeqs(h *LogHub, instanceID string, from, to uint64) {
+	for seq := from; seq <= to; seq++ {
+		h.Publish(instanceID, LogLine{Seq: seq, Stream: "stdout"})
+	}
+}
+
+// received drains what is buffered on a subscription without blocking
+func received(sub *Subscription) []uint64 {
+	var out []uint64
+	for {
+		select {
+		case l, ok := <-sub.C:
+			if !ok {
+				return out
+			}
+			out = append(out, l.Seq)
+		default:
+			return out
+		}
+	}
+}
+
+func TestLineRing(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		pushed uint64
+		after  uint64
+		want   []uint64
+	}{
+		{"not full", 5, 3, 0, seqRange(1, 3)},
+		{"wrapped keeps the newest", 5, 12, 0, seqRange(8, 12)},
+		{"after filters", 5, 12, 10, seqRange(11, 12)},
+		{"after everything", 5, 12, 12, nil},
+		{"zero size keeps nothing", 0, 3, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newLineRing(tt.size)
+			for seq := uint64(1); seq <= tt.pushed; seq++ {
+				r.push(LogLine{Seq: seq})
+			}
+			if got := seqs(r.after(tt.after)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("after(%d) = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogHubDelivery(t *testing.T) {
+	h := NewLogHub(10, 0, 10)
+	a := h.Subscribe("a")
+	b := h.Subscribe("a")
+	other := h.Subscribe("b")
+
+	publishSeqs(h, "a", 1, 3)
+	for name, sub := range map[string]*Subscription{"first": a, "second": b} {
+		if got := received(sub); !reflect.DeepEqual(got, seqRange(1, 3)) {
+			t.Errorf("%s subscriber got %v, want %v", name, got, seqRange(1, 3))
+		}
+	}
+	if got := received(other); len(got) != 0 {
+		t.Errorf("other instance got %v", got)
+	}
+
+	a.Close()
+	a.Close()
+	if n := h.Subscribers("a"); n != 1 {
+		t.Errorf("%d subscribers after close, want 1", n)
+	}
+}
+
+func TestLogHubSlowConsumer(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDropped  int
+		published   uint64
+		wantDropped int64
+		wantEvicted bool
+	}{
+		{"fits the buffer", 3, 4, 0, false},
+		{"drops without eviction", 3, 6, 2, false},
+		{"evicted after max dropped in a row", 3, 7, 3, true},
+		{"never evicted without a maximum", 0, 20, 16, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewLogHub(4, tt.maxDropped, 100)
+			sub := h.Subscribe("a")
+			publishSeqs(h, "a", 1, tt.published)
+
+			if got := sub.Dropped(); got != tt.wantDropped {
+				t.Errorf("dropped %d, want %d", got, tt.wantDropped)
+			}
+			if got := sub.Evicted(); got != tt.wantEvicted {
+				t.Errorf("evicted = %v, want %v", got, tt.wantEvicted)
+			}
+			// An evicted subscriber still gets what was buffered, then a closed channel
+			if got := received(sub); !reflect.DeepEqual(got, seqRange(1, 4)) {
+				t.Errorf("received %v, want %v", got, seqRange(1, 4))
+			}
+			wantSubs := 1
+			if tt.wantEvicted {
+				wantSubs = 0
+			}
+			if n := h.Subscribers("a"); n != wantSubs {
+				t.Errorf("%d subscribers, want %d", n, wantSubs)
+			}
+		})
+	}
+}
+
+func TestLogHubConsecutiveDrops(t *testing.T) {
+	h := NewLogHub(2, 3, 100)
+	sub := h.Subscribe("a")
+
+	// Two drops, then reading makes room and a delivery resets the count
+	publishSeqs(h, "a", 1, 4)
+	received(sub)
+	publishSeqs(h, "a", 5, 8)
+	if sub.Evicted() {
+		t.Fatal("evicted although drops were not consecutive")
+	}
+	if got := sub.Dropped(); got != 4 {
+		t.Errorf("dropped %d, want 4", got)
+	}
+}
+
+func TestLogHubSubscribeAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		published   uint64
+		after       uint64
+		want        []uint64
+		wantCovered bool
+	}{
+		{"nothing published", 0, 0, nil, false},
+		{"from the start", 3, 0, seqRange(1, 3), true},
+		{"resume in the middle", 8, 5, seqRange(6, 8), true},
+		{"up to date", 8, 8, nil, true},
+		{"one line short of the backlog", 15, 9, seqRange(11, 15), false},
+		{"backlog does not reach back", 15, 3, seqRange(11, 15), false},
+		{"backlog starts at the next line", 15, 10, seqRange(11, 15), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewLogHub(10, 0, 5)
+			publishSeqs(h, "a", 1, tt.published)
+
+			sub, backlog, covered := h.SubscribeAfter("a", tt.after)
+			defer sub.Close()
+			if got := seqs(backlog); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("backlog = %v, want %v", got, tt.want)
+			}
+			if covered != tt.wantCovered {
+				t.Errorf("covered = %v, want %v", covered, tt.wantCovered)
+			}
+
+			// Lines published afterwards arrive live
+			h.Publish("a", LogLine{Seq: tt.published + 1})
+			if got := received(sub); !reflect.DeepEqual(got, []uint64{tt.published + 1}) {
+				t.Errorf("live lines = %v, want [%d]", got, tt.published+1)
+			}
+		})
+	}
+}
+
+func TestLogHubForget(t *testing.T) {
+	h := NewLogHub(10, 0, 10)
+	publishSeqs(h, "a", 1, 3)
+	sub := h.Subscribe("a")
+
+	h.Forget("a")
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription still open after Forget")
+	}
+	sub.Close()
+	if _, backlog, _ := h.SubscribeAfter("a", 0); len(backlog) != 0 {
+		t.Errorf("backlog survived Forget: %v", seqs(backlog))
+	}
+}
+
+func TestResumeLogs(t *testing.T) {
+	tests := []struct {
+		name  string
+		after uint64
+		want  []uint64
+	}{
+		{"from the backlog", 17, seqRange(18, 20)},
+		{"backlog and persisted log", 5, seqRange(6, 20)},
+		{"everything", 0, seqRange(1, 20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Runner{
+				logs: NewLogStore(t.TempDir(), RotationPolicy{}),
+				hub:  NewLogHub(10, 0, 4),
+			}
+			for i := 0; i < 20; i++ {
+				r.appendLog("a", LogLine{Time: testLogStart, Stream: "stdout", Text: "tick"})
+			}
+
+			sub, lines, err := r.ResumeLogs("a", tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+			if got := seqs(lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("seqs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,640 @@
+package passivbot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activeLogName    = "passivbot.log"
+	segmentPrefix    = "passivbot-"
+	segmentTimestamp = "20060102T150405.000000000"
+	maxLogLineBytes  = 64 * 1024
+)
+
+var ErrInvalidInstanceID = errors.New("invalid instance id")
+
+// LogLine is a single line of captured process output. Seq increases
+// monotonically per instance, across process restarts and log rotation.
+type LogLine struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"` // stdout, stderr, system
+	Text   string    `json:"text"`
+
+	// Parsed from Text by ParseLogLine; not persisted
+	LogEntry
+}
+
+// encode renders the line in the on-disk format: "<seq> <RFC3339Nano> <stream> <text>\n"
+func (l LogLine) encode() []byte {
+	return []byte(fmt.Sprintf("%d %s %s %s\n", l.Seq, l.Time.UTC().Format(time.RFC3339Nano), l.Stream, l.Text))
+}
+
+func decodeLogLine(raw string) LogLine {
+	if parts := strings.SplitN(raw, " ", 4); len(parts) == 4 {
+		if seq, err := strconv.ParseUint(parts[0], 10, 64); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, parts[1]); err == nil {
+				return LogLine{Seq: seq, Time: t, Stream: parts[2], Text: parts[3]}
+			}
+		}
+	}
+	// Lines written before sequence numbers were introduced
+	if parts := strings.SplitN(raw, " ", 3); len(parts) == 3 {
+		if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+			return LogLine{Time: t, Stream: parts[1], Text: parts[2]}
+		}
+	}
+	// Not written by us (e.g. edited by hand); keep the raw text
+	return LogLine{Stream: "stdout", Text: raw}
+}
+
+// LogQuery selects lines from an instance's persisted logs.
+// Tail returns the last N matching lines; otherwise Limit caps the result
+// counting from the oldest match. Zero times and sequence bounds leave the
+// range open.
+type LogQuery struct {
+	Since     time.Time
+	Until     time.Time
+	AfterSeq  uint64
+	BeforeSeq uint64
+	Filter    LogFilter
+	Tail      int
+	Limit     int
+}
+
+// match reports whether l passes the query. Lines are only parsed here when
+// the filter needs their structured fields.
+func (q LogQuery) match(l *LogLine) bool {
+	if !q.matchesRaw(*l) {
+		return false
+	}
+	if q.Filter.Empty() {
+		return true
+	}
+	*l = ParseLogLine(*l)
+	return q.Filter.Matches(*l)
+}
+
+func (q LogQuery) matchesRaw(l LogLine) bool {
+	if q.AfterSeq > 0 && l.Seq <= q.AfterSeq {
+		return false
+	}
+	if q.BeforeSeq > 0 && l.Seq >= q.BeforeSeq {
+		return false
+	}
+	if l.Time.IsZero() {
+		return q.Since.IsZero() && q.Until.IsZero()
+	}
+	if !q.Since.IsZero() && l.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && l.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// RotationPolicy controls when instance log segments are rotated and pruned
+type RotationPolicy struct {
+	MaxSize       int64         // rotate the active segment once it exceeds this many bytes
+	MaxSegmentAge time.Duration // rotate the active segment once it has been open this long
+	MaxBackups    int           // keep at most this many rotated segments
+	MaxAge        time.Duration // delete rotated segments older than this
+}
+
+// LogStore persists captured process output as rotating per-instance log
+// files under dir/<instanceID>/. Rotated segments are gzipped.
+type LogStore struct {
+	dir    string
+	policy RotationPolicy
+
+	mu    sync.Mutex
+	files map[string]*rotatingFile
+}
+
+func NewLogStore(dir string, policy RotationPolicy) *LogStore {
+	return &LogStore{
+		dir:    dir,
+		policy: policy,
+		files:  make(map[string]*rotatingFile),
+	}
+}
+
+// Dir returns the directory holding an instance's log segments
+func (s *LogStore) Dir(instanceID string) (string, error) {
+	if !validInstanceID(instanceID) {
+		return "", ErrInvalidInstanceID
+	}
+	return filepath.Join(s.dir, instanceID), nil
+}
+
+// Has reports whether the instance has a log directory
+func (s *LogStore) Has(instanceID string) bool {
+	dir, err := s.Dir(instanceID)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// Append assigns the line the instance's next sequence number and writes it
+// to the active segment, rotating first if needed. If then is non-nil it is
+// called with the sequenced line before the next Append for the instance can
+// proceed, so observers see lines in sequence order. It is called even when
+// the write fails.
+func (s *LogStore) Append(instanceID string, line LogLine, then func(LogLine)) error {
+	for {
+		f, err := s.file(instanceID)
+		if err != nil {
+			return err
+		}
+		// A file closed after it was looked up takes no more lines; the
+		// next lookup opens a fresh one that continues its sequence
+		if err := f.append(line, then); err != errFileClosed {
+			return err
+		}
+	}
+}
+
+// Close releases the instance's active segment. Later appends reopen it.
+// The file is closed under the same lock appends look it up with, so no
+// append can still write to it once a new one is opened.
+func (s *LogStore) Close(instanceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[instanceID]
+	if !ok {
+		return nil
+	}
+	delete(s.files, instanceID)
+	return f.close()
+}
+
+// Read returns persisted lines for an instance in chronological order
+func (s *LogStore) Read(instanceID string, q LogQuery) ([]LogLine, error) {
+	segs, err := s.segments(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	segs = overlapping(segs, q.Since, q.Until)
+
+	if q.Tail > 0 {
+		// Walk newest to oldest so only the segments we need get decompressed
+		var out []LogLine
+		for i := len(segs) - 1; i >= 0 && len(out) < q.Tail; i-- {
+			var lines []LogLine
+			reachedAfter := false
+			err := scanSegment(segs[i], func(l LogLine) bool {
+				if q.match(&l) {
+					lines = append(lines, l)
+				}
+				if q.AfterSeq > 0 && l.Seq > 0 && l.Seq <= q.AfterSeq {
+					reachedAfter = true
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+			if need := q.Tail - len(out); len(lines) > need {
+				lines = lines[len(lines)-need:]
+			}
+			out = append(lines, out...)
+			// Sequence numbers are monotonic, so older segments cannot match
+			if reachedAfter {
+				break
+			}
+		}
+		return parseAll(out), nil
+	}
+
+	var out []LogLine
+	for _, seg := range segs {
+		err := scanSegment(seg, func(l LogLine) bool {
+			if q.match(&l) {
+				out = append(out, l)
+			}
+			return q.Limit <= 0 || len(out) < q.Limit
+		})
+		if err != nil {
+			return nil, err
+		}
+		if q.Limit > 0 && len(out) >= q.Limit {
+			break
+		}
+	}
+	return parseAll(out), nil
+}
+
+// parseAll fills in structured fields for lines the filter did not parse
+func parseAll(lines []LogLine) []LogLine {
+	for i := range lines {
+		if lines[i].Level == "" {
+			lines[i] = ParseLogLine(lines[i])
+		}
+	}
+	return lines
+}
+
+// Export writes the persisted lines matching q to w in the on-disk format,
+// oldest first, without holding them in memory
+func (s *LogStore) Export(instanceID string, q LogQuery, w io.Writer) error {
+	segs, err := s.segments(instanceID)
+	if err != nil {
+		return err
+	}
+
+	var writeErr error
+	for _, seg := range overlapping(segs, q.Since, q.Until) {
+		err := scanSegment(seg, func(l LogLine) bool {
+			if q.match(&l) {
+				_, writeErr = w.Write(l.encode())
+			}
+			return writeErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+func (s *LogStore) file(instanceID string) (*rotatingFile, error) {
+	dir, err := s.Dir(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[instanceID]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	seq, err := s.lastSeq(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{dir: dir, policy: s.policy, seq: seq}
+	s.files[instanceID] = f
+	return f, nil
+}
+
+// lastSeq returns the highest sequence number persisted for an instance
+func (s *LogStore) lastSeq(instanceID string) (uint64, error) {
+	segs, err := s.segments(instanceID)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(segs) - 1; i >= 0; i-- {
+		var last uint64
+		err := scanSegment(segs[i], func(l LogLine) bool {
+			if l.Seq > last {
+				last = l.Seq
+			}
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+		if last > 0 {
+			return last, nil
+		}
+	}
+	return 0, nil
+}
+
+// segment is one log file on disk covering (start, end]
+type segment struct {
+	path  string
+	start time.Time
+	end   time.Time // zero for the active segment
+	gz    bool
+}
+
+// segments lists an instance's log files, oldest first, with the active segment last
+func (s *LogStore) segments(instanceID string) ([]segment, error) {
+	dir, err := s.Dir(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := rotatedSegments(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	segs := rotated
+	active := filepath.Join(dir, activeLogName)
+	if _, err := os.Stat(active); err == nil {
+		segs = append(segs, segment{path: active})
+	}
+	for i := 1; i < len(segs); i++ {
+		segs[i].start = segs[i-1].end
+	}
+	return segs, nil
+}
+
+func rotatedSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byStamp := make(map[string]segment)
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, segmentPrefix)
+		gz := strings.HasSuffix(stamp, ".log.gz")
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ".log")
+		end, err := time.Parse(segmentTimestamp, stamp)
+		if err != nil {
+			continue
+		}
+		// While a segment is being compressed both files exist; the plain one is complete
+		if prev, ok := byStamp[stamp]; ok && !prev.gz {
+			continue
+		}
+		byStamp[stamp] = segment{path: filepath.Join(dir, name), end: end, gz: gz}
+	}
+
+	segs := make([]segment, 0, len(byStamp))
+	for _, seg := range byStamp {
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].end.Before(segs[j].end) })
+	return segs, nil
+}
+
+func overlapping(segs []segment, since, until time.Time) []segment {
+	var out []segment
+	for _, seg := range segs {
+		if !since.IsZero() && !seg.end.IsZero() && seg.end.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !seg.start.IsZero() && seg.start.After(until) {
+			continue
+		}
+		out = append(out, seg)
+	}
+	return out
+}
+
+// scanSegment calls fn for each line in the segment until fn returns false
+func scanSegment(seg segment, fn func(LogLine) bool) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Pruned or compressed between listing and reading
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if seg.gz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", seg.path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*maxLogLineBytes)
+	for scanner.Scan() {
+		if !fn(decodeLogLine(scanner.Text())) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// rotatingFile is the active segment of one instance's log
+type rotatingFile struct {
+	dir    string
+	policy RotationPolicy
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	seq      uint64
+	closed   bool // released by LogStore.Close
+}
+
+var errFileClosed = errors.New("log file closed")
+
+func (f *rotatingFile) append(line LogLine, then func(LogLine)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return errFileClosed
+	}
+	f.seq++
+	line.Seq = f.seq
+	err := f.write(line.encode())
+	if then != nil {
+		then(line)
+	}
+	return err
+}
+
+func (f *rotatingFile) write(p []byte) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) shouldRotate(next int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.policy.MaxSize > 0 && f.size+int64(next) > f.policy.MaxSize {
+		return true
+	}
+	return f.policy.MaxSegmentAge > 0 && time.Since(f.openedAt) > f.policy.MaxSegmentAge
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(filepath.Join(f.dir, activeLogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	if f.size > 0 {
+		// Reopening an existing segment; its age is best approximated by the last write
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	rotated := filepath.Join(f.dir, segmentPrefix+time.Now().UTC().Format(segmentTimestamp)+".log")
+	if err := os.Rename(filepath.Join(f.dir, activeLogName), rotated); err != nil {
+		return err
+	}
+
+	go func() {
+		// A burst of rotations can prune a segment before it is compressed
+		if err := compressSegment(rotated); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to compress log segment %s: %v", rotated, err)
+		}
+		pruneSegments(f.dir, f.policy)
+	}()
+
+	return f.open()
+}
+
+func (f *rotatingFile) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneSegments removes rotated segments beyond MaxBackups or older than MaxAge
+func pruneSegments(dir string, policy RotationPolicy) {
+	segs, err := rotatedSegments(dir)
+	if err != nil {
+		return
+	}
+
+	for i, seg := range segs {
+		tooMany := policy.MaxBackups > 0 && i < len(segs)-policy.MaxBackups
+		tooOld := policy.MaxAge > 0 && time.Since(seg.end) > policy.MaxAge
+		if tooMany || tooOld {
+			os.Remove(seg.path)
+		}
+	}
+}
+
+func validInstanceID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// lineWriter splits process output into lines and hands each one to emit.
+// exec.Cmd copies each stream from a single goroutine, so no locking is needed.
+type lineWriter struct {
+	emit func(string)
+	buf  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLogLineBytes {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing partial line
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(strings.TrimRight(string(w.buf), "\r"))
+		w.buf = w.buf[:0]
+	}
+}
@@ -0,0 +1,235 @@
+package passivbot
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLogStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// appendLines appends n lines one second apart, starting at testLogStart
+// plus offset seconds
+func appendLines(t *testing.T, s *LogStore, instanceID string, offset, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		line := LogLine{
+			Time:   testLogStart.Add(time.Duration(offset+i) * time.Second),
+			Stream: "stdout",
+			Text:   fmt.Sprintf("line %d", offset+i),
+		}
+		if err := s.Append(instanceID, line, nil); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+func seqs(lines []LogLine) []uint64 {
+	var out []uint64
+	for _, l := range lines {
+		out = append(out, l.Seq)
+	}
+	return out
+}
+
+func seqRange(from, to uint64) []uint64 {
+	var out []uint64
+	for s := from; s <= to; s++ {
+		out = append(out, s)
+	}
+	return out
+}
+
+// waitCompressed waits until no rotated segment is left uncompressed
+func waitCompressed(t *testing.T, dir string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		plain, _ := filepath.Glob(filepath.Join(dir, segmentPrefix+"*.log"))
+		tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		if len(plain) == 0 && len(tmp) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("rotated segments in %s were not compressed", dir)
+}
+
+func TestLogStoreSeq(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string // "append", "close" or "reopen" (a new store on the same directory)
+		want  []uint64
+	}{
+		{"single run", []string{"append", "append"}, seqRange(1, 6)},
+		{"continues after close", []string{"append", "close", "append"}, seqRange(1, 6)},
+		{"continues after restart", []string{"append", "reopen", "append", "reopen", "append"}, seqRange(1, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := NewLogStore(dir, RotationPolicy{})
+			offset := 0
+			for _, step := range tt.steps {
+				switch step {
+				case "append":
+					appendLines(t, s, "bot", offset, 3)
+					offset += 3
+				case "close":
+					s.Close("bot")
+				case "reopen":
+					s.Close("bot")
+					s = NewLogStore(dir, RotationPolicy{})
+				}
+			}
+			lines, err := s.Read("bot", LogQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := seqs(lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("seqs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogStoreRotation(t *testing.T) {
+	// Lines are 37 or 38 bytes on disk, so each segment holds two
+	tests := []struct {
+		name         string
+		policy       RotationPolicy
+		lines        int
+		wantSegments int
+		wantFirst    uint64
+	}{
+		{"no rotation", RotationPolicy{}, 10, 0, 1},
+		{"by size", RotationPolicy{MaxSize: 80}, 10, 4, 1},
+		{"pruned to max backups", RotationPolicy{MaxSize: 80, MaxBackups: 2}, 10, 2, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLogStore(t.TempDir(), tt.policy)
+			appendLines(t, s, "bot", 0, tt.lines)
+			dir, _ := s.Dir("bot")
+			waitCompressed(t, dir)
+			// Pruning runs after compressing each segment, so run it once
+			// more now that all of them are done
+			pruneSegments(dir, tt.policy)
+
+			rotated, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*.log.gz"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rotated) != tt.wantSegments {
+				t.Errorf("%d rotated segments, want %d", len(rotated), tt.wantSegments)
+			}
+
+			lines, err := s.Read("bot", LogQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := seqs(lines), seqRange(tt.wantFirst, uint64(tt.lines)); !reflect.DeepEqual(got, want) {
+				t.Errorf("seqs = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLogStoreRead(t *testing.T) {
+	s := NewLogStore(t.TempDir(), RotationPolicy{MaxSize: 300})
+	appendLines(t, s, "bot", 0, 20)
+	dir, _ := s.Dir("bot")
+	waitCompressed(t, dir)
+
+	at := func(sec int) time.Time { return testLogStart.Add(time.Duration(sec) * time.Second) }
+	tests := []struct {
+		name  string
+		query LogQuery
+		want  []uint64
+	}{
+		{"all", LogQuery{}, seqRange(1, 20)},
+		{"limit counts from the oldest", LogQuery{Limit: 3}, seqRange(1, 3)},
+		{"tail", LogQuery{Tail: 4}, seqRange(17, 20)},
+		{"tail longer than the log", LogQuery{Tail: 50}, seqRange(1, 20)},
+		{"after seq", LogQuery{AfterSeq: 15}, seqRange(16, 20)},
+		{"before seq", LogQuery{BeforeSeq: 4}, seqRange(1, 3)},
+		{"tail after seq", LogQuery{AfterSeq: 18, Tail: 5}, seqRange(19, 20)},
+		{"time range", LogQuery{Since: at(5), Until: at(7)}, seqRange(6, 8)},
+		{"limit after seq", LogQuery{AfterSeq: 10, Limit: 2}, []uint64{11, 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := s.Read("bot", tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := seqs(lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("seqs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogStoreInvalidInstanceID(t *testing.T) {
+	s := NewLogStore(t.TempDir(), RotationPolicy{})
+	for _, id := range []string{"", ".", "..", "../x", `a\b`} {
+		if err := s.Append(id, LogLine{Text: "x"}, nil); err != ErrInvalidInstanceID {
+			t.Errorf("Append(%q) = %v, want ErrInvalidInstanceID", id, err)
+		}
+	}
+}
+
+func TestDecodeLogLine(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name string
+		raw  string
+		want LogLine
+	}{
+		{"current format", "7 2024-03-01T12:00:00.0000005Z stderr some text", LogLine{Seq: 7, Time: ts, Stream: "stderr", Text: "some text"}},
+		{"without seq", "2024-03-01T12:00:00.0000005Z stdout some text", LogLine{Time: ts, Stream: "stdout", Text: "some text"}},
+		{"foreign line", "edited by hand", LogLine{Stream: "stdout", Text: "edited by hand"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeLogLine(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeLogLine(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+
+	// Round trip through the on-disk format
+	l := LogLine{Seq: 3, Time: ts, Stream: "system", Text: "started"}
+	if got := decodeLogLine(strings.TrimSuffix(string(l.encode()), "\n")); !reflect.DeepEqual(got, l) {
+		t.Errorf("round trip = %+v, want %+v", got, l)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{"whole lines", []string{"a\nb\n"}, []string{"a", "b"}},
+		{"split across writes", []string{"he", "llo\nwor", "ld\n"}, []string{"hello", "world"}},
+		{"crlf", []string{"a\r\n"}, []string{"a"}},
+		{"partial line flushed", []string{"a\nrest"}, []string{"a", "rest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			w := &lineWriter{emit: func(s string) { got = append(got, s) }}
+			for _, s := range tt.writes {
+				w.Write([]byte(s))
+			}
+			w.Flush()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package passivbot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Operation statuses
+const (
+	OperationRunning   = "running"
+	OperationCompleted = "completed"
+)
+
+// Operation item statuses
+const (
+	ItemPending   = "pending"
+	ItemRunning   = "running"
+	ItemSucceeded = "succeeded"
+	ItemFailed    = "failed"
+)
+
+const (
+	defaultBulkWorkers = 4
+
+	// Completed operations stay queryable this long
+	operationRetention = time.Hour
+
+	operationUpdateBuffer = 64
+)
+
+// OperationItem is the progress of one instance within an operation
+type OperationItem struct {
+	InstanceID string     `json:"instance_id"`
+	Status     string     `json:"status"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// OperationSnapshot is a consistent view of an operation's progress
+type OperationSnapshot struct {
+	ID          string          `json:"id"`
+	Action      string          `json:"action"`
+	Status      string          `json:"status"`
+	Total       int             `json:"total"`
+	Done        int             `json:"done"`
+	Failed      int             `json:"failed"`
+	Items       []OperationItem `json:"items"`
+	CreatedAt   time.Time       `json:"created_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Operation is a batch of instance actions executed by the runner's worker
+// pool. Its progress can be polled with Snapshot or followed with Watch.
+type Operation struct {
+	mu   sync.Mutex
+	snap OperationSnapshot
+	subs map[chan OperationSnapshot]struct{}
+}
+
+// Snapshot returns the operation's current progress
+func (op *Operation) Snapshot() OperationSnapshot {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.snapshot()
+}
+
+func (op *Operation) snapshot() OperationSnapshot {
+	s := op.snap
+	s.Items = append([]OperationItem(nil), op.snap.Items...)
+	return s
+}
+
+// Watch returns the current progress and a channel receiving a snapshot
+// after every change. The channel is closed when the operation completes;
+// intermediate snapshots may be skipped for a slow reader, so the final
+// state should be read with Snapshot after the channel closes.
+func (op *Operation) Watch() (OperationSnapshot, <-chan OperationSnapshot, func()) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	ch := make(chan OperationSnapshot, operationUpdateBuffer)
+	if op.snap.Status == OperationCompleted {
+		close(ch)
+		return op.snapshot(), ch, func() {}
+	}
+	op.subs[ch] = struct{}{}
+	cancel := func() {
+		op.mu.Lock()
+		defer op.mu.Unlock()
+		if _, ok := op.subs[ch]; ok {
+			delete(op.subs, ch)
+			close(ch)
+		}
+	}
+	return op.snapshot(), ch, cancel
+}
+
+// update applies fn to item i and notifies watchers. op.mu must not be held.
+func (op *Operation) update(i int, fn func(item *OperationItem)) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	item := &op.snap.Items[i]
+	fn(item)
+	if item.Status == ItemSucceeded || item.Status == ItemFailed {
+		op.snap.Done++
+		if item.Status == ItemFailed {
+			op.snap.Failed++
+		}
+	}
+	if op.snap.Done == op.snap.Total {
+		now := time.Now()
+		op.snap.Status = OperationCompleted
+		op.snap.CompletedAt = &now
+	}
+
+	s := op.snapshot()
+	for ch := range op.subs {
+		select {
+		case ch <- s:
+		default:
+		}
+		if s.Status == OperationCompleted {
+			delete(op.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (op *Operation) expired(now time.Time) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.snap.CompletedAt != nil && now.Sub(*op.snap.CompletedAt) > operationRetention
+}
+
+// SetBulkWorkers sets the size of the worker pool used by RunOperation. It
+// must be called before the first operation runs.
+func (r *Runner) SetBulkWorkers(n int) {
+	if n > 0 {
+		r.bulkWorkers = n
+	}
+}
+
+// RunOperation applies fn to each instance through the bounded worker pool
+// and returns immediately. fn returns a short message on success.
+func (r *Runner) RunOperation(action string, instanceIDs []string, fn func(instanceID string) (string, error)) *Operation {
+	r.startWorkers()
+
+	now := time.Now()
+	op := &Operation{
+		snap: OperationSnapshot{
+			ID:        uuid.New().String(),
+			Action:    action,
+			Status:    OperationRunning,
+			Total:     len(instanceIDs),
+			Items:     make([]OperationItem, len(instanceIDs)),
+			CreatedAt: now,
+		},
+		subs: make(map[chan OperationSnapshot]struct{}),
+	}
+	for i, id := range instanceIDs {
+		op.snap.Items[i] = OperationItem{InstanceID: id, Status: ItemPending}
+	}
+	if len(instanceIDs) == 0 {
+		op.snap.Status = OperationCompleted
+		op.snap.CompletedAt = &now
+	}
+
+	r.opsMu.Lock()
+	for id, old := range r.operations {
+		if old.expired(now) {
+			delete(r.operations, id)
+		}
+	}
+	r.operations[op.snap.ID] = op
+	r.opsMu.Unlock()
+
+	// Queue items in order; the feeder blocks while every worker is busy
+	go func() {
+		for i, id := range instanceIDs {
+			i, id := i, id
+			r.tasks <- func() {
+				op.update(i, func(item *OperationItem) {
+					started := time.Now()
+					item.Status = ItemRunning
+					item.StartedAt = &started
+				})
+				msg, err := fn(id)
+				op.update(i, func(item *OperationItem) {
+					finished := time.Now()
+					item.FinishedAt = &finished
+					if err != nil {
+						item.Status = ItemFailed
+						item.Error = err.Error()
+						return
+					}
+					item.Status = ItemSucceeded
+					item.Message = msg
+				})
+			}
+		}
+	}()
+	return op
+}
+
+// Operation looks up an operation started by RunOperation
+func (r *Runner) Operation(id string) (*Operation, bool) {
+	r.opsMu.Lock()
+	defer r.opsMu.Unlock()
+	op, ok := r.operations[id]
+	return op, ok
+}
+
+func (r *Runner) startWorkers() {
+	r.workersOnce.Do(func() {
+		for i := 0; i < r.bulkWorkers; i++ {
+			go func() {
+				for task := range r.tasks {
+					task()
+				}
+			}()
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package passivbot
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+// Log levels, matching Python's logging module
+const (
+	LevelDebug    = "DEBUG"
+	LevelInfo     = "INFO"
+	LevelWarning  = "WARNING"
+	LevelError    = "ERROR"
+	LevelCritical = "CRITICAL"
+)
+
+// Event types recognized in passivbot output
+const (
+	EventOrderCreated   = "order_created"
+	EventOrderCancelled = "order_cancelled"
+	EventOrderFilled    = "order_filled"
+	EventPositionChange = "position_change"
+	EventError          = "error"
+	EventDisconnect     = "exchange_disconnect"
+)
+
+var (
+	LogLevels = []string{LevelDebug, LevelInfo, LevelWarning, LevelError, LevelCritical}
+	LogEvents = []string{EventOrderCreated, EventOrderCancelled, EventOrderFilled, EventPositionChange, EventError, EventDisconnect}
+)
+
+// LogEntry is the structured form of a passivbot log line
+type LogEntry struct {
+	LoggedAt *time.Time `json:"logged_at,omitempty"` // timestamp written by passivbot itself
+	Level    string     `json:"level"`
+	Symbol   string     `json:"symbol,omitempty"`
+	Event    string     `json:"event,omitempty"`
+	Message  string     `json:"message"` // text without the timestamp and level prefix
+}
+
+var (
+	// passivbot configures logging as "%(asctime)s %(levelname)-8s %(message)s";
+	// older releases use Python's default asctime with milliseconds
+	prefixPattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)\s+(DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL)\b\s*(.*)$`)
+	levelOnly     = regexp.MustCompile(`^(DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL)[:\s]\s*(.*)$`)
+
+	// ccxt unified symbols (BTC/USDT:USDT) and exchange-native ones (BTCUSDT)
+	unifiedSymbol = regexp.MustCompile(`\b([A-Z0-9]{2,20})/([A-Z]{2,6})(?::[A-Z]{2,6})?\b`)
+	nativeSymbol  = regexp.MustCompile(`\b([A-Z0-9]{2,20}?)(USDT|USDC|BUSD|USD)\b`)
+
+	// Events. A fill is only the line passivbot logs for one, "filled <symbol>
+	// <side> <qty> <position side> @ <price>" padded to line up with other
+	// order actions, or "[fill] ..." in older releases.
+	disconnectPattern = regexp.MustCompile(`(?i)(disconnect|connection (lost|closed|reset|refused)|websocket.*(closed|error)|reconnect|NetworkError|RequestTimeout|ExchangeNotAvailable)`)
+	cancelPattern     = regexp.MustCompile(`(?i)(^\[cancel|\bcancel(l)?(ing|ed)\b.*\border|\border.*\bcancel(l)?(ing|ed)\b)`)
+	fillPattern       = regexp.MustCompile(`(?i)^\s*(\[fill\]|(new )?fill(ed)?)\s+\S+.*\s@\s*\d`)
+	createPattern     = regexp.MustCompile(`(?i)(^\[(order|create)|\b(creat|post|plac)(e|ed|ing)\b.*\border|\border.*\b(creat|post|plac)(e|ed|ing)\b)`)
+	positionPattern   = regexp.MustCompile(`(?i)(^\[pos|\bpos(ition)?s?\b.*(->|\bchange|\bnew\b|\bclosed\b)|\bnew pos)`)
+	tracebackPattern  = regexp.MustCompile(`^(Traceback \(most recent call last\)|\s+File ".*", line \d+|(\w+\.)*\w*(Error|Exception): )`)
+)
+
+// ParseLogLine fills in the structured fields of a captured line
+func ParseLogLine(line LogLine) LogLine {
+	line.LogEntry = ParseLogText(line.Stream, line.Text)
+	return line
+}
+
+// ParseLogText extracts timestamp, level, symbol and event from one line of
+// passivbot output. Lines without an explicit level default to INFO, or
+// WARNING on stderr; tracebacks are ERROR.
+func ParseLogText(stream, text string) LogEntry {
+	entry := LogEntry{Message: text}
+
+	if m := prefixPattern.FindStringSubmatch(text); m != nil {
+		entry.LoggedAt = parseLoggedAt(m[1])
+		entry.Level = normalizeLevel(m[2])
+		entry.Message = m[3]
+	} else if m := levelOnly.FindStringSubmatch(text); m != nil {
+		entry.Level = normalizeLevel(m[1])
+		entry.Message = m[2]
+	}
+
+	traceback := tracebackPattern.MatchString(text)
+	if entry.Level == "" {
+		switch {
+		case traceback:
+			entry.Level = LevelError
+		case stream == "stderr":
+			entry.Level = LevelWarning
+		default:
+			entry.Level = LevelInfo
+		}
+	}
+
+	entry.Symbol = findSymbol(entry.Message)
+	entry.Event = classifyEvent(entry.Level, entry.Message, traceback)
+	return entry
+}
+
+func classifyEvent(level, msg string, traceback bool) string {
+	switch {
+	case disconnectPattern.MatchString(msg):
+		return EventDisconnect
+	case cancelPattern.MatchString(msg):
+		return EventOrderCancelled
+	case fillPattern.MatchString(msg):
+		return EventOrderFilled
+	case createPattern.MatchString(msg):
+		return EventOrderCreated
+	case positionPattern.MatchString(msg):
+		return EventPositionChange
+	case traceback || level == LevelError || level == LevelCritical:
+		return EventError
+	}
+	return ""
+}
+
+func findSymbol(msg string) string {
+	if m := unifiedSymbol.FindStringSubmatch(msg); m != nil {
+		return m[0]
+	}
+	if m := nativeSymbol.FindString(msg); m != "" {
+		return m
+	}
+	return ""
+}
+
+func parseLoggedAt(value string) *time.Time {
+	value = strings.Replace(value, ",", ".", 1)
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func normalizeLevel(level string) string {
+	if level == "WARN" {
+		return LevelWarning
+	}
+	return level
+}
+
+// NormalizeSymbol reduces unified and native symbols to one comparable form,
+// e.g. "BTC/USDT:USDT" and "btcusdt" both become "BTCUSDT"
+func NormalizeSymbol(symbol string) string {
+	symbol = strings.ToUpper(symbol)
+	if i := strings.Index(symbol, ":"); i >= 0 {
+		symbol = symbol[:i]
+	}
+	return strings.ReplaceAll(symbol, "/", "")
+}
+
+// LogFilter restricts lines by parsed fields. Empty lists match everything.
+type LogFilter struct {
+	Levels  []string
+	Symbols []string
+	Events  []string
+}
+
+// Empty reports whether the filter matches every line
+func (f LogFilter) Empty() bool {
+	return len(f.Levels) == 0 && len(f.Symbols) == 0 && len(f.Events) == 0
+}
+
+// Matches reports whether a parsed line passes the filter. A symbol filter
+// matches the full symbol or just its base coin ("BTC" matches "BTC/USDT"
+// and "BTCUSDT").
+func (f LogFilter) Matches(l LogLine) bool {
+	if len(f.Levels) > 0 && !containsFold(f.Levels, l.Level) {
+		return false
+	}
+	if len(f.Events) > 0 && !containsFold(f.Events, l.Event) {
+		return false
+	}
+	if len(f.Symbols) > 0 {
+		if l.Symbol == "" {
+			return false
+		}
+		symbol := NormalizeSymbol(l.Symbol)
+		base := pbconfig.CoinName(symbol)
+		matched := false
+		for _, want := range f.Symbols {
+			want = NormalizeSymbol(want)
+			if want == symbol || want == base {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
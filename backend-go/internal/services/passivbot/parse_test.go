@@ -0,0 +1,193 @@
+package passivbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogText(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     string
+		text       string
+		wantLevel  string
+		wantSymbol string
+		wantEvent  string
+		wantMsg    string
+		wantLogged string // local time, empty if none
+	}{
+		{
+			name:   "passivbot prefix",
+			stream: "stdout", text: "2024-03-01T12:00:00 INFO     starting bot",
+			wantLevel: LevelInfo, wantMsg: "starting bot", wantLogged: "2024-03-01 12:00:00",
+		},
+		{
+			name:   "python default asctime with milliseconds",
+			stream: "stdout", text: "2024-03-01 12:00:00,123 WARNING  rate limited",
+			wantLevel: LevelWarning, wantMsg: "rate limited", wantLogged: "2024-03-01 12:00:00.123",
+		},
+		{
+			name:   "WARN is WARNING",
+			stream: "stdout", text: "WARN: low balance",
+			wantLevel: LevelWarning, wantMsg: "low balance",
+		},
+		{
+			name:   "no level on stdout",
+			stream: "stdout", text: "hello",
+			wantLevel: LevelInfo, wantMsg: "hello",
+		},
+		{
+			name:   "no level on stderr",
+			stream: "stderr", text: "hello",
+			wantLevel: LevelWarning, wantMsg: "hello",
+		},
+		{
+			name:   "traceback",
+			stream: "stderr", text: "Traceback (most recent call last):",
+			wantLevel: LevelError, wantEvent: EventError, wantMsg: "Traceback (most recent call last):",
+		},
+		{
+			name:   "exception line",
+			stream: "stderr", text: "KeyError: 'BTCUSDT'",
+			wantLevel: LevelError, wantSymbol: "BTCUSDT", wantEvent: EventError, wantMsg: "KeyError: 'BTCUSDT'",
+		},
+		{
+			name:   "order created with unified symbol",
+			stream: "stdout", text: "2024-03-01T12:00:00 INFO     creating order BTC/USDT:USDT long 0.001 @ 60000",
+			wantLevel: LevelInfo, wantSymbol: "BTC/USDT:USDT", wantEvent: EventOrderCreated,
+			wantMsg: "creating order BTC/USDT:USDT long 0.001 @ 60000", wantLogged: "2024-03-01 12:00:00",
+		},
+		{
+			name:   "order cancelled with native symbol",
+			stream: "stdout", text: "INFO cancelled order ETHUSDT short 0.1",
+			wantLevel: LevelInfo, wantSymbol: "ETHUSDT", wantEvent: EventOrderCancelled, wantMsg: "cancelled order ETHUSDT short 0.1",
+		},
+		{
+			name:   "fill",
+			stream: "stdout", text: "[fill] SOL/USDT:USDT long 1.5 @ 100",
+			wantLevel: LevelInfo, wantSymbol: "SOL/USDT:USDT", wantEvent: EventOrderFilled, wantMsg: "[fill] SOL/USDT:USDT long 1.5 @ 100",
+		},
+		{
+			name:   "passivbot fill",
+			stream: "stdout", text: "2024-03-01T12:00:00 INFO        filled BTC/USDT:USDT buy 0.001 long @ 60000.0 source: WS",
+			wantLevel: LevelInfo, wantSymbol: "BTC/USDT:USDT", wantEvent: EventOrderFilled,
+			wantMsg: "filled BTC/USDT:USDT buy 0.001 long @ 60000.0 source: WS", wantLogged: "2024-03-01 12:00:00",
+		},
+		{
+			name:   "fills mentioned without a fill",
+			stream: "stdout", text: "INFO no fills yet for BTCUSDT",
+			wantLevel: LevelInfo, wantSymbol: "BTCUSDT", wantMsg: "no fills yet for BTCUSDT",
+		},
+		{
+			name:   "fill count",
+			stream: "stdout", text: "filled orders: 0",
+			wantLevel: LevelInfo, wantMsg: "filled orders: 0",
+		},
+		{
+			name:   "position change",
+			stream: "stdout", text: "new pos XRPUSDT long 10 @ 0.5",
+			wantLevel: LevelInfo, wantSymbol: "XRPUSDT", wantEvent: EventPositionChange, wantMsg: "new pos XRPUSDT long 10 @ 0.5",
+		},
+		{
+			name:   "disconnect wins over error level",
+			stream: "stdout", text: "ERROR websocket closed, reconnecting",
+			wantLevel: LevelError, wantEvent: EventDisconnect, wantMsg: "websocket closed, reconnecting",
+		},
+		{
+			name:   "error level without pattern",
+			stream: "stdout", text: "CRITICAL: giving up",
+			wantLevel: LevelCritical, wantEvent: EventError, wantMsg: "giving up",
+		},
+		{
+			name:   "level word inside text",
+			stream: "stdout", text: "no ERROR here",
+			wantLevel: LevelInfo, wantMsg: "no ERROR here",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLogText(tt.stream, tt.text)
+			if got.Level != tt.wantLevel {
+				t.Errorf("level = %q, want %q", got.Level, tt.wantLevel)
+			}
+			if got.Symbol != tt.wantSymbol {
+				t.Errorf("symbol = %q, want %q", got.Symbol, tt.wantSymbol)
+			}
+			if got.Event != tt.wantEvent {
+				t.Errorf("event = %q, want %q", got.Event, tt.wantEvent)
+			}
+			if got.Message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", got.Message, tt.wantMsg)
+			}
+
+			switch {
+			case tt.wantLogged == "" && got.LoggedAt != nil:
+				t.Errorf("logged at %v, want none", got.LoggedAt)
+			case tt.wantLogged != "":
+				want, _ := time.ParseInLocation("2006-01-02 15:04:05.999", tt.wantLogged, time.Local)
+				if got.LoggedAt == nil || !got.LoggedAt.Equal(want) {
+					t.Errorf("logged at %v, want %v", got.LoggedAt, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeSymbol(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"BTC/USDT:USDT", "BTCUSDT"},
+		{"btc/usdt", "BTCUSDT"},
+		{"btcusdt", "BTCUSDT"},
+		{"ETH", "ETH"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeSymbol(tt.in); got != tt.want {
+			t.Errorf("NormalizeSymbol(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogFilterMatches(t *testing.T) {
+	line := ParseLogLine(LogLine{Stream: "stdout", Text: "ERROR creating order BTC/USDT:USDT failed"})
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   bool
+	}{
+		{"empty", LogFilter{}, true},
+		{"level", LogFilter{Levels: []string{"error"}}, true},
+		{"other level", LogFilter{Levels: []string{LevelInfo, LevelWarning}}, false},
+		{"event", LogFilter{Events: []string{EventOrderCreated}}, true},
+		{"other event", LogFilter{Events: []string{EventOrderFilled}}, false},
+		{"full symbol", LogFilter{Symbols: []string{"BTCUSDT"}}, true},
+		{"unified symbol", LogFilter{Symbols: []string{"btc/usdt:usdt"}}, true},
+		{"base coin", LogFilter{Symbols: []string{"BTC"}}, true},
+		{"other coin", LogFilter{Symbols: []string{"ETH"}}, false},
+		{"all must match", LogFilter{Levels: []string{LevelError}, Symbols: []string{"ETH"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(line); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A coin matches native symbols too
+	native := ParseLogLine(LogLine{Stream: "stdout", Text: "cancelled order BTCUSDT long"})
+	for _, want := range []string{"BTC", "btc", "BTC/USDT:USDT", "BTCUSDT"} {
+		if !(LogFilter{Symbols: []string{want}}).Matches(native) {
+			t.Errorf("%q did not match %s", want, native.Symbol)
+		}
+	}
+	if (LogFilter{Symbols: []string{"BT"}}).Matches(native) {
+		t.Errorf("\"BT\" matched %s", native.Symbol)
+	}
+
+	// Lines without a symbol never pass a symbol filter
+	if (LogFilter{Symbols: []string{"BTC"}}).Matches(ParseLogLine(LogLine{Text: "hello"})) {
+		t.Error("line without symbol matched a symbol filter")
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !unix
+
+package passivbot
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// On Windows FindProcess opens a handle and fails for unknown PIDs
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// processArgs is unavailable without /proc; identity cannot be verified
+func processArgs(pid int) (args []string, ok bool) {
+	return nil, false
+}
+
+// detach is a no-op here; the process is still independent of the backend's
+// lifetime because its output goes to files rather than pipes
+func detach(cmd *exec.Cmd) {}
+
+// stopSignals is the escalation Stop walks through; without POSIX signals
+// the process can only be killed
+var stopSignals = []os.Signal{os.Kill}
+
+// signalGroup signals the process; there are no process groups here
+func signalGroup(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	return p.Signal(sig)
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package passivbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	// EPERM means it exists but belongs to someone else
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	return !zombie(pid)
+}
+
+// zombie reports whether the process has exited but not been reaped yet,
+// as happens to adopted processes until init collects them
+func zombie(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name, which may contain spaces
+	i := bytes.LastIndexByte(data, ')')
+	return i >= 0 && i+2 < len(data) && data[i+2] == 'Z'
+}
+
+// processArgs returns a process's command line from /proc. ok is false
+// where /proc is unavailable, in which case identity cannot be verified.
+func processArgs(pid int) (args []string, ok bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, false
+	}
+	for _, arg := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args, true
+}
+
+// detach starts the process in its own session, so it neither receives
+// signals aimed at the backend's process group nor dies with the backend
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// stopSignals is the escalation Stop walks through
+var stopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL}
+
+// signalGroup signals the process group led by pid, which includes any
+// children passivbot spawned. Processes not started as group leaders are
+// signalled directly.
+func signalGroup(pid int, sig os.Signal) error {
+	s := sig.(syscall.Signal)
+	if err := syscall.Kill(-pid, s); err == nil {
+		return nil
+	}
+	return syscall.Kill(pid, s)
+}
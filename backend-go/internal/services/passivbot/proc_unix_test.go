@@ -0,0 +1,34 @@
+//go:build unix
+
+package passivbot
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"pbgui-backend/internal/models"
+)
+
+// Instances run in their own session, so signals aimed at the backend's
+// process group, such as Ctrl-C in its terminal, do not reach them
+func TestStartDetached(t *testing.T) {
+	r := newTestRunner(t, "trap 'exit 0' INT TERM\nwhile :; do sleep 0.05; done\n")
+	if err := r.Start(models.Instance{ID: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	proc, _ := r.processes.Load("bot")
+	pid := proc.(*process).pid
+
+	sid, err := unix.Getsid(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own, _ := unix.Getsid(0); sid != pid || sid == own {
+		t.Errorf("session %d, want the process's own (%d)", sid, pid)
+	}
+	if _, err := r.Stop("bot", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
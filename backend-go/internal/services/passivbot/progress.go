@@ -0,0 +1,134 @@
+package passivbot
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Progress callbacks are rate limited; the final 100% always goes out
+	progressInterval = 250 * time.Millisecond
+
+	// How much of a backtest's output is kept for error messages
+	outputTailSize = 64 * 1024
+)
+
+// BacktestProgress is how far a running backtest is, as read from its
+// output
+type BacktestProgress struct {
+	Stage     string        // "downloading" while fetching candles, then "backtesting"
+	Fraction  float64       // of the current stage, 0 to 1
+	Processed int64         // items done, when the output counts them
+	Total     int64         // items in the stage, when known
+	ETA       time.Duration // until the stage completes; 0 when unknown
+}
+
+var (
+	// "450/1000" as printed by tqdm and passivbot's own counters, but not
+	// dates like 2024/01/01
+	countPattern   = regexp.MustCompile(`(?:^|[^\d/.])(\d+)\s*/\s*(\d+)(?:[^\d/.]|$)`)
+	percentPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	stagePattern   = regexp.MustCompile(`(?i)download|fetch`)
+)
+
+// parseProgress reads a progress report from one output line. Counts are
+// preferred over percentages, which tqdm prints next to them.
+func parseProgress(line string) (p BacktestProgress, ok bool) {
+	p.Stage = "backtesting"
+	if stagePattern.MatchString(line) {
+		p.Stage = "downloading"
+	}
+
+	for _, m := range countPattern.FindAllStringSubmatch(line, -1) {
+		done, err1 := strconv.ParseInt(m[1], 10, 64)
+		total, err2 := strconv.ParseInt(m[2], 10, 64)
+		if err1 != nil || err2 != nil || total <= 0 || done > total {
+			continue
+		}
+		p.Processed, p.Total = done, total
+		p.Fraction = float64(done) / float64(total)
+		return p, true
+	}
+	if m := percentPattern.FindStringSubmatch(line); m != nil {
+		pct, err := strconv.ParseFloat(m[1], 64)
+		if err == nil && pct <= 100 {
+			p.Fraction = pct / 100
+			return p, true
+		}
+	}
+	return p, false
+}
+
+// progressWriter receives a backtest's output, reports progress found in
+// it and keeps its tail. Lines end at "\n" or at the "\r" progress bars
+// redraw with.
+type progressWriter struct {
+	mu       sync.Mutex
+	partial  []byte
+	tail     []byte
+	onUpdate func(BacktestProgress)
+
+	stage      string
+	stageStart time.Time
+	lastEmit   time.Time
+	lastTenth  int // last reported progress in tenths of a percent
+}
+
+func newProgressWriter(onUpdate func(BacktestProgress)) *progressWriter {
+	return &progressWriter{onUpdate: onUpdate, lastTenth: -1}
+}
+
+func (w *progressWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.tail = append(w.tail, data...)
+	if len(w.tail) > outputTailSize {
+		w.tail = w.tail[len(w.tail)-outputTailSize:]
+	}
+
+	w.partial = append(w.partial, data...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+// Output returns the last part of everything written
+func (w *progressWriter) Output() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.TrimSpace(string(w.tail))
+}
+
+func (w *progressWriter) line(line string) {
+	p, ok := parseProgress(line)
+	if !ok || w.onUpdate == nil {
+		return
+	}
+
+	now := time.Now()
+	if p.Stage != w.stage {
+		w.stage, w.stageStart, w.lastTenth = p.Stage, now, -1
+	}
+	tenth := int(p.Fraction * 1000)
+	if tenth == w.lastTenth || (tenth < 1000 && now.Sub(w.lastEmit) < progressInterval) {
+		return
+	}
+	// Too early an estimate swings wildly, so wait for 1%
+	if p.Fraction >= 0.01 && p.Fraction < 1 {
+		elapsed := now.Sub(w.stageStart)
+		p.ETA = time.Duration(float64(elapsed) * (1 - p.Fraction) / p.Fraction).Round(time.Second)
+	}
+	w.lastTenth, w.lastEmit = tenth, now
+	w.onUpdate(p)
+}
@@ -0,0 +1,134 @@
+package passivbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   BacktestProgress
+		wantOK bool
+	}{
+		{
+			name:   "tqdm bar",
+			line:   " 45%|████▌     | 450/1000 [00:05<00:06, 90.00it/s]",
+			want:   BacktestProgress{Stage: "backtesting", Fraction: 0.45, Processed: 450, Total: 1000},
+			wantOK: true,
+		},
+		{
+			name:   "download counter",
+			line:   "Downloading candles for BTC 3/12",
+			want:   BacktestProgress{Stage: "downloading", Fraction: 0.25, Processed: 3, Total: 12},
+			wantOK: true,
+		},
+		{
+			name:   "fetch is downloading",
+			line:   "fetching ohlcvs 1 / 4",
+			want:   BacktestProgress{Stage: "downloading", Fraction: 0.25, Processed: 1, Total: 4},
+			wantOK: true,
+		},
+		{
+			name:   "percent only",
+			line:   "backtest 12.5% done",
+			want:   BacktestProgress{Stage: "backtesting", Fraction: 0.125},
+			wantOK: true,
+		},
+		{
+			name:   "complete",
+			line:   "100%|██████████| 1000/1000",
+			want:   BacktestProgress{Stage: "backtesting", Fraction: 1, Processed: 1000, Total: 1000},
+			wantOK: true,
+		},
+		{
+			name:   "date is not a count",
+			line:   "2024-01-01T00:00:00 start date 2024/01/01",
+			want:   BacktestProgress{Stage: "backtesting"},
+			wantOK: false,
+		},
+		{
+			name:   "ratio is not a count",
+			line:   "ratio 1.5/2.5",
+			want:   BacktestProgress{Stage: "backtesting"},
+			wantOK: false,
+		},
+		{
+			name:   "more done than total skipped",
+			line:   "seen 7/3 then 2/4",
+			want:   BacktestProgress{Stage: "backtesting", Fraction: 0.5, Processed: 2, Total: 4},
+			wantOK: true,
+		},
+		{
+			name:   "zero total",
+			line:   "0/0",
+			want:   BacktestProgress{Stage: "backtesting"},
+			wantOK: false,
+		},
+		{
+			name:   "percent over 100",
+			line:   "gain 250%",
+			want:   BacktestProgress{Stage: "backtesting"},
+			wantOK: false,
+		},
+		{
+			name:   "plain output",
+			line:   "loading config",
+			want:   BacktestProgress{Stage: "backtesting"},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseProgress(tt.line)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseProgress(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []float64 // fractions reported
+	}{
+		{"carriage returns", []string{"10/100\r20/100\r"}, []float64{0.1}},
+		{"split across writes", []string{"1", "0/100", "\n"}, []float64{0.1}},
+		{"unfinished line", []string{"10/100"}, nil},
+		{"completion always reported", []string{"10/100\r", "11/100\r", "100/100\r"}, []float64{0.1, 1}},
+		{"same progress once", []string{"100/100\r", "100/100\n"}, []float64{1}},
+		{"no progress", []string{"starting\nloading\n"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			w := newProgressWriter(func(p BacktestProgress) { got = append(got, p.Fraction) })
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write = %d, %v", n, err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressWriterOutput(t *testing.T) {
+	w := newProgressWriter(nil)
+	w.Write([]byte("  Traceback\nValueError: bad\n"))
+	if got := w.Output(); got != "Traceback\nValueError: bad" {
+		t.Errorf("Output = %q", got)
+	}
+
+	w.Write([]byte(strings.Repeat("x", outputTailSize) + "end"))
+	got := w.Output()
+	if len(got) != outputTailSize || !strings.HasSuffix(got, "end") {
+		t.Errorf("Output keeps %d bytes ending %q, want the last %d", len(got), got[len(got)-3:], outputTailSize)
+	}
+}
@@ -0,0 +1,259 @@
+package passivbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+const adoptedPollInterval = time.Second
+
+var errAdoptedExit = errors.New("process exited while adopted; exit code unavailable")
+
+// PIDInfo is the content of an instance's PID file, written at start so a
+// restarted backend can find processes it launched earlier
+type PIDInfo struct {
+	InstanceID string    `json:"instance_id"`
+	PID        int       `json:"pid"`
+	StartedAt  time.Time `json:"started_at"`
+	ConfigPath string    `json:"config_path"`
+	Command    []string  `json:"command"`
+}
+
+// Alive reports whether the recorded process still exists and is still
+// passivbot running this instance's config, guarding against PID reuse
+func (info PIDInfo) Alive() bool {
+	if !processAlive(info.PID) {
+		return false
+	}
+	args, ok := processArgs(info.PID)
+	if !ok {
+		return true
+	}
+	for _, arg := range args {
+		if arg == info.ConfigPath {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) pidPath(instanceID string) string {
+	return filepath.Join(r.runDir, instanceID+".pid")
+}
+
+func (r *Runner) writePIDFile(info PIDInfo) error {
+	if err := os.MkdirAll(r.runDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.pidPath(info.InstanceID), data, 0644)
+}
+
+func (r *Runner) removePIDFile(instanceID string) {
+	if err := os.Remove(r.pidPath(instanceID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove PID file for instance %s: %v", instanceID, err)
+	}
+}
+
+// PIDFiles returns every PID file in the run directory, keyed by instance
+// ID. Files whose recorded instance does not match their name are skipped,
+// since the ID is used to build paths.
+func (r *Runner) PIDFiles() (map[string]PIDInfo, error) {
+	entries, err := os.ReadDir(r.runDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]PIDInfo{}, nil
+		}
+		return nil, err
+	}
+
+	infos := make(map[string]PIDInfo)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".pid") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(r.runDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var info PIDInfo
+		if err := json.Unmarshal(data, &info); err != nil || info.InstanceID == "" {
+			continue
+		}
+		if id := strings.TrimSuffix(e.Name(), ".pid"); info.InstanceID != id || !validInstanceID(id) {
+			log.Printf("Ignoring PID file %s: it records instance %q", e.Name(), info.InstanceID)
+			continue
+		}
+		infos[info.InstanceID] = info
+	}
+	return infos, nil
+}
+
+// RemoveStalePIDFile deletes the PID, output and API key files of an
+// instance the runner is not supervising
+func (r *Runner) RemoveStalePIDFile(instanceID string) {
+	if !validInstanceID(instanceID) {
+		return
+	}
+	if _, ok := r.processes.Load(instanceID); ok {
+		return
+	}
+	r.removeSpools(instanceID)
+	r.removePIDFile(instanceID)
+	r.removeAPIKeys(instanceID)
+}
+
+// Supervised reports whether the runner has a process for the instance or
+// an automatic restart pending, and which of the two
+func (r *Runner) Supervised(instanceID string) (status string, ok bool) {
+	if _, ok := r.processes.Load(instanceID); ok {
+		return StatusRunning, true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st := r.restarts[instanceID]; st != nil && st.timer != nil {
+		return StatusRestarting, true
+	}
+	return "", false
+}
+
+// Resume starts an instance found without a process, as Start does, unless
+// that changed since the caller looked: a process was started, a restart is
+// pending or an exit is being handled, or wanted, which should re-read the
+// stored status, reports the instance is no longer meant to run. wanted is
+// called under r.mu, so a stop or crash cannot slip in between. It returns
+// false when the instance was left alone.
+func (r *Runner) Resume(instance models.Instance, wanted func() bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.idle(instance.ID) || !wanted() {
+		return false, nil
+	}
+	r.cancelRestart(instance.ID, true)
+	p, err := r.start(instance)
+	if err != nil {
+		return false, err
+	}
+	r.emit(StateEvent{InstanceID: instance.ID, Status: StatusRunning, Time: p.startedAt})
+	return true, nil
+}
+
+// IfIdle calls fn if nothing supervises the instance, while nothing can
+// start, stop or reap it. It reports whether fn was called.
+func (r *Runner) IfIdle(instanceID string, fn func()) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.idle(instanceID) {
+		return false
+	}
+	fn()
+	return true
+}
+
+// idle reports whether the instance has no process, no pending restart and
+// no exit being handled. r.mu must be held.
+func (r *Runner) idle(instanceID string) bool {
+	if _, ok := r.processes.Load(instanceID); ok {
+		return false
+	}
+	if st := r.restarts[instanceID]; st != nil && st.timer != nil {
+		return false
+	}
+	return r.exiting[instanceID] == 0
+}
+
+// beginExit and endExit bracket handling a process exit, from forgetting the
+// process to recording the resulting status
+func (r *Runner) beginExit(instanceID string) {
+	r.mu.Lock()
+	r.exiting[instanceID]++
+	r.mu.Unlock()
+}
+
+func (r *Runner) endExit(instanceID string) {
+	r.mu.Lock()
+	if r.exiting[instanceID]--; r.exiting[instanceID] <= 0 {
+		delete(r.exiting, instanceID)
+	}
+	r.mu.Unlock()
+}
+
+// Adopt takes over supervision of a still-alive passivbot process started
+// by an earlier run of the backend, using its PID file. It returns false if
+// there is no PID file or the recorded process is gone; a stale PID file is
+// removed. Adopted processes are polled for liveness since they are not our
+// children, so their exit code is unknown.
+func (r *Runner) Adopt(instance models.Instance) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.processes.Load(instance.ID); ok {
+		return true, nil
+	}
+
+	data, err := os.ReadFile(r.pidPath(instance.ID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var info PIDInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		r.removePIDFile(instance.ID)
+		return false, fmt.Errorf("corrupt PID file for instance %s: %w", instance.ID, err)
+	}
+	if !info.Alive() {
+		// Exited while the backend was away; keep what it wrote meanwhile
+		r.drainSpools(instance.ID)
+		r.logSystem(instance.ID, fmt.Sprintf("passivbot (pid %d) exited while the backend was not running", info.PID))
+		r.logs.Close(instance.ID)
+		r.removePIDFile(instance.ID)
+		r.removeAPIKeys(instance.ID)
+		return false, nil
+	}
+
+	p := newProcess(instance, info.PID, info.StartedAt)
+	p.adopted = true
+	r.logSystem(instance.ID, fmt.Sprintf("re-adopted passivbot (pid %d) started at %s", info.PID, info.StartedAt.Format(time.RFC3339)))
+	p.tails = r.tailSpools(instance.ID)
+	r.processes.Store(instance.ID, p)
+	go r.watchAdopted(p)
+	return true, nil
+}
+
+// watchAdopted waits for an adopted process to exit
+func (r *Runner) watchAdopted(p *process) {
+	ticker := time.NewTicker(adoptedPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if processAlive(p.pid) {
+			continue
+		}
+
+		instanceID := p.instance.ID
+		r.beginExit(instanceID)
+		r.release(p)
+		r.logSystem(instanceID, errAdoptedExit.Error())
+		r.logs.Close(instanceID)
+		r.handleExit(p, -1, errAdoptedExit)
+		r.endExit(instanceID)
+		p.exited(-1)
+		return
+	}
+}
@@ -0,0 +1,147 @@
+package passivbot
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// leaveRunning starts an instance the way Start does, but without
+// supervising it, as a backend that has since exited would have left it
+func (r *testRunner) leaveRunning(t *testing.T, instance models.Instance) PIDInfo {
+	t.Helper()
+	configPath, err := r.createConfigFile(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := r.openSpools(instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(r.pythonPath, filepath.Join(r.pbPath, "main.py"), "--config", configPath)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	detach(cmd)
+	err = cmd.Start()
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reaped as init would once the backend is gone
+	go cmd.Wait()
+	t.Cleanup(func() { signalGroup(cmd.Process.Pid, os.Kill) })
+
+	info := PIDInfo{InstanceID: instance.ID, PID: cmd.Process.Pid, StartedAt: time.Now(), ConfigPath: configPath, Command: cmd.Args}
+	if err := r.writePIDFile(info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// touch creates a file the fake passivbot waits for next to its config
+func (r *testRunner) touch(t *testing.T, instanceID, name string) {
+	t.Helper()
+	if err := os.WriteFile(r.workspace.instancePath(instanceID, name), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitDead waits for a process to exit
+func waitDead(t *testing.T, info PIDInfo) {
+	t.Helper()
+	for i := 0; i < 500 && info.Alive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info.Alive() {
+		t.Fatalf("process %d still running", info.PID)
+	}
+}
+
+// adoptableBot runs until SIGINT or until a file named exit appears next
+// to its config
+const adoptableBot = `trap 'exit 0' INT TERM
+until [ -e "$(dirname "$2")/exit" ]; do sleep 0.05; done
+exit 2
+`
+
+// A restarted backend takes over the processes the previous one left
+// running, notices when they exit and can stop them
+func TestAdoptAfterRestart(t *testing.T) {
+	before := newTestRunner(t, adoptableBot)
+	crashes := models.Instance{ID: "crashes", RestartPolicy: RestartNever}
+	stopped := models.Instance{ID: "stopped", RestartPolicy: RestartAlways}
+	gone := models.Instance{ID: "gone"}
+	crashesInfo := before.leaveRunning(t, crashes)
+	stoppedInfo := before.leaveRunning(t, stopped)
+	goneInfo := before.leaveRunning(t, gone)
+	before.touch(t, gone.ID, "exit")
+	waitDead(t, goneInfo)
+
+	r := runnerIn(t, before.dir)
+	for _, instance := range []models.Instance{crashes, stopped, crashes} {
+		if ok, err := r.Adopt(instance); !ok || err != nil {
+			t.Fatalf("Adopt(%s) = %v, %v", instance.ID, ok, err)
+		}
+	}
+	if status, ok := r.Supervised(crashes.ID); !ok || status != StatusRunning {
+		t.Errorf("adopted instance %s, supervised %v", status, ok)
+	}
+
+	// One that exited meanwhile is not adopted and its PID file goes
+	if ok, err := r.Adopt(gone); ok || err != nil {
+		t.Errorf("Adopt(gone) = %v, %v", ok, err)
+	}
+	if _, err := os.Stat(r.pidPath(gone.ID)); !os.IsNotExist(err) {
+		t.Errorf("PID file of an exited process kept: %v", err)
+	}
+	if texts := logTexts(t, r.Runner, gone.ID); len(texts) == 0 || !strings.Contains(texts[len(texts)-1], "exited while the backend was not running") {
+		t.Errorf("gone instance log %q", texts)
+	}
+
+	// Exit codes of processes that are not our children are unknown, so
+	// an adopted process ending on its own counts as a failure
+	r.touch(t, crashes.ID, "exit")
+	ev := r.waitEvent(t, crashes.ID, StatusError)
+	if ev.Error != errAdoptedExit.Error() {
+		t.Errorf("error %q, want %q", ev.Error, errAdoptedExit)
+	}
+	if _, ok := r.Supervised(crashes.ID); ok {
+		t.Error("exited instance still supervised")
+	}
+	if _, err := os.Stat(r.pidPath(crashes.ID)); !os.IsNotExist(err) {
+		t.Errorf("PID file of an exited process kept: %v", err)
+	}
+	waitDead(t, crashesInfo)
+
+	result, err := r.Stop(stopped.ID, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Graceful || result.ExitCode != nil {
+		t.Errorf("stop result %+v, want graceful with unknown exit code", result)
+	}
+	waitDead(t, stoppedInfo)
+	if ev, _ := r.lastEvent(stopped.ID); ev.Status != StatusStopped {
+		t.Errorf("stopped instance is %s", ev.Status)
+	}
+}
+
+// A recorded PID now used by another program is not adopted
+func TestAdoptReusedPID(t *testing.T) {
+	r := newTestRunner(t, "")
+	info := PIDInfo{InstanceID: "bot", PID: os.Getpid(), StartedAt: time.Now(), ConfigPath: filepath.Join(r.dir, "config.json")}
+	if err := r.writePIDFile(info); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.Adopt(models.Instance{ID: "bot"}); ok || err != nil {
+		t.Errorf("Adopt = %v, %v", ok, err)
+	}
+	if _, ok := r.Supervised("bot"); ok {
+		t.Error("another program's process is supervised")
+	}
+}
@@ -0,0 +1,165 @@
+package passivbot
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+const usageSampleInterval = 5 * time.Second
+
+// procStat is what the runner reads about a process from the OS
+type procStat struct {
+	cpuSeconds float64 // user plus system time
+	rssBytes   uint64
+	startedAt  time.Time
+}
+
+// usageSampler keeps the latest resource sample of one process
+type usageSampler struct {
+	mu         sync.Mutex
+	latest     *models.ResourceUsage
+	lastCPU    float64
+	lastSample time.Time
+}
+
+// sample reads the process's counters. CPU use is averaged since the
+// previous sample, or since the process started for the first one.
+func (p *process) sample(now time.Time) *models.ResourceUsage {
+	p.usage.mu.Lock()
+	defer p.usage.mu.Unlock()
+
+	st, err := readProcStat(p.pid)
+	if err != nil {
+		return p.usage.latest
+	}
+	uptime := now.Sub(st.startedAt)
+
+	u := &models.ResourceUsage{
+		RSSBytes:      st.rssBytes,
+		UptimeSeconds: int64(uptime.Seconds()),
+		Limits:        p.limits,
+		SampledAt:     now,
+	}
+	switch {
+	case !p.usage.lastSample.IsZero() && now.After(p.usage.lastSample):
+		u.CPUPercent = (st.cpuSeconds - p.usage.lastCPU) / now.Sub(p.usage.lastSample).Seconds() * 100
+	case uptime > 0:
+		u.CPUPercent = st.cpuSeconds / uptime.Seconds() * 100
+	}
+	if u.CPUPercent < 0 {
+		u.CPUPercent = 0
+	}
+
+	p.usage.lastCPU = st.cpuSeconds
+	p.usage.lastSample = now
+	p.usage.latest = u
+	return u
+}
+
+// Usage returns the latest resource sample of a running instance, or nil
+func (r *Runner) Usage(instanceID string) *models.ResourceUsage {
+	proc, ok := r.processes.Load(instanceID)
+	if !ok {
+		return nil
+	}
+	p := proc.(*process)
+
+	p.usage.mu.Lock()
+	u := p.usage.latest
+	p.usage.mu.Unlock()
+	if u == nil {
+		// Not sampled yet
+		u = p.sample(time.Now())
+	}
+	return u
+}
+
+// TotalUsage sums the latest samples of every running instance
+func (r *Runner) TotalUsage() (cpuPercent float64, rssBytes uint64) {
+	r.processes.Range(func(key, _ interface{}) bool {
+		if u := r.Usage(key.(string)); u != nil {
+			cpuPercent += u.CPUPercent
+			rssBytes += u.RSSBytes
+		}
+		return true
+	})
+	return cpuPercent, rssBytes
+}
+
+// MemoryPercent is rss as a percentage of the host's memory, or 0 when that
+// is unknown
+func MemoryPercent(rssBytes uint64) float64 {
+	total := systemMemory()
+	if total == 0 {
+		return 0
+	}
+	return float64(rssBytes) / float64(total) * 100
+}
+
+// sampleUsage refreshes every running instance's sample; it never returns
+func (r *Runner) sampleUsage() {
+	ticker := time.NewTicker(usageSampleInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		r.processes.Range(func(_, proc interface{}) bool {
+			proc.(*process).sample(now)
+			return true
+		})
+	}
+}
+
+// SetCgroupRoot sets the cgroup v2 directory under which instances with CPU
+// or memory limits get their own group. Empty disables cgroups.
+func (r *Runner) SetCgroupRoot(dir string) {
+	r.cgroupRoot = dir
+}
+
+// hasLimits reports whether any resource limit is configured
+func hasLimits(instance models.Instance) bool {
+	return instance.CPULimit > 0 || instance.MemoryLimitMB > 0 || instance.MaxOpenFiles > 0
+}
+
+// limitPlan carries what prepareLimits set up before a process starts to
+// applyLimits, which finishes after it started
+type limitPlan struct {
+	cgroup   *os.File // the instance's cgroup directory the process starts in
+	limits   string   // the limits the cgroup carries, for messages
+	problems []string // limits that are not enforced, and why
+}
+
+// dropCgroup gives up starting inside the cgroup after a start failed, so
+// the process can be started again without it; its limits are then
+// reported as not enforced. It returns false if no cgroup was used.
+func (p *limitPlan) dropCgroup(err error) bool {
+	if p.cgroup == nil {
+		return false
+	}
+	p.close()
+	p.problems = append(p.problems, fmt.Sprintf("%s not enforced: cannot start inside cgroup: %v", p.limits, err))
+	return true
+}
+
+func (p *limitPlan) close() {
+	if p.cgroup != nil {
+		p.cgroup.Close()
+		p.cgroup = nil
+	}
+}
+
+// cgroupLimits names the configured limits that need a cgroup
+func cgroupLimits(instance models.Instance) string {
+	switch {
+	case instance.CPULimit > 0 && instance.MemoryLimitMB > 0:
+		return "cpu and memory limits"
+	case instance.CPULimit > 0:
+		return "cpu limit"
+	case instance.MemoryLimitMB > 0:
+		return "memory limit"
+	}
+	return ""
+}
@@ -0,0 +1,201 @@
+package passivbot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"pbgui-backend/internal/models"
+)
+
+const (
+	// USER_HZ, the unit of CPU times in /proc; 100 on every Linux platform Go supports
+	clockTicks = 100
+
+	cpuPeriod = 100000 // microseconds, the cgroup default
+)
+
+// prepareLimits has cmd start inside a cgroup v2 group carrying the
+// instance's CPU and memory limits, so the process never runs unconfined.
+// Without cgroups these limits are reported as not enforced.
+func (r *Runner) prepareLimits(instance models.Instance, cmd *exec.Cmd) *limitPlan {
+	plan := &limitPlan{limits: cgroupLimits(instance)}
+	if plan.limits == "" {
+		return plan
+	}
+
+	dir, err := r.createCgroup(instance)
+	if err == nil {
+		plan.cgroup, err = os.Open(dir)
+	}
+	if err != nil {
+		plan.problems = append(plan.problems, fmt.Sprintf("%s not enforced: %v", plan.limits, err))
+		return plan
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(plan.cgroup.Fd())
+	return plan
+}
+
+// applyLimits finishes restricting a freshly started process: open files
+// are capped with an rlimit. The returned error describes limits that are
+// not enforced; the process keeps running.
+func (r *Runner) applyLimits(instance models.Instance, pid int, plan *limitPlan) (string, error) {
+	defer plan.close()
+	if !hasLimits(instance) {
+		return "", nil
+	}
+
+	var methods []string
+	problems := plan.problems
+	if plan.cgroup != nil {
+		methods = append(methods, "cgroup")
+	}
+	if instance.MaxOpenFiles > 0 {
+		n := uint64(instance.MaxOpenFiles)
+		if err := unix.Prlimit(pid, unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: n, Max: n}, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("open files: %v", err))
+		} else {
+			methods = append(methods, "rlimit")
+		}
+	}
+
+	if len(problems) > 0 {
+		return strings.Join(methods, ","), errors.New(strings.Join(problems, "; "))
+	}
+	return strings.Join(methods, ","), nil
+}
+
+// systemMemory is the host's total RAM in bytes
+func systemMemory() uint64 {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
+
+// createCgroup creates and configures the instance's own group below the
+// cgroup root, returning its directory
+func (r *Runner) createCgroup(instance models.Instance) (string, error) {
+	if r.cgroupRoot == "" {
+		return "", errors.New("cgroups disabled")
+	}
+	parent := filepath.Dir(r.cgroupRoot)
+	controllers, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("no cgroup v2 hierarchy at %s", parent)
+	}
+	for _, c := range []string{"cpu", "memory"} {
+		if !containsFold(strings.Fields(string(controllers)), c) {
+			return "", fmt.Errorf("cgroup controller %s not available at %s", c, parent)
+		}
+	}
+
+	// Controllers must be enabled on every level above the instance group
+	if err := os.MkdirAll(r.cgroupRoot, 0755); err != nil {
+		return "", err
+	}
+	writeCgroup(parent, "cgroup.subtree_control", "+cpu +memory")
+	if err := writeCgroup(r.cgroupRoot, "cgroup.subtree_control", "+cpu +memory"); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(r.cgroupRoot, instance.ID)
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	cpuMax, memMax := "max", "max"
+	if instance.CPULimit > 0 {
+		cpuMax = strconv.Itoa(int(instance.CPULimit * cpuPeriod))
+	}
+	if instance.MemoryLimitMB > 0 {
+		memMax = strconv.FormatInt(int64(instance.MemoryLimitMB)<<20, 10)
+	}
+	if err := writeCgroup(dir, "cpu.max", fmt.Sprintf("%s %d", cpuMax, cpuPeriod)); err != nil {
+		return "", err
+	}
+	if err := writeCgroup(dir, "memory.max", memMax); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// releaseLimits removes the instance's cgroup once its process has exited
+func (r *Runner) releaseLimits(instanceID string) {
+	if r.cgroupRoot != "" {
+		os.Remove(filepath.Join(r.cgroupRoot, instanceID))
+	}
+}
+
+func writeCgroup(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("cgroup %s: %w", file, err)
+	}
+	return nil
+}
+
+// readProcStat reads CPU time, resident memory and start time from /proc
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	// Fields after the parenthesized command name, starting with the state (field 3)
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return procStat{}, errors.New("malformed /proc stat")
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return procStat{}, errors.New("malformed /proc stat")
+	}
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{
+		cpuSeconds: float64(field(14)+field(15)) / clockTicks,
+		rssBytes:   field(24) * uint64(os.Getpagesize()),
+		startedAt:  boot.Add(time.Duration(field(22)) * time.Second / clockTicks),
+	}, nil
+}
+
+// bootTime reads the system boot time from /proc/stat
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("btime not found in /proc/stat")
+}
@@ -0,0 +1,147 @@
+package passivbot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// limitsBot records its open files limit, as its children would see it,
+// once a file named ready appears next to its config
+const limitsBot = `d=$(dirname "$2")
+trap 'exit 0' INT TERM
+until [ -e "$d/ready" ]; do sleep 0.05; done
+sh -c 'ulimit -n' > "$d/nofile.tmp" && mv "$d/nofile.tmp" "$d/nofile"
+while :; do sleep 0.05; done
+`
+
+// readNofile waits for the open files limit limitsBot recorded
+func (r *testRunner) readNofile(t *testing.T, instanceID string) string {
+	t.Helper()
+	r.touch(t, instanceID, "ready")
+	path := r.workspace.instancePath(instanceID, "nofile")
+	for i := 0; i < 500; i++ {
+		if data, err := os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("open files limit not recorded")
+	return ""
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	r := newTestRunner(t, limitsBot)
+	if err := r.Start(models.Instance{ID: "bot", MaxOpenFiles: 64}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.readNofile(t, "bot"); got != "64" {
+		t.Errorf("open files limit %s, want 64", got)
+	}
+	if u := r.Usage("bot"); u == nil || u.Limits != "rlimit" {
+		t.Errorf("usage %+v, want limits enforced by rlimit", u)
+	}
+	for _, text := range logTexts(t, r.Runner, "bot") {
+		if strings.Contains(text, "not enforced") {
+			t.Errorf("logged %q", text)
+		}
+	}
+
+	// Without limits the process keeps the backend's
+	if err := r.Start(models.Instance{ID: "free"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.readNofile(t, "free"); got == "64" {
+		t.Error("limit applied to an instance without one")
+	}
+	if u := r.Usage("free"); u == nil || u.Limits != "" {
+		t.Errorf("usage %+v, want no limits", u)
+	}
+}
+
+// CPU and memory limits need a cgroup. Where none can be set up the
+// instance still starts, with the limits reported as not enforced; the
+// open files limit does not depend on it.
+func TestCgroupLimitsUnavailable(t *testing.T) {
+	instance := models.Instance{ID: "bot", CPULimit: 0.5, MemoryLimitMB: 100, MaxOpenFiles: 64}
+	tests := []struct {
+		name    string
+		root    func(dir string) string
+		problem string
+	}{
+		{"disabled", func(string) string { return "" }, "cgroups disabled"},
+		{"no hierarchy", func(dir string) string { return filepath.Join(dir, "pbgui") }, "no cgroup v2 hierarchy"},
+		{"no memory controller", func(dir string) string {
+			os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu io\n"), 0644)
+			return filepath.Join(dir, "pbgui")
+		}, "cgroup controller memory not available"},
+		{"not a cgroup filesystem", func(dir string) string {
+			os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu memory\n"), 0644)
+			return filepath.Join(dir, "pbgui")
+		}, "cannot start inside cgroup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRunner(t, limitsBot)
+			r.SetCgroupRoot(tt.root(t.TempDir()))
+			if err := r.Start(instance); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.readNofile(t, "bot"); got != "64" {
+				t.Errorf("open files limit %s, want 64", got)
+			}
+			if u := r.Usage("bot"); u == nil || u.Limits != "rlimit" {
+				t.Errorf("usage %+v, want only the rlimit enforced", u)
+			}
+			want := "cpu and memory limits not enforced: " + tt.problem
+			found := false
+			for _, text := range logTexts(t, r.Runner, "bot") {
+				found = found || strings.Contains(text, want)
+			}
+			if !found {
+				t.Errorf("log does not report %q", want)
+			}
+		})
+	}
+}
+
+func TestCreateCgroup(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	r := &Runner{cgroupRoot: filepath.Join(dir, "pbgui")}
+
+	group, err := r.createCgroup(models.Instance{ID: "bot", CPULimit: 0.5, MemoryLimitMB: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != filepath.Join(dir, "pbgui", "bot") {
+		t.Errorf("group %s", group)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "cgroup.subtree_control"):          "+cpu +memory",
+		filepath.Join(dir, "pbgui", "cgroup.subtree_control"): "+cpu +memory",
+		filepath.Join(group, "cpu.max"):                       "50000 100000",
+		filepath.Join(group, "memory.max"):                    "104857600",
+	}
+	for path, want := range files {
+		if data, _ := os.ReadFile(path); string(data) != want {
+			t.Errorf("%s = %q, want %q", path, data, want)
+		}
+	}
+
+	// An unset limit is unlimited
+	group, err = r.createCgroup(models.Instance{ID: "cpu", CPULimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(group, "memory.max")); string(data) != "max" {
+		t.Errorf("memory.max = %q, want max", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(group, "cpu.max")); string(data) != "200000 100000" {
+		t.Errorf("cpu.max = %q", data)
+	}
+}
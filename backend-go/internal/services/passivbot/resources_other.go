@@ -0,0 +1,34 @@
+//go:build !linux
+
+package passivbot
+
+import (
+	"errors"
+	"os/exec"
+
+	"pbgui-backend/internal/models"
+)
+
+var errNoProc = errors.New("resource limits and usage need Linux")
+
+func (r *Runner) prepareLimits(instance models.Instance, cmd *exec.Cmd) *limitPlan {
+	return &limitPlan{}
+}
+
+// applyLimits cannot enforce anything without Linux rlimits and cgroups
+func (r *Runner) applyLimits(instance models.Instance, pid int, plan *limitPlan) (string, error) {
+	if !hasLimits(instance) {
+		return "", nil
+	}
+	return "", errNoProc
+}
+
+func (r *Runner) releaseLimits(instanceID string) {}
+
+func systemMemory() uint64 {
+	return 0
+}
+
+func readProcStat(pid int) (procStat, error) {
+	return procStat{}, errNoProc
+}
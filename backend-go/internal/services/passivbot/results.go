@@ -0,0 +1,369 @@
+package passivbot
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// The equity curve is written once per minute of the backtest; stored
+// results keep at most this many points of it
+const maxEquityPoints = 2000
+
+// BacktestResult is what a backtest produced, read from the files passivbot
+// writes to its output directory
+type BacktestResult struct {
+	StartBalance float64               `json:"start_balance"`
+	FinalBalance float64               `json:"final_balance"`
+	TotalReturn  float64               `json:"total_return"` // percent
+	MaxDrawdown  float64               `json:"max_drawdown"` // percent of peak equity, negative
+	SharpeRatio  float64               `json:"sharpe_ratio"`
+	TotalTrades  int                   `json:"total_trades"`
+	WinRate      float64               `json:"win_rate"` // share of fills with a realized pnl that were profitable
+	Metrics      map[string]float64    `json:"metrics"`  // analysis.json as written by passivbot
+	Equity       []EquityPoint         `json:"equity"`
+	Fills        []models.BacktestFill `json:"fills,omitempty"` // stored apart from the results
+	CompletedAt  time.Time             `json:"completed_at"`
+}
+
+// EquityPoint is the balance and equity at one minute of a backtest
+type EquityPoint struct {
+	Minute    int64      `json:"minute"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Balance   float64    `json:"balance"`
+	Equity    float64    `json:"equity"`
+}
+
+// Column names used by passivbot releases for the same value. The empty
+// name is the unnamed index pandas writes first.
+var (
+	minuteColumns    = []string{"minute", "index", ""}
+	timestampColumns = []string{"timestamp"}
+	balanceColumns   = []string{"balance", "usd_total_balance", "balance_usd"}
+	equityColumns    = []string{"equity", "usd_total_equity", "equity_usd"}
+)
+
+// findResultsDir returns the output directory of the backtest run under
+// base: the most recently written directory with an analysis.json
+func findResultsDir(base string) (string, error) {
+	var found string
+	var newest time.Time
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "analysis.json" {
+			return nil
+		}
+		if found == "" || info.ModTime().After(newest) {
+			found, newest = filepath.Dir(path), info.ModTime()
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("backtest wrote no analysis.json under %s", base)
+	}
+	return found, nil
+}
+
+// readBacktestResult reads a backtest's output directory. analysis.json is
+// required; fills.csv and balance_and_equity.csv are read when present.
+func readBacktestResult(dir string) (*BacktestResult, error) {
+	metrics, err := readAnalysis(filepath.Join(dir, "analysis.json"))
+	if err != nil {
+		return nil, err
+	}
+	equity, err := readEquity(filepath.Join(dir, "balance_and_equity.csv"))
+	if err != nil {
+		return nil, err
+	}
+	fills, err := readFills(filepath.Join(dir, "fills.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BacktestResult{
+		Metrics:     metrics,
+		Equity:      equity,
+		Fills:       fills,
+		TotalTrades: len(fills),
+		SharpeRatio: metricOf(metrics, "sharpe_ratio", "sharpe_ratio_usd"),
+		CompletedAt: time.Now(),
+	}
+
+	switch {
+	case len(equity) > 0:
+		result.StartBalance = equity[0].Balance
+		result.FinalBalance = equity[len(equity)-1].Balance
+	case len(fills) > 0:
+		result.StartBalance = metricOf(metrics, "starting_balance")
+		result.FinalBalance = fills[len(fills)-1].Balance
+	default:
+		result.StartBalance = metricOf(metrics, "starting_balance")
+		result.FinalBalance = metricOf(metrics, "final_balance")
+	}
+	if result.StartBalance > 0 {
+		result.TotalReturn = (result.FinalBalance/result.StartBalance - 1) * 100
+	}
+
+	if len(equity) > 0 {
+		result.MaxDrawdown = maxDrawdown(equity)
+	} else {
+		result.MaxDrawdown = -math.Abs(metricOf(metrics, "drawdown_worst", "drawdown_worst_usd")) * 100
+	}
+
+	var realized, won int
+	for _, f := range fills {
+		if f.PNL != 0 {
+			realized++
+			if f.PNL > 0 {
+				won++
+			}
+		}
+	}
+	if realized > 0 {
+		result.WinRate = float64(won) / float64(realized)
+	}
+
+	result.Equity = downsample(equity, maxEquityPoints)
+	return result, nil
+}
+
+// readAnalysis reads the numeric values of analysis.json. Nested objects,
+// which some releases write per side or per exchange, are flattened to
+// "outer.inner" keys.
+func readAnalysis(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid analysis.json: %w", err)
+	}
+	metrics := make(map[string]float64)
+	flattenMetrics(metrics, "", raw)
+	return metrics, nil
+}
+
+func flattenMetrics(out map[string]float64, prefix string, values map[string]interface{}) {
+	for key, v := range values {
+		switch v := v.(type) {
+		case float64:
+			out[prefix+key] = v
+		case map[string]interface{}:
+			flattenMetrics(out, prefix+key+".", v)
+		}
+	}
+}
+
+// metricOf returns the first of the named metrics passivbot wrote
+func metricOf(metrics map[string]float64, names ...string) float64 {
+	for _, name := range names {
+		if v, ok := metrics[name]; ok {
+			return v
+		}
+	}
+	return 0
+}
+
+func readEquity(path string) ([]EquityPoint, error) {
+	var points []EquityPoint
+	err := readCSV(path, func(row csvRow) error {
+		p := EquityPoint{
+			Minute:    row.integer(minuteColumns...),
+			Timestamp: row.timestamp(timestampColumns...),
+			Balance:   row.number(balanceColumns...),
+			Equity:    row.number(equityColumns...),
+		}
+		points = append(points, p)
+		return row.err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid balance_and_equity.csv: %w", err)
+	}
+	return points, nil
+}
+
+func readFills(path string) ([]models.BacktestFill, error) {
+	var fills []models.BacktestFill
+	err := readCSV(path, func(row csvRow) error {
+		f := models.BacktestFill{
+			Minute:        row.integer(minuteColumns...),
+			Timestamp:     row.timestamp(timestampColumns...),
+			Coin:          row.text("coin", "symbol"),
+			Type:          row.text("type"),
+			Qty:           row.number("qty"),
+			Price:         row.number("price"),
+			PNL:           row.number("pnl"),
+			Fee:           row.number("fee_paid", "fee"),
+			Balance:       row.number(balanceColumns...),
+			PositionSize:  row.number("psize", "position_size"),
+			PositionPrice: row.number("pprice", "position_price"),
+		}
+		fills = append(fills, f)
+		return row.err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid fills.csv: %w", err)
+	}
+	return fills, nil
+}
+
+// maxDrawdown is the largest fall of equity from its running peak, in
+// percent of the peak
+func maxDrawdown(points []EquityPoint) float64 {
+	var peak, worst float64
+	for _, p := range points {
+		if p.Equity > peak {
+			peak = p.Equity
+		}
+		if peak > 0 {
+			if dd := (p.Equity - peak) / peak * 100; dd < worst {
+				worst = dd
+			}
+		}
+	}
+	return worst
+}
+
+// downsample keeps evenly spaced points, always including the last one
+func downsample(points []EquityPoint, max int) []EquityPoint {
+	if len(points) <= max {
+		return points
+	}
+	step := float64(len(points)-1) / float64(max-1)
+	out := make([]EquityPoint, 0, max)
+	for i := 0; i < max-1; i++ {
+		out = append(out, points[int(float64(i)*step)])
+	}
+	return append(out, points[len(points)-1])
+}
+
+// csvRow is one record of a CSV file, read by column name. The first
+// value that fails to parse is kept in err.
+type csvRow struct {
+	columns map[string]int
+	record  []string
+	line    int
+	err     error
+}
+
+// readCSV calls fn for every record of a CSV file with a header. A file
+// that does not exist has no records.
+func readCSV(path string, fn func(row csvRow) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(csvRow{columns: columns, record: record, line: line}); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *csvRow) value(names []string) (string, string, bool) {
+	for _, name := range names {
+		if i, ok := r.columns[name]; ok && i < len(r.record) {
+			return name, strings.TrimSpace(r.record[i]), true
+		}
+	}
+	return "", "", false
+}
+
+func (r *csvRow) text(names ...string) string {
+	_, v, _ := r.value(names)
+	return v
+}
+
+func (r *csvRow) number(names ...string) float64 {
+	name, v, ok := r.value(names)
+	if !ok || v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.fail(name, v)
+		return 0
+	}
+	return f
+}
+
+func (r *csvRow) integer(names ...string) int64 {
+	name, v, ok := r.value(names)
+	if !ok || v == "" {
+		return 0
+	}
+	// pandas may write integer columns as floats
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.fail(name, v)
+		return 0
+	}
+	return int64(f)
+}
+
+// timestamp reads epoch milliseconds or a date and time as pandas writes them
+func (r *csvRow) timestamp(names ...string) *time.Time {
+	name, v, ok := r.value(names)
+	if !ok || v == "" {
+		return nil
+	}
+	if ms, err := strconv.ParseFloat(v, 64); err == nil {
+		t := time.UnixMilli(int64(ms)).UTC()
+		return &t
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	r.fail(name, v)
+	return nil
+}
+
+func (r *csvRow) fail(column, value string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("line %d: invalid %s %q", r.line, column, value)
+	}
+}
@@ -0,0 +1,268 @@
+package passivbot
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// The output of a backtest as passivbot writes it
+const fixtureDir = "testdata/backtest"
+
+// fixtureCopy copies the named files of the fixture into a new directory,
+// optionally replacing their contents
+func fixtureCopy(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if content == "" {
+			data, err := os.ReadFile(filepath.Join(fixtureDir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content = string(data)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestReadBacktestResult(t *testing.T) {
+	result, err := readBacktestResult(fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := []struct {
+		name      string
+		got, want float64
+	}{
+		{"start balance", result.StartBalance, 1000},
+		{"final balance", result.FinalBalance, 1050},
+		{"total return", result.TotalReturn, 5},
+		{"max drawdown", result.MaxDrawdown, (980.0 - 1010) / 1010 * 100},
+		{"sharpe ratio", result.SharpeRatio, 1.5},
+		{"win rate", result.WinRate, 2.0 / 3},
+	}
+	for _, n := range numbers {
+		if !near(n.got, n.want) {
+			t.Errorf("%s = %v, want %v", n.name, n.got, n.want)
+		}
+	}
+	if result.TotalTrades != 5 || len(result.Fills) != 5 {
+		t.Errorf("%d trades and %d fills, want 5", result.TotalTrades, len(result.Fills))
+	}
+
+	wantMetrics := map[string]float64{
+		"adg":                         0.001,
+		"sharpe_ratio":                1.5,
+		"drawdown_worst":              0.03,
+		"starting_balance":            1000,
+		"final_balance":               1050,
+		"long.adg":                    0.002,
+		"long.positions_held_per_day": 1.2,
+	}
+	if !reflect.DeepEqual(result.Metrics, wantMetrics) {
+		t.Errorf("metrics = %v, want %v", result.Metrics, wantMetrics)
+	}
+
+	if len(result.Equity) != 5 {
+		t.Fatalf("%d equity points, want 5", len(result.Equity))
+	}
+	p := result.Equity[2]
+	if p.Minute != 2 || p.Balance != 1005 || p.Equity != 980 || p.Timestamp == nil ||
+		!p.Timestamp.Equal(time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC)) {
+		t.Errorf("equity point = %+v (%v)", p, p.Timestamp)
+	}
+
+	at := time.Date(2024, 1, 1, 0, 3, 0, 0, time.UTC)
+	wantFill := models.BacktestFill{
+		Minute: 3, Timestamp: &at, Coin: "BTC/USDT:USDT", Type: "close_grid_long",
+		Qty: -0.01, Price: 43000, PNL: 10, Fee: -0.1, Balance: 1009.8,
+	}
+	if !reflect.DeepEqual(result.Fills[1], wantFill) {
+		t.Errorf("fill = %+v, want %+v", result.Fills[1], wantFill)
+	}
+}
+
+func TestReadBacktestResultPartial(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        map[string]string
+		wantStart    float64
+		wantFinal    float64
+		wantDrawdown float64
+		wantTrades   int
+	}{
+		{
+			name:         "analysis only",
+			files:        map[string]string{"analysis.json": ""},
+			wantStart:    1000,
+			wantFinal:    1050,
+			wantDrawdown: -3,
+			wantTrades:   0,
+		},
+		{
+			name:         "fills without equity",
+			files:        map[string]string{"analysis.json": "", "fills.csv": ""},
+			wantStart:    1000,
+			wantFinal:    1034.2,
+			wantDrawdown: -3,
+			wantTrades:   5,
+		},
+		{
+			name:         "header only",
+			files:        map[string]string{"analysis.json": "", "fills.csv": "minute,pnl\n", "balance_and_equity.csv": "minute,balance,equity\n"},
+			wantStart:    1000,
+			wantFinal:    1050,
+			wantDrawdown: -3,
+			wantTrades:   0,
+		},
+		{
+			name: "older column names",
+			files: map[string]string{
+				"analysis.json":          `{"drawdown_worst_usd":-0.5}`,
+				"balance_and_equity.csv": "index,usd_total_balance,usd_total_equity\n0,100,100\n1,100,50\n2,200,200\n",
+			},
+			wantStart:    100,
+			wantFinal:    200,
+			wantDrawdown: -50,
+			wantTrades:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := readBacktestResult(fixtureCopy(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(result.StartBalance, tt.wantStart) || !near(result.FinalBalance, tt.wantFinal) {
+				t.Errorf("balance %v to %v, want %v to %v", result.StartBalance, result.FinalBalance, tt.wantStart, tt.wantFinal)
+			}
+			if !near(result.MaxDrawdown, tt.wantDrawdown) {
+				t.Errorf("max drawdown = %v, want %v", result.MaxDrawdown, tt.wantDrawdown)
+			}
+			if result.TotalTrades != tt.wantTrades {
+				t.Errorf("%d trades, want %d", result.TotalTrades, tt.wantTrades)
+			}
+		})
+	}
+}
+
+func TestReadBacktestResultInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"no analysis", map[string]string{"fills.csv": ""}, "analysis.json"},
+		{"analysis not json", map[string]string{"analysis.json": "{"}, "invalid analysis.json"},
+		{"bad number", map[string]string{"analysis.json": "", "fills.csv": "minute,pnl\n1,0\n2,lots\n"}, `invalid fills.csv: line 3: invalid pnl "lots"`},
+		{"bad minute", map[string]string{"analysis.json": "", "balance_and_equity.csv": "minute,balance\nx,1\n"}, `invalid balance_and_equity.csv: line 2: invalid minute "x"`},
+		{"bad timestamp", map[string]string{"analysis.json": "", "fills.csv": "timestamp,pnl\nyesterday,1\n"}, `line 2: invalid timestamp "yesterday"`},
+		{"malformed csv", map[string]string{"analysis.json": "", "fills.csv": "minute,pnl\n\"1,2\n"}, "invalid fills.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readBacktestResult(fixtureCopy(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindResultsDir(t *testing.T) {
+	base := t.TempDir()
+	if _, err := findResultsDir(base); err == nil {
+		t.Error("found results in an empty directory")
+	}
+	if _, err := findResultsDir(filepath.Join(base, "missing")); err == nil {
+		t.Error("found results in a missing directory")
+	}
+
+	// Runs are written to backtests/<exchange>/<date>/; the newest wins
+	old := filepath.Join(base, "combined", "2024-01-01T00_00_00")
+	recent := filepath.Join(base, "combined", "2024-01-02T00_00_00")
+	for i, dir := range []string{recent, old} {
+		if err := os.MkdirAll(filepath.Join(dir, "plots"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "analysis.json")
+		os.WriteFile(path, []byte("{}"), 0644)
+		mtime := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+	got, err := findResultsDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != recent {
+		t.Errorf("findResultsDir = %s, want %s", got, recent)
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		equity []float64
+		want   float64
+	}{
+		{"none", nil, 0},
+		{"rising", []float64{1, 2, 3}, 0},
+		{"one fall", []float64{100, 80, 120}, -20},
+		{"worst fall from a later peak", []float64{100, 90, 200, 120, 210}, -40},
+		{"zero equity", []float64{0, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var points []EquityPoint
+			for _, e := range tt.equity {
+				points = append(points, EquityPoint{Equity: e})
+			}
+			if got := maxDrawdown(points); !near(got, tt.want) {
+				t.Errorf("maxDrawdown = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		max  int
+		want []int64 // minutes kept
+	}{
+		{"under the limit", 3, 5, []int64{0, 1, 2}},
+		{"at the limit", 5, 5, []int64{0, 1, 2, 3, 4}},
+		{"halved", 9, 5, []int64{0, 2, 4, 6, 8}},
+		{"last kept", 10, 4, []int64{0, 3, 6, 9}},
+		{"uneven", 7, 3, []int64{0, 3, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var points []EquityPoint
+			for i := 0; i < tt.n; i++ {
+				points = append(points, EquityPoint{Minute: int64(i)})
+			}
+			var got []int64
+			for _, p := range downsample(points, tt.max) {
+				got = append(got, p.Minute)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept minutes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
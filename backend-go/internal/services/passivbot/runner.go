@@ -1,9 +1,11 @@
 package passivbot
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -11,113 +13,397 @@ import (
 	"time"
 
 	"pbgui-backend/internal/models"
+	"pbgui-backend/internal/services/pbconfig"
+)
+
+// maxReplayLines caps how many persisted lines a resuming log stream replays
+const maxReplayLines = 5000
+
+const (
+	defaultStopGrace = 10 * time.Second
+
+	// How often Stop checks whether an exit being handled is done
+	exitPollInterval = 10 * time.Millisecond
+)
+
+// How long Stop waits after SIGTERM and after SIGKILL; tests shorten them
+var (
+	stopTermWait = 5 * time.Second
+	stopKillWait = 5 * time.Second
 )
 
 type Runner struct {
 	pythonPath string
 	pbPath     string
-	processes  sync.Map // instanceID -> *os.Process
-	configs    sync.Map // instanceID -> models.Instance
+	runDir     string   // PID files
+	workspace  *Workspace
+	processes  sync.Map // instanceID -> *process
+	logs       *LogStore
+	hub        *LogHub
+	onState    func(StateEvent)
+	stopGrace  time.Duration
+	cgroupRoot string
+
+	mu       sync.Mutex // serializes starts, stops and automatic restarts
+	restarts map[string]*restartState
+	exiting  map[string]int // instances whose exit is being handled
+
+	// Worker pool for bulk operations
+	bulkWorkers int
+	workersOnce sync.Once
+	tasks       chan func()
+	opsMu       sync.Mutex
+	operations  map[string]*Operation
 }
 
-func NewRunner(pbPath, pythonPath string) *Runner {
-	return &Runner{
+func NewRunner(pbPath, pythonPath, runDir string, logs *LogStore) *Runner {
+	r := &Runner{
 		pythonPath: pythonPath,
 		pbPath:     pbPath,
+		runDir:     runDir,
+		workspace:  NewWorkspace(filepath.Join(os.TempDir(), "pbgui-workspace")),
+		logs:       logs,
+		hub:        NewLogHub(defaultSubscriberBuffer, defaultMaxDropped, defaultBacklogSize),
+		stopGrace:  defaultStopGrace,
+		restarts:   make(map[string]*restartState),
+		exiting:    make(map[string]int),
+
+		bulkWorkers: defaultBulkWorkers,
+		tasks:       make(chan func()),
+		operations:  make(map[string]*Operation),
 	}
+	go r.sampleUsage()
+	return r
 }
 
+// Start launches an instance. A manual start cancels any pending automatic
+// restart and resets the instance's crash-loop history.
 func (r *Runner) Start(instance models.Instance) error {
-	// Create config file
-	configPath, err := r.createConfigFile(instance)
-	if err != nil {
-		return fmt.Errorf("failed to create config: %w", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.processes.Load(instance.ID); ok {
+		return fmt.Errorf("instance %s is already running", instance.ID)
 	}
+	r.cancelRestart(instance.ID, true)
 
-	// Build command
-	cmd := exec.Command(
-		r.pythonPath,
-		filepath.Join(r.pbPath, "main.py"),
-		"--config", configPath,
-	)
+	p, err := r.start(instance)
+	if err != nil {
+		return err
+	}
+	r.emit(StateEvent{InstanceID: instance.ID, Status: StatusRunning, Time: p.startedAt})
+	return nil
+}
 
-	// Set working directory
-	cmd.Dir = r.pbPath
+// start launches the passivbot process. r.mu must be held.
+func (r *Runner) start(instance models.Instance) (*process, error) {
+	// Write the account's API keys, if any, and the config pointing at them
+	if instance.AccountID != "" {
+		if err := r.writeAPIKeys(instance); err != nil {
+			return nil, fmt.Errorf("failed to write API keys: %w", err)
+		}
+	}
+	configPath, err := r.createConfigFile(instance)
+	if err != nil {
+		r.removeAPIKeys(instance.ID)
+		return nil, fmt.Errorf("failed to create config: %w", err)
+	}
 
-	// Create pipes for stdout/stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Run detached with output going to spool files, so the process
+	// survives backend restarts; the files are tailed into the log
+	stdout, stderr, err := r.openSpools(instance.ID)
+	if err != nil {
+		r.removeAPIKeys(instance.ID)
+		return nil, fmt.Errorf("failed to create output files: %w", err)
+	}
+	command := func() *exec.Cmd {
+		cmd := exec.Command(
+			r.pythonPath,
+			filepath.Join(r.pbPath, "main.py"),
+			"--config", configPath,
+		)
+		cmd.Dir = r.pbPath
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		detach(cmd)
+		return cmd
+	}
 
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start passivbot: %w", err)
+	// Start the process, inside its cgroup if it has one. Kernels before
+	// 5.7 cannot do that; it then starts without CPU and memory limits.
+	cmd := command()
+	limits := r.prepareLimits(instance, cmd)
+	err = cmd.Start()
+	if err != nil && limits.dropCgroup(err) {
+		cmd = command()
+		err = cmd.Start()
+	}
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		limits.close()
+		r.releaseLimits(instance.ID)
+		r.removeSpools(instance.ID)
+		r.removeAPIKeys(instance.ID)
+		r.logSystem(instance.ID, fmt.Sprintf("failed to start passivbot: %v", err))
+		r.logs.Close(instance.ID)
+		return nil, fmt.Errorf("failed to start passivbot: %w", err)
 	}
+	r.logSystem(instance.ID, fmt.Sprintf("started passivbot (pid %d) with config %s", cmd.Process.Pid, configPath))
 
-	// Store process and config
-	r.processes.Store(instance.ID, cmd.Process)
-	r.configs.Store(instance.ID, instance)
+	// Store process and record it for reconciliation after a backend restart
+	p := newProcess(instance, cmd.Process.Pid, time.Now())
+	p.limits, err = r.applyLimits(instance, p.pid, limits)
+	if err != nil {
+		r.logSystem(instance.ID, fmt.Sprintf("resource limits only partly applied: %v", err))
+	}
+	p.tails = r.tailSpools(instance.ID)
+	r.processes.Store(instance.ID, p)
+	err = r.writePIDFile(PIDInfo{
+		InstanceID: instance.ID,
+		PID:        p.pid,
+		StartedAt:  p.startedAt,
+		ConfigPath: configPath,
+		Command:    cmd.Args,
+	})
+	if err != nil {
+		r.logSystem(instance.ID, fmt.Sprintf("failed to write PID file: %v", err))
+	}
 
 	// Start goroutine to monitor process
-	go r.monitorProcess(instance.ID, cmd)
+	go r.monitorProcess(p, cmd)
 
-	return nil
+	return p, nil
+}
+
+// StopResult describes how a stopped process ended
+type StopResult struct {
+	Graceful bool          // exited on the first, polite signal
+	Signal   string        // last signal sent
+	ExitCode *int          // nil when unknown, as for adopted processes
+	Duration time.Duration // from the first signal to the exit
 }
 
-func (r *Runner) Stop(instanceID string) error {
-	if proc, ok := r.processes.Load(instanceID); ok {
-		if process, ok := proc.(*os.Process); ok {
-			// Graceful shutdown first
-			if err := process.Signal(os.Interrupt); err != nil {
-				// Force kill if graceful shutdown fails
-				return process.Kill()
+// SetStopGracePeriod sets how long Stop waits after SIGINT before escalating
+func (r *Runner) SetStopGracePeriod(d time.Duration) {
+	if d > 0 {
+		r.stopGrace = d
+	}
+}
+
+// Stop shuts an instance down and waits for it to exit. It sends SIGINT to
+// the process group, then SIGTERM after the grace period (the runner default
+// when grace is zero), then SIGKILL, each time waiting for the process to
+// actually exit before escalating.
+func (r *Runner) Stop(instanceID string, grace time.Duration) (*StopResult, error) {
+	r.mu.Lock()
+	// A process that just exited is forgotten before its restart policy is
+	// applied; wait for that so a restart it schedules is cancelled below
+	for r.exiting[instanceID] > 0 {
+		if _, ok := r.processes.Load(instanceID); ok {
+			break
+		}
+		r.mu.Unlock()
+		time.Sleep(exitPollInterval)
+		r.mu.Lock()
+	}
+	cancelled := r.cancelRestart(instanceID, false)
+	proc, ok := r.processes.Load(instanceID)
+	if !ok && cancelled {
+		// Waiting to be restarted; cancelling the restart stops it. The
+		// status is recorded before unlocking so a reconcile pass cannot
+		// mistake the instance for one that should be running.
+		r.logSystem(instanceID, "pending restart cancelled")
+		r.emit(StateEvent{InstanceID: instanceID, Status: StatusStopped})
+		r.mu.Unlock()
+		return &StopResult{Graceful: true}, nil
+	}
+	if !ok {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	// Marked before unlocking, so an exit from here on is not restarted
+	p := proc.(*process)
+	p.stopping.Store(true)
+	r.mu.Unlock()
+
+	if grace <= 0 {
+		grace = r.stopGrace
+	}
+	waits := []time.Duration{grace, stopTermWait, stopKillWait}
+
+	begin := time.Now()
+	for i, sig := range stopSignals {
+		result := &StopResult{Graceful: i == 0 && sig != os.Kill, Signal: sig.String()}
+		if err := signalGroup(p.pid, sig); err != nil && processAlive(p.pid) {
+			r.logSystem(instanceID, fmt.Sprintf("failed to send %s: %v", sig, err))
+		}
+
+		wait := waits[len(waits)-1]
+		if i < len(waits) {
+			wait = waits[i]
+		}
+		select {
+		case <-p.done:
+			result.Duration = time.Since(begin)
+			if !p.adopted {
+				code := p.exitCode
+				result.ExitCode = &code
 			}
-			
-			// Wait a bit for graceful shutdown
-			time.Sleep(2 * time.Second)
-			
-			// Check if still running, force kill if needed
-			if r.isProcessRunning(process) {
-				return process.Kill()
+			return result, nil
+		case <-time.After(wait):
+			if i < len(stopSignals)-1 {
+				r.logSystem(instanceID, fmt.Sprintf("still running %s after %s; escalating", wait, sig))
 			}
-			
-			// Clean up
-			r.processes.Delete(instanceID)
-			r.configs.Delete(instanceID)
-			
-			return nil
 		}
 	}
-	return fmt.Errorf("instance %s not found", instanceID)
+	return nil, fmt.Errorf("instance %s did not exit after %s", instanceID, stopSignals[len(stopSignals)-1])
 }
 
-func (r *Runner) GetStatus(instanceID string) string {
-	if proc, ok := r.processes.Load(instanceID); ok {
-		if process, ok := proc.(*os.Process); ok {
-			if r.isProcessRunning(process) {
-				return "running"
-			}
+// Restart stops a running instance, gracefully within grace, and starts it
+// again with the given settings, rewriting its config file. A stopped
+// instance is just started, and the returned StopResult is nil.
+func (r *Runner) Restart(instance models.Instance, grace time.Duration) (*StopResult, error) {
+	var result *StopResult
+	if _, ok := r.Supervised(instance.ID); ok {
+		res, err := r.Stop(instance.ID, grace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stop for restart: %w", err)
 		}
+		result = res
+	}
+
+	r.logSystem(instance.ID, "restarting with current settings")
+	return result, r.Start(instance)
+}
+
+func (r *Runner) GetStatus(instanceID string) string {
+	if proc, ok := r.processes.Load(instanceID); ok && processAlive(proc.(*process).pid) {
+		return StatusRunning
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st := r.restarts[instanceID]; st != nil && st.timer != nil {
+		return StatusRestarting
+	}
+	return StatusStopped
+}
+
+// GetLogs reads an instance's captured output from its persisted log files
+func (r *Runner) GetLogs(instanceID string, q LogQuery) ([]LogLine, error) {
+	return r.logs.Read(instanceID, q)
+}
+
+// HasLogs reports whether any output has been persisted for an instance
+func (r *Runner) HasLogs(instanceID string) bool {
+	return r.logs.Has(instanceID)
+}
+
+// ExportLogs streams an instance's persisted logs matching q to w
+func (r *Runner) ExportLogs(instanceID string, q LogQuery, w io.Writer) error {
+	return r.logs.Export(instanceID, q, w)
+}
+
+// RenderedConfig returns the config file last written for an instance, or
+// nil data if it has never been started
+func (r *Runner) RenderedConfig(instanceID string) (path string, data []byte, err error) {
+	if !validInstanceID(instanceID) {
+		return "", nil, ErrInvalidInstanceID
+	}
+	path = r.configPath(instanceID)
+	data, err = os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return path, nil, nil
+	}
+	return path, data, err
+}
+
+// SearchLogs searches the persisted logs of one or many instances
+func (r *Runner) SearchLogs(q SearchQuery) (*SearchResult, error) {
+	return r.logs.Search(q)
+}
+
+// SubscribeLogs streams an instance's output lines live as they are captured.
+// Callers must Close the subscription when done.
+func (r *Runner) SubscribeLogs(instanceID string) *Subscription {
+	return r.hub.Subscribe(instanceID)
+}
+
+// ResumeLogs subscribes to an instance's live output and returns every line
+// after the given sequence number that was emitted before the subscription,
+// from the in-memory backlog and, if that does not reach back far enough,
+// the persisted log (capped at maxReplayLines). Lines in the backlog may
+// also arrive on the subscription; callers should skip already-sent Seqs.
+func (r *Runner) ResumeLogs(instanceID string, after uint64) (*Subscription, []LogLine, error) {
+	sub, backlog, covered := r.hub.SubscribeAfter(instanceID, after)
+	if covered {
+		return sub, backlog, nil
+	}
+
+	q := LogQuery{AfterSeq: after, Tail: maxReplayLines}
+	if len(backlog) > 0 {
+		q.BeforeSeq = backlog[0].Seq
+	}
+	persisted, err := r.logs.Read(instanceID, q)
+	if err != nil {
+		sub.Close()
+		return nil, nil, err
 	}
-	return "stopped"
+	return sub, append(persisted, backlog...), nil
 }
 
-func (r *Runner) GetLogs(instanceID string) ([]string, error) {
-	// In a real implementation, you'd read from log files
-	// For now, return mock logs
-	return []string{
-		fmt.Sprintf("[%s] Instance %s initialized", time.Now().Format("15:04:05"), instanceID),
-		fmt.Sprintf("[%s] Connected to exchange", time.Now().Format("15:04:05")),
-		fmt.Sprintf("[%s] Starting trading loop", time.Now().Format("15:04:05")),
-	}, nil
+// outputWriter returns a writer that records each line of a process stream
+func (r *Runner) outputWriter(instanceID, stream string) *lineWriter {
+	return &lineWriter{emit: func(text string) {
+		r.appendLog(instanceID, LogLine{Time: time.Now(), Stream: stream, Text: text})
+	}}
+}
+
+// logSystem records a runner-generated message alongside the process output
+func (r *Runner) logSystem(instanceID, text string) {
+	r.appendLog(instanceID, LogLine{Time: time.Now(), Stream: "system", Text: text})
+}
+
+func (r *Runner) appendLog(instanceID string, line LogLine) {
+	line = ParseLogLine(line)
+	publish := func(l LogLine) { r.hub.Publish(instanceID, l) }
+	if err := r.logs.Append(instanceID, line, publish); err != nil {
+		log.Printf("Failed to write log for instance %s: %v", instanceID, err)
+	}
 }
 
 func (r *Runner) createConfigFile(instance models.Instance) (string, error) {
+	configBytes, err := r.ConfigFile(instance)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := r.workspace.InstanceDir(instance.ID); err != nil {
+		return "", err
+	}
+	configPath := r.configPath(instance.ID)
+	if err := writeFileAtomic(configPath, configBytes); err != nil {
+		return "", err
+	}
+
+	return configPath, nil
+}
+
+// ConfigFile renders the config file an instance is started with. A
+// template, if the instance uses one, must be attached. An instance with
+// an account is pointed at its api-keys.json; the keys are not included.
+func (r *Runner) ConfigFile(instance models.Instance) ([]byte, error) {
+	rendered, err := pbconfig.Render(instance)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse config JSON or create default
 	var config map[string]interface{}
-	if instance.Config != "" {
-		if err := json.Unmarshal([]byte(instance.Config), &config); err != nil {
-			return "", err
+	if rendered != "" {
+		if err := json.Unmarshal([]byte(rendered), &config); err != nil {
+			return nil, err
 		}
 	} else {
 		// Default config
@@ -129,50 +415,72 @@ func (r *Runner) createConfigFile(instance models.Instance) (string, error) {
 			"wallet_exposure_limit": 0.1,
 		}
 	}
-
-	// Create temp config file
-	configDir := filepath.Join(os.TempDir(), "pbgui-configs")
-	os.MkdirAll(configDir, 0755)
-	
-	configPath := filepath.Join(configDir, fmt.Sprintf("%s.json", instance.ID))
-	
-	configBytes, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return "", err
+	if instance.AccountID != "" {
+		r.injectAccount(config, instance)
 	}
 
-	if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
-		return "", err
-	}
+	return json.MarshalIndent(config, "", "  ")
+}
 
-	return configPath, nil
+// configPath is where createConfigFile writes an instance's config
+func (r *Runner) configPath(instanceID string) string {
+	return r.workspace.instancePath(instanceID, "config.json")
 }
 
-func (r *Runner) monitorProcess(instanceID string, cmd *exec.Cmd) {
-	// Wait for process to finish
+// SetWorkspaceDir sets where instance and job files are written
+func (r *Runner) SetWorkspaceDir(dir string) {
+	r.workspace = NewWorkspace(dir)
+}
+
+// RemoveInstanceFiles deletes a deleted instance's workspace and the
+// output lines kept in memory for it
+func (r *Runner) RemoveInstanceFiles(instanceID string) error {
+	if _, ok := r.processes.Load(instanceID); ok {
+		return fmt.Errorf("instance %s is still running", instanceID)
+	}
+	r.hub.Forget(instanceID)
+	return r.workspace.RemoveInstance(instanceID)
+}
+
+func (r *Runner) monitorProcess(p *process, cmd *exec.Cmd) {
+	instanceID := p.instance.ID
+
+	// Wait for process to finish, then collect the rest of its output
 	err := cmd.Wait()
-	
-	// Clean up when process exits
-	r.processes.Delete(instanceID)
-	r.configs.Delete(instanceID)
-	
+	r.beginExit(instanceID)
+	defer r.endExit(instanceID)
+	r.release(p)
+
 	// Log the exit
+	code := cmd.ProcessState.ExitCode()
+	defer p.exited(code)
 	if err != nil {
-		fmt.Printf("Instance %s exited with error: %v\n", instanceID, err)
+		r.logSystem(instanceID, fmt.Sprintf("process exited with error: %v", err))
+		log.Printf("Instance %s exited with error: %v", instanceID, err)
 	} else {
-		fmt.Printf("Instance %s exited normally\n", instanceID)
+		r.logSystem(instanceID, "process exited normally")
+		log.Printf("Instance %s exited normally", instanceID)
 	}
-}
+	r.logs.Close(instanceID)
 
-func (r *Runner) isProcessRunning(process *os.Process) bool {
-	// Send signal 0 to check if process is running
-	err := process.Signal(os.Signal(nil))
-	return err == nil
+	r.handleExit(p, code, err)
 }
 
-// RunBacktest executes a backtest job
-func (r *Runner) RunBacktest(params models.BacktestParams) (map[string]interface{}, error) {
-	// Create temporary config for backtest
+// RunBacktest executes a backtest job. Its files live in the job's
+// workspace, which is removed once the backtest finishes. Cancelling ctx
+// kills the backtest and every process it started. onProgress, if not nil,
+// receives progress read from the backtest's output as it runs. The
+// results are read from the output directory passivbot writes.
+func (r *Runner) RunBacktest(ctx context.Context, jobID string, params models.BacktestParams, onProgress func(BacktestProgress)) (*BacktestResult, error) {
+	dir, err := r.workspace.JobDir(jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.workspace.RemoveJob(jobID)
+	resultsBase := filepath.Join(dir, "backtests")
+
+	// Create temporary config for backtest, writing its output into the
+	// job's workspace
 	config := map[string]interface{}{
 		"exchange":   params.Exchange,
 		"symbol":     params.Symbol,
@@ -180,6 +488,7 @@ func (r *Runner) RunBacktest(params models.BacktestParams) (map[string]interface
 		"end_date":   params.EndDate,
 		"strategy":   params.Strategy,
 		"parameters": params.Parameters,
+		"backtest":   map[string]interface{}{"base_dir": resultsBase},
 	}
 
 	configBytes, err := json.Marshal(config)
@@ -187,36 +496,42 @@ func (r *Runner) RunBacktest(params models.BacktestParams) (map[string]interface
 		return nil, err
 	}
 
-	// Create temp config file
-	configPath := filepath.Join(os.TempDir(), fmt.Sprintf("backtest-%d.json", time.Now().Unix()))
-	if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
+	configPath := filepath.Join(dir, "config.json")
+	if err := writeFileAtomic(configPath, configBytes); err != nil {
 		return nil, err
 	}
-	defer os.Remove(configPath)
 
-	// Run backtest command
-	cmd := exec.Command(
+	// Run backtest command as a process group leader, so cancelling also
+	// kills the workers it forks
+	cmd := exec.CommandContext(ctx,
 		r.pythonPath,
 		filepath.Join(r.pbPath, "backtest.py"),
 		"--config", configPath,
 	)
+	detach(cmd)
+	cmd.Cancel = func() error {
+		return signalGroup(cmd.Process.Pid, stopSignals[len(stopSignals)-1])
+	}
+
+	output := newProgressWriter(onProgress)
+	cmd.Stdout = output
+	cmd.Stderr = output
 
-	output, err := cmd.CombinedOutput()
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("backtest failed: %w, output: %s", err, string(output))
+		return nil, fmt.Errorf("backtest failed: %w, output: %s", err, output.Output())
 	}
 
-	// Parse results (this would be JSON from actual passivbot)
-	results := map[string]interface{}{
-		"total_return":    15.5,
-		"sharpe_ratio":    1.8,
-		"max_drawdown":    -12.3,
-		"total_trades":    156,
-		"win_rate":        0.65,
-		"final_balance":   1155.50,
-		"start_balance":   1000.00,
-		"completed_at":    time.Now(),
+	resultsDir, err := findResultsDir(resultsBase)
+	if err != nil {
+		return nil, fmt.Errorf("%w, output: %s", err, output.Output())
 	}
-
-	return results, nil
+	result, err := readBacktestResult(resultsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backtest results: %w", err)
+	}
+	return result, nil
 }
@@ -0,0 +1,360 @@
+package passivbot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// testRunner runs script with sh in place of passivbot's main.py and
+// records the state changes it reports
+type testRunner struct {
+	*Runner
+	dir    string // holds passivbot, the run directory, logs and workspace
+	mu     sync.Mutex
+	events []StateEvent
+}
+
+func newTestRunner(t *testing.T, script string) *testRunner {
+	t.Helper()
+	dir := t.TempDir()
+	pbPath := filepath.Join(dir, "passivbot")
+	if err := os.MkdirAll(pbPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pbPath, "main.py"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return runnerIn(t, dir)
+}
+
+// runnerIn returns a runner using the directories in dir, as the backend
+// does when restarted on the same data
+func runnerIn(t *testing.T, dir string) *testRunner {
+	t.Helper()
+	pbPath := filepath.Join(dir, "passivbot")
+	r := &testRunner{Runner: NewRunner(pbPath, "/bin/sh", filepath.Join(dir, "run"), NewLogStore(filepath.Join(dir, "logs"), RotationPolicy{})), dir: dir}
+	r.SetWorkspaceDir(filepath.Join(dir, "workspace"))
+	r.OnStateChange(func(ev StateEvent) {
+		r.mu.Lock()
+		r.events = append(r.events, ev)
+		r.mu.Unlock()
+	})
+	t.Cleanup(func() {
+		r.processes.Range(func(id, _ interface{}) bool {
+			r.Stop(id.(string), 100*time.Millisecond)
+			return true
+		})
+	})
+	return r
+}
+
+// lastEvent returns the latest state change of an instance
+func (r *testRunner) lastEvent(instanceID string) (StateEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].InstanceID == instanceID {
+			return r.events[i], true
+		}
+	}
+	return StateEvent{}, false
+}
+
+// An instance that exits on its own just as it is stopped must stay
+// stopped, whichever of the two the runner sees first
+func TestStopRacingExit(t *testing.T) {
+	r := newTestRunner(t, "trap 'exit 0' INT TERM\nsleep 0.05\nexit 1\n")
+	instance := models.Instance{ID: "race", RestartPolicy: RestartAlways}
+
+	for i := 0; i < 20; i++ {
+		if err := r.Start(instance); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Duration(40+i) * time.Millisecond)
+		if _, err := r.Stop(instance.ID, time.Second); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if status, ok := r.Supervised(instance.ID); ok {
+			t.Fatalf("attempt %d: %s after Stop", i, status)
+		}
+		if ev, _ := r.lastEvent(instance.ID); ev.Status != StatusStopped {
+			t.Fatalf("attempt %d: last status %s, want stopped", i, ev.Status)
+		}
+	}
+}
+
+// Stop called after a process was forgotten but before its restart policy
+// was applied waits for the restart to be scheduled, then cancels it
+func TestStopDuringExitHandling(t *testing.T) {
+	r := newTestRunner(t, "")
+	p := newProcess(models.Instance{ID: "exiting", RestartPolicy: RestartAlways}, 0, time.Now())
+	r.beginExit(p.instance.ID)
+
+	stopped := make(chan error, 1)
+	go func() {
+		_, err := r.Stop(p.instance.ID, time.Second)
+		stopped <- err
+	}()
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned %v before the exit was handled", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.handleExit(p, 1, nil)
+	r.endExit(p.instance.ID)
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after the exit was handled")
+	}
+	if status, ok := r.Supervised(p.instance.ID); ok {
+		t.Errorf("%s after Stop", status)
+	}
+	if ev, _ := r.lastEvent(p.instance.ID); ev.Status != StatusStopped {
+		t.Errorf("last status %s, want stopped", ev.Status)
+	}
+}
+
+// shortenStopWaits has Stop escalate quickly for the rest of the test
+func shortenStopWaits(t *testing.T) {
+	term, kill := stopTermWait, stopKillWait
+	stopTermWait, stopKillWait = 200*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { stopTermWait, stopKillWait = term, kill })
+}
+
+// waitPID waits for a script to write a PID to a file next to it
+func waitPID(t *testing.T, path string) int {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		data, _ := os.ReadFile(path)
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return pid
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no PID in %s", path)
+	return 0
+}
+
+// Stop sends SIGINT, then SIGTERM, then SIGKILL to the process group,
+// moving on only when the previous signal did not end the process
+func TestStopEscalation(t *testing.T) {
+	shortenStopWaits(t)
+	tests := []struct {
+		name     string
+		traps    string
+		signal   string
+		graceful bool
+		exitCode int
+	}{
+		{"exits on SIGINT", `trap 'exit 0' INT`, "interrupt", true, 0},
+		{"ignores SIGINT", `trap '' INT; trap 'exit 3' TERM`, "terminated", false, 3},
+		{"ignores SIGINT and SIGTERM", `trap '' INT TERM`, "killed", false, -1},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The worker inherits ignored signals, so only what ends the
+			// bot ends it too
+			r := newTestRunner(t, tt.traps+`
+sh -c 'while :; do sleep 0.05; done' worker &
+echo $! > "$(dirname "$0")/worker.pid"
+while :; do sleep 0.05; done
+`)
+			if err := r.Start(models.Instance{ID: "bot", RestartPolicy: RestartAlways}); err != nil {
+				t.Fatal(err)
+			}
+			worker := waitPID(t, filepath.Join(r.pbPath, "worker.pid"))
+			t.Cleanup(func() { signalGroup(worker, os.Kill) })
+
+			result, err := r.Stop("bot", 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Signal != tt.signal || result.Graceful != tt.graceful {
+				t.Errorf("stopped by %s (graceful %v), want %s (graceful %v)", result.Signal, result.Graceful, tt.signal, tt.graceful)
+			}
+			if result.ExitCode == nil || *result.ExitCode != tt.exitCode {
+				t.Errorf("exit code %v, want %d", result.ExitCode, tt.exitCode)
+			}
+			escalations := 0
+			for _, text := range logTexts(t, r.Runner, "bot") {
+				if strings.Contains(text, "escalating") {
+					escalations++
+				}
+			}
+			if escalations != i {
+				t.Errorf("%d escalations logged, want %d", escalations, i)
+			}
+			if ev, _ := r.lastEvent("bot"); ev.Status != StatusStopped || ev.Error != "" {
+				t.Errorf("last event %s %q, want stopped without error", ev.Status, ev.Error)
+			}
+
+			// Background jobs of sh ignore SIGINT, so the worker only
+			// goes with the later signals sent to the group
+			if tt.graceful {
+				return
+			}
+			for j := 0; j < 100 && processAlive(worker); j++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if processAlive(worker) {
+				t.Errorf("worker survived %s", result.Signal)
+			}
+		})
+	}
+}
+
+// fakeBacktest stands in for passivbot's backtest.py: it forks a worker,
+// records both PIDs with its config path next to itself and runs until
+// killed
+const fakeBacktest = `sh -c 'while :; do sleep 0.05; done' worker "$2" &
+echo "$$ $! $2" > "$(dirname "$0")/backtest.pids"
+while :; do sleep 0.05; done
+`
+
+// backtestProcesses waits for the fake backtest to record its processes
+func backtestProcesses(t *testing.T, pbPath string) []PIDInfo {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		data, _ := os.ReadFile(filepath.Join(pbPath, "backtest.pids"))
+		if fields := strings.Fields(string(data)); len(fields) == 3 {
+			var infos []PIDInfo
+			for _, f := range fields[:2] {
+				pid, _ := strconv.Atoi(f)
+				infos = append(infos, PIDInfo{PID: pid, ConfigPath: fields[2]})
+			}
+			return infos
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("backtest did not start")
+	return nil
+}
+
+// Cancelling a backtest kills the workers it forked along with it, rather
+// than leaving them to hold its output open
+func TestRunBacktestCancel(t *testing.T) {
+	r := newTestRunner(t, "")
+	os.WriteFile(filepath.Join(r.pbPath, "backtest.py"), []byte(fakeBacktest), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.RunBacktest(ctx, "job", models.BacktestParams{Symbol: "BTCUSDT"}, nil)
+		done <- err
+	}()
+	procs := backtestProcesses(t, r.pbPath)
+	for _, p := range procs {
+		if !p.Alive() {
+			t.Fatalf("process %d not running", p.PID)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RunBacktest = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunBacktest did not return after cancel")
+	}
+	for _, p := range procs {
+		for i := 0; i < 100 && p.Alive(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p.Alive() {
+			t.Errorf("process %d survived the cancel", p.PID)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(r.workspace.root, "jobs", "job")); !os.IsNotExist(err) {
+		t.Errorf("job workspace kept: %v", err)
+	}
+}
+
+// Every line a process writes, on either stream, reaches each live
+// subscriber in order and the persisted log with the same sequence numbers
+func TestOutputFanOut(t *testing.T) {
+	r := newTestRunner(t, "trap 'echo stopping; exit 0' INT TERM\necho hello\necho oops >&2\nwhile :; do sleep 0.05; done\n")
+	subs := []*Subscription{r.SubscribeLogs("bot"), r.SubscribeLogs("bot")}
+	if err := r.Start(models.Instance{ID: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the startup output on both streams before shutting down
+	for stream, text := range map[string]string{"stdout": "hello", "stderr": "oops"} {
+		for i := 0; i < 500 && !strings.Contains(r.streamLog("bot", stream), text); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if _, err := r.Stop("bot", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	persisted, err := r.GetLogs("bot", LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sub := range subs {
+		var got []LogLine
+	receive:
+		for len(got) < len(persisted) {
+			select {
+			case line := <-sub.C:
+				got = append(got, line)
+			case <-time.After(time.Second):
+				break receive
+			}
+		}
+		sub.Close()
+		if !reflect.DeepEqual(streamTexts(got), streamTexts(persisted)) || !reflect.DeepEqual(seqs(got), seqs(persisted)) {
+			t.Errorf("subscriber %d got %q, want %q", i, streamTexts(got), streamTexts(persisted))
+		}
+	}
+	// The streams are tailed separately, so only their own order is kept
+	for stream, want := range map[string]string{"stdout": "hello\nstopping", "stderr": "oops"} {
+		if got := r.streamLog("bot", stream); got != want {
+			t.Errorf("%s logged %q, want %q", stream, got, want)
+		}
+	}
+	if n := r.hub.Subscribers("bot"); n != 0 {
+		t.Errorf("%d subscribers left after closing", n)
+	}
+}
+
+// streamLog returns the texts logged so far from one stream of an instance
+func (r *testRunner) streamLog(instanceID, stream string) string {
+	lines, err := r.GetLogs(instanceID, LogQuery{})
+	if err != nil {
+		return ""
+	}
+	var texts []string
+	for _, l := range lines {
+		if l.Stream == stream {
+			texts = append(texts, l.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func streamTexts(lines []LogLine) []string {
+	var out []string
+	for _, l := range lines {
+		out = append(out, l.Stream+": "+l.Text)
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package passivbot
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchQuery describes a full-text search over persisted instance logs
+type SearchQuery struct {
+	Pattern       string
+	Regex         bool
+	CaseSensitive bool
+	InstanceIDs   []string // empty searches every instance with logs
+	Since         time.Time
+	Until         time.Time
+	Filter        LogFilter
+	Context       int // lines of context before and after each match
+	Offset        int
+	Limit         int
+}
+
+// SearchMatch is one matching line with its surrounding context
+type SearchMatch struct {
+	InstanceID string    `json:"instance_id"`
+	Line       LogLine   `json:"line"`
+	Before     []LogLine `json:"before,omitempty"`
+	After      []LogLine `json:"after,omitempty"`
+}
+
+// SearchResult is one page of matches plus the total number of matches
+type SearchResult struct {
+	Total   int           `json:"total"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// Instances lists the IDs of every instance that has persisted logs
+func (s *LogStore) Instances() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() && validInstanceID(e.Name()) {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Search scans persisted logs of the selected instances, in instance order
+// and chronologically within each instance. Every match is counted, but
+// context is only collected for matches on the requested page.
+func (s *LogStore) Search(q SearchQuery) (*SearchResult, error) {
+	match, err := textMatcher(q.Pattern, q.Regex, q.CaseSensitive)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := q.InstanceIDs
+	if len(ids) == 0 {
+		if ids, err = s.Instances(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &SearchResult{Matches: []SearchMatch{}}
+	for _, id := range ids {
+		if err := s.searchInstance(id, q, match, result); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range result.Matches {
+		m := &result.Matches[i]
+		m.Before = parseAll(m.Before)
+		m.After = parseAll(m.After)
+	}
+	return result, nil
+}
+
+func (s *LogStore) searchInstance(instanceID string, q SearchQuery, match func(string) bool, result *SearchResult) error {
+	segs, err := s.segments(instanceID)
+	if err != nil {
+		return err
+	}
+	segs = overlapping(segs, q.Since, q.Until)
+	rangeQuery := LogQuery{Since: q.Since, Until: q.Until, Filter: q.Filter}
+
+	// Context carries across segment boundaries within an instance
+	var window []LogLine
+	var pending []int // indexes into result.Matches still collecting After
+	for _, seg := range segs {
+		err := scanSegment(seg, func(l LogLine) bool {
+			still := pending[:0]
+			for _, i := range pending {
+				m := &result.Matches[i]
+				m.After = append(m.After, l)
+				if len(m.After) < q.Context {
+					still = append(still, i)
+				}
+			}
+			pending = still
+
+			if match(l.Text) && rangeQuery.match(&l) {
+				n := result.Total
+				result.Total++
+				if n >= q.Offset && (q.Limit <= 0 || n < q.Offset+q.Limit) {
+					result.Matches = append(result.Matches, SearchMatch{
+						InstanceID: instanceID,
+						Line:       parseAll([]LogLine{l})[0],
+						Before:     append([]LogLine(nil), window...),
+					})
+					if q.Context > 0 {
+						pending = append(pending, len(result.Matches)-1)
+					}
+				}
+			}
+
+			if q.Context > 0 {
+				if len(window) == q.Context {
+					window = window[1:]
+				}
+				window = append(window, l)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textMatcher builds the line predicate for a search pattern
+func textMatcher(pattern string, isRegex, caseSensitive bool) (func(string) bool, error) {
+	if isRegex {
+		if !caseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	if caseSensitive {
+		return func(text string) bool { return strings.Contains(text, pattern) }, nil
+	}
+	lower := strings.ToLower(pattern)
+	return func(text string) bool { return strings.Contains(strings.ToLower(text), lower) }, nil
+}
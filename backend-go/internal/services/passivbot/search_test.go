@@ -0,0 +1,124 @@
+package passivbot
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSearchStore writes ten lines to each of the instances "a" and "b",
+// rotating every few lines. Lines 0, 3, 6 and 9 read "match <i>", the
+// others "line <i>". Segments are dated by when they rotate, so lines are
+// dated as written; the times of instance "a"'s lines are returned.
+func newSearchStore(t *testing.T) (*LogStore, []time.Time) {
+	t.Helper()
+	s := NewLogStore(t.TempDir(), RotationPolicy{MaxSize: 100})
+	var times []time.Time
+	for _, id := range []string{"b", "a"} {
+		times = times[:0]
+		for i := 0; i < 10; i++ {
+			text := fmt.Sprintf("line %d", i)
+			if i%3 == 0 {
+				text = fmt.Sprintf("match %d", i)
+			}
+			line := LogLine{Time: time.Now(), Stream: "stdout", Text: text}
+			if err := s.Append(id, line, nil); err != nil {
+				t.Fatal(err)
+			}
+			times = append(times, line.Time)
+			time.Sleep(time.Millisecond)
+		}
+		dir, _ := s.Dir(id)
+		waitCompressed(t, dir)
+	}
+	return s, times
+}
+
+// matchKeys renders matches as "<instance>:<seq>"
+func matchKeys(matches []SearchMatch) []string {
+	var out []string
+	for _, m := range matches {
+		out = append(out, fmt.Sprintf("%s:%d", m.InstanceID, m.Line.Seq))
+	}
+	return out
+}
+
+func TestSearchPaging(t *testing.T) {
+	s, at := newSearchStore(t)
+
+	tests := []struct {
+		name      string
+		query     SearchQuery
+		wantTotal int
+		want      []string
+	}{
+		{"all, instances in order", SearchQuery{Pattern: "match"}, 8,
+			[]string{"a:1", "a:4", "a:7", "a:10", "b:1", "b:4", "b:7", "b:10"}},
+		{"first page", SearchQuery{Pattern: "match", Limit: 3}, 8, []string{"a:1", "a:4", "a:7"}},
+		{"page across instances", SearchQuery{Pattern: "match", Offset: 2, Limit: 3}, 8, []string{"a:7", "a:10", "b:1"}},
+		{"offset past the end", SearchQuery{Pattern: "match", Offset: 20, Limit: 3}, 8, nil},
+		{"selected instance", SearchQuery{Pattern: "match", InstanceIDs: []string{"b"}, Limit: 2}, 4, []string{"b:1", "b:4"}},
+		{"case insensitive by default", SearchQuery{Pattern: "MATCH", Limit: 1}, 8, []string{"a:1"}},
+		{"case sensitive", SearchQuery{Pattern: "MATCH", CaseSensitive: true}, 0, nil},
+		{"regex", SearchQuery{Pattern: `^match [36]$`, Regex: true}, 4, []string{"a:4", "a:7", "b:4", "b:7"}},
+		{"time range", SearchQuery{Pattern: "match", Since: at[3], Until: at[8], InstanceIDs: []string{"a"}}, 2, []string{"a:4", "a:7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Search(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", result.Total, tt.wantTotal)
+			}
+			if got := matchKeys(result.Matches); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchContext(t *testing.T) {
+	s, _ := newSearchStore(t)
+
+	tests := []struct {
+		name       string
+		query      SearchQuery
+		wantBefore []uint64
+		wantAfter  []uint64
+	}{
+		{"none", SearchQuery{Pattern: "match 3"}, nil, nil},
+		{"around a match", SearchQuery{Pattern: "match 3", Context: 2}, []uint64{2, 3}, []uint64{5, 6}},
+		{"at the start", SearchQuery{Pattern: "match 0", Context: 2}, nil, []uint64{2, 3}},
+		{"at the end", SearchQuery{Pattern: "match 9", Context: 2}, []uint64{8, 9}, nil},
+		{"spans segments", SearchQuery{Pattern: "match 6", Context: 4}, []uint64{3, 4, 5, 6}, []uint64{8, 9, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.InstanceIDs = []string{"a"}
+			result, err := s.Search(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Matches) != 1 {
+				t.Fatalf("%d matches, want 1", len(result.Matches))
+			}
+			m := result.Matches[0]
+			if got := seqs(m.Before); !reflect.DeepEqual(got, tt.wantBefore) {
+				t.Errorf("before = %v, want %v", got, tt.wantBefore)
+			}
+			if got := seqs(m.After); !reflect.DeepEqual(got, tt.wantAfter) {
+				t.Errorf("after = %v, want %v", got, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestSearchInvalidRegex(t *testing.T) {
+	s := NewLogStore(t.TempDir(), RotationPolicy{})
+	if _, err := s.Search(SearchQuery{Pattern: "(", Regex: true}); err == nil {
+		t.Error("invalid regex accepted")
+	}
+}
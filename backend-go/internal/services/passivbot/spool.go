@@ -0,0 +1,200 @@
+package passivbot
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	spoolPollInterval = 200 * time.Millisecond
+
+	// Once this much more of a spool file is in the log, the disk space of
+	// the logged part is given back, so a long-running instance does not
+	// keep a second copy of its output
+	spoolReclaimSize = 8 << 20
+)
+
+var spoolStreams = []string{"stdout", "stderr"}
+
+// Passivbot writes its output to spool files in the run directory rather than
+// to pipes, so it keeps running when the backend exits. The backend tails the
+// files into the instance's log and checkpoints how far it got, so a restarted
+// backend picks up exactly where the previous one stopped.
+
+func (r *Runner) spoolPath(instanceID, stream string) string {
+	return filepath.Join(r.runDir, instanceID+"."+stream)
+}
+
+// openSpools creates fresh spool files for a new process, first collecting
+// anything a previous process left unread
+func (r *Runner) openSpools(instanceID string) (stdout, stderr *os.File, err error) {
+	if err := os.MkdirAll(r.runDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	r.drainSpools(instanceID)
+
+	files := make([]*os.File, len(spoolStreams))
+	for i, stream := range spoolStreams {
+		path := r.spoolPath(instanceID, stream)
+		os.Remove(offsetPath(path))
+		files[i], err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+		if err != nil {
+			for _, f := range files[:i] {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return files[0], files[1], nil
+}
+
+// tailSpools follows an instance's spool files from their checkpoints
+func (r *Runner) tailSpools(instanceID string) []*spoolTail {
+	var tails []*spoolTail
+	for _, stream := range spoolStreams {
+		t := r.newSpoolTail(instanceID, stream)
+		go t.run()
+		tails = append(tails, t)
+	}
+	return tails
+}
+
+// drainSpools collects the remaining output of a process that exited while
+// nobody was tailing it, then removes its spool files
+func (r *Runner) drainSpools(instanceID string) {
+	for _, stream := range spoolStreams {
+		t := r.newSpoolTail(instanceID, stream)
+		t.drain()
+		t.out.Flush()
+	}
+	r.removeSpools(instanceID)
+}
+
+func (r *Runner) removeSpools(instanceID string) {
+	for _, stream := range spoolStreams {
+		path := r.spoolPath(instanceID, stream)
+		os.Remove(path)
+		os.Remove(offsetPath(path))
+	}
+}
+
+// spoolTail copies new output from a spool file into the instance's log
+type spoolTail struct {
+	path      string
+	offset    int64 // bytes of the file passed to out
+	reclaimed int64 // bytes at the start of the file whose space was freed
+	out       *lineWriter
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func (r *Runner) newSpoolTail(instanceID, stream string) *spoolTail {
+	path := r.spoolPath(instanceID, stream)
+	return &spoolTail{
+		path:   path,
+		offset: readOffset(path),
+		out:    r.outputWriter(instanceID, stream),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (t *spoolTail) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(spoolPollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.drain()
+		select {
+		case <-t.stop:
+			// The process has exited; pick up its last output
+			t.drain()
+			t.out.Flush()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close stops tailing after a final read and waits for it to finish
+func (t *spoolTail) close() {
+	close(t.stop)
+	<-t.done
+}
+
+// drain copies everything written since the last read
+func (t *spoolTail) drain() {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() < t.offset {
+		// Truncated behind our back; start over
+		t.offset = 0
+	}
+	if info.Size() == t.offset {
+		return
+	}
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return
+	}
+	n, _ := io.Copy(t.out, f)
+	t.offset += n
+	t.checkpoint()
+	t.reclaim()
+}
+
+// checkpoint records how far the log has been written. A trailing partial
+// line is not yet in the log, so it is read again after a backend restart.
+func (t *spoolTail) checkpoint() {
+	os.WriteFile(offsetPath(t.path), []byte(strconv.FormatInt(t.logged(), 10)), 0644)
+}
+
+// logged is how much of the file is in the log
+func (t *spoolTail) logged() int64 {
+	return t.offset - int64(len(t.out.buf))
+}
+
+// reclaim frees the disk space of the logged part of a large spool file.
+// The file is never truncated while the process may be writing to it, which
+// would lose output written in between; freeing space leaves its size and
+// everything after the logged part as they are. Spool files start over
+// empty when the instance is started again.
+func (t *spoolTail) reclaim() {
+	logged := t.logged()
+	if logged-t.reclaimed < spoolReclaimSize {
+		return
+	}
+	if err := punchHole(t.path, logged); err != nil {
+		return
+	}
+	t.reclaimed = logged
+}
+
+func offsetPath(spoolPath string) string {
+	return spoolPath + ".offset"
+}
+
+func readOffset(spoolPath string) int64 {
+	data, err := os.ReadFile(offsetPath(spoolPath))
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
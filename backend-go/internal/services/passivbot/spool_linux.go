@@ -0,0 +1,18 @@
+package passivbot
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// punchHole frees the disk blocks of the first n bytes of a file without
+// changing its size; they read back as zeros
+func punchHole(path string, n int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, 0, n)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package passivbot
+
+import "errors"
+
+// punchHole is not available; spool files keep their space until the
+// instance is started again
+func punchHole(path string, n int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,300 @@
+package passivbot
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+func newSpoolRunner(t *testing.T) *Runner {
+	t.Helper()
+	return &Runner{
+		runDir: t.TempDir(),
+		logs:   NewLogStore(t.TempDir(), RotationPolicy{}),
+		hub:    NewLogHub(10, 0, 10),
+	}
+}
+
+// logTexts returns the texts of an instance's persisted lines
+func logTexts(t *testing.T, r *Runner, instanceID string) []string {
+	t.Helper()
+	lines, err := r.logs.Read(instanceID, LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, l := range lines {
+		out = append(out, l.Text)
+	}
+	return out
+}
+
+func appendSpool(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolResume(t *testing.T) {
+	// Each step is output written by the process, then a read by a tail
+	// that is either the running one or, with restart, a new one as after
+	// a backend restart
+	type step struct {
+		write   string
+		restart bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  []string
+	}{
+		{"one tail", []step{{write: "a\nb\n"}, {write: "c\n"}}, []string{"a", "b", "c"}},
+		{"restart after whole lines", []step{{write: "a\nb\n"}, {write: "c\n", restart: true}}, []string{"a", "b", "c"}},
+		{"restart without new output", []step{{write: "a\n"}, {restart: true}, {write: "b\n"}}, []string{"a", "b"}},
+		{"restart inside a line", []step{{write: "a\npar"}, {write: "tial\nb\n", restart: true}}, []string{"a", "partial", "b"}},
+		{"several restarts", []step{{write: "a\n"}, {write: "b\nc", restart: true}, {write: "d\n", restart: true}}, []string{"a", "b", "cd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSpoolRunner(t)
+			path := r.spoolPath("bot", "stdout")
+			tail := r.newSpoolTail("bot", "stdout")
+			for _, s := range tt.steps {
+				appendSpool(t, path, s.write)
+				if s.restart {
+					// The old tail stops without a final flush, as when the
+					// backend is killed
+					tail = r.newSpoolTail("bot", "stdout")
+				}
+				tail.drain()
+			}
+			tail.out.Flush()
+
+			if got := logTexts(t, r, "bot"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		content *string
+		want    int64
+	}{
+		{"missing", nil, 0},
+		{"valid", strPtr("42"), 42},
+		{"trailing newline", strPtr("42\n"), 42},
+		{"garbage", strPtr("x"), 0},
+		{"negative", strPtr("-5"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/bot.stdout"
+			if tt.content != nil {
+				os.WriteFile(offsetPath(path), []byte(*tt.content), 0644)
+			}
+			if got := readOffset(path); got != tt.want {
+				t.Errorf("readOffset = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestSpoolRewritten(t *testing.T) {
+	r := newSpoolRunner(t)
+	path := r.spoolPath("bot", "stdout")
+	appendSpool(t, path, "first run\n")
+	tail := r.newSpoolTail("bot", "stdout")
+	tail.drain()
+
+	// A file shorter than the checkpoint was replaced; read it from the start
+	os.WriteFile(path, []byte("new\n"), 0644)
+	tail.drain()
+
+	if got, want := logTexts(t, r, "bot"), []string{"first run", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestDrainSpools(t *testing.T) {
+	r := newSpoolRunner(t)
+	out, errOut := r.spoolPath("bot", "stdout"), r.spoolPath("bot", "stderr")
+	appendSpool(t, out, "seen\n")
+	tail := r.newSpoolTail("bot", "stdout")
+	tail.drain()
+
+	// The process exits while nobody tails its output
+	appendSpool(t, out, "unseen\nlast words")
+	appendSpool(t, errOut, "Traceback\n")
+	r.drainSpools("bot")
+
+	if got, want := logTexts(t, r, "bot"), []string{"seen", "unseen", "last words", "Traceback"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+	for _, path := range []string{out, errOut, offsetPath(out), offsetPath(errOut)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", path)
+		}
+	}
+}
+
+func TestOpenSpools(t *testing.T) {
+	r := newSpoolRunner(t)
+	path := r.spoolPath("bot", "stdout")
+	appendSpool(t, path, "from the previous process\n")
+
+	stdout, stderr, err := r.openSpools("bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout.WriteString("new\n")
+	stdout.Close()
+	stderr.Close()
+
+	// Leftovers are logged before the files start over, without a checkpoint
+	if got, want := logTexts(t, r, "bot"), []string{"from the previous process"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("spool holds %q, want only the new output", data)
+	}
+	if got := readOffset(path); got != 0 {
+		t.Errorf("checkpoint %d, want 0", got)
+	}
+}
+
+func TestSpoolReclaim(t *testing.T) {
+	r := newSpoolRunner(t)
+	path := r.spoolPath("bot", "stdout")
+	line := strings.Repeat("x", 1023) + "\n"
+	appendSpool(t, path, strings.Repeat(line, spoolReclaimSize/len(line)+1)+"partial")
+
+	// Collect lines directly; parsing megabytes of them into the log is slow
+	tail := r.newSpoolTail("bot", "stdout")
+	var last string
+	tail.out = &lineWriter{emit: func(text string) { last = text }}
+	tail.drain()
+	size := tail.offset
+	if tail.reclaimed != 0 && tail.reclaimed != tail.logged() {
+		t.Errorf("reclaimed %d bytes, want 0 or the %d logged", tail.reclaimed, tail.logged())
+	}
+
+	// Freeing space keeps the file's size and the unlogged rest, so the
+	// running process and the tail carry on where they were
+	appendSpool(t, path, " line\n")
+	tail.drain()
+	if tail.offset != size+6 {
+		t.Errorf("offset %d, want %d", tail.offset, size+6)
+	}
+	if last != "partial line" {
+		t.Errorf("last line %q, want \"partial line\"", last)
+	}
+	if got := readOffset(path); got != tail.offset {
+		t.Errorf("checkpoint %d, want %d", got, tail.offset)
+	}
+}
+
+// waitSpool waits until an instance's spool file holds want
+func waitSpool(t *testing.T, r *testRunner, instanceID, stream, want string) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if data, _ := os.ReadFile(r.spoolPath(instanceID, stream)); strings.Contains(string(data), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s %s never got %q", instanceID, stream, want)
+}
+
+// Output written while no backend runs reaches the log once a restarted
+// backend adopts the process, each line exactly once
+func TestSpoolAcrossRestart(t *testing.T) {
+	before := newTestRunner(t, `d=$(dirname "$2")
+echo first
+printf 'half '
+until [ -e "$d/away" ]; do sleep 0.05; done
+echo line
+echo while away
+echo warning >&2
+until [ -e "$d/exit" ]; do sleep 0.05; done
+echo last
+`)
+	instance := models.Instance{ID: "bot"}
+	before.leaveRunning(t, instance)
+
+	// The previous backend logged what was there when it went away
+	waitSpool(t, before, "bot", "stdout", "half ")
+	for _, stream := range spoolStreams {
+		before.newSpoolTail("bot", stream).drain()
+	}
+	before.logs.Close("bot")
+	before.touch(t, "bot", "away")
+	waitSpool(t, before, "bot", "stderr", "warning")
+
+	r := runnerIn(t, before.dir)
+	if ok, err := r.Adopt(instance); !ok || err != nil {
+		t.Fatalf("Adopt = %v, %v", ok, err)
+	}
+	r.touch(t, "bot", "exit")
+	r.waitEvent(t, "bot", StatusError)
+
+	lines, err := r.logs.Read("bot", LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, l := range lines {
+		if l.Stream != "system" {
+			got[l.Stream] = append(got[l.Stream], l.Text)
+		}
+	}
+	want := map[string][]string{"stdout": {"first", "half line", "while away", "last"}, "stderr": {"warning"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+	for _, stream := range spoolStreams {
+		path := r.spoolPath("bot", stream)
+		for _, p := range []string{path, offsetPath(path)} {
+			if _, err := os.Stat(p); !os.IsNotExist(err) {
+				t.Errorf("%s left behind", p)
+			}
+		}
+	}
+}
+
+// A process that exited while no backend ran has its last output logged
+// when the restarted backend finds it gone
+func TestSpoolExitedWhileAway(t *testing.T) {
+	before := newTestRunner(t, "echo seen\nprintf 'last words'\nexit 1\n")
+	info := before.leaveRunning(t, models.Instance{ID: "bot"})
+	waitSpool(t, before, "bot", "stdout", "last words")
+	before.newSpoolTail("bot", "stdout").drain()
+	before.logs.Close("bot")
+	waitDead(t, info)
+
+	r := runnerIn(t, before.dir)
+	if ok, err := r.Adopt(models.Instance{ID: "bot"}); ok || err != nil {
+		t.Fatalf("Adopt = %v, %v", ok, err)
+	}
+	got := logTexts(t, r.Runner, "bot")
+	if len(got) != 3 || got[0] != "seen" || got[1] != "last words" {
+		t.Errorf("logged %q, want seen, last words and the exit", got)
+	}
+	if _, err := os.Stat(r.spoolPath("bot", "stdout")); !os.IsNotExist(err) {
+		t.Errorf("spool left behind: %v", err)
+	}
+}
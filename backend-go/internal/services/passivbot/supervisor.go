@@ -0,0 +1,276 @@
+package passivbot
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// Instance statuses reported by the runner
+const (
+	StatusRunning    = "running"
+	StatusRestarting = "restarting"
+	StatusStopped    = "stopped"
+	StatusError      = "error"
+)
+
+// Restart policies, set per instance
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+var RestartPolicies = []string{RestartNever, RestartOnFailure, RestartAlways}
+
+const (
+	defaultMaxRestarts   = 5
+	defaultRestartWindow = 10 * time.Minute
+
+	restartMaxDelay = 5 * time.Minute
+
+	// A process that stays up this long resets the backoff
+	stableUptime = 10 * time.Minute
+)
+
+// restartBaseDelay is the delay before the first automatic restart, doubled
+// for each consecutive failure; tests shorten it
+var restartBaseDelay = 2 * time.Second
+
+// StateEvent reports an instance status change detected by the runner
+type StateEvent struct {
+	InstanceID    string
+	Status        string
+	ExitCode      *int
+	Error         string
+	Restarts      int // automatic restarts since the last manual start
+	NextRestartAt *time.Time
+	Time          time.Time
+}
+
+// process is a passivbot instance started by this runner
+type process struct {
+	instance  models.Instance
+	pid       int
+	startedAt time.Time
+	adopted   bool         // started by an earlier run of the backend
+	tails     []*spoolTail // copy its output files into the log
+	stopping  atomic.Bool  // set by Stop so the exit is not treated as a crash
+	limits    string       // how resource limits are enforced
+	usage     usageSampler
+
+	done     chan struct{} // closed once the exit has been handled
+	exitCode int           // valid after done is closed
+}
+
+func newProcess(instance models.Instance, pid int, startedAt time.Time) *process {
+	return &process{instance: instance, pid: pid, startedAt: startedAt, done: make(chan struct{})}
+}
+
+// exited records the exit code and wakes anyone waiting in Stop
+func (p *process) exited(code int) {
+	p.exitCode = code
+	close(p.done)
+}
+
+// release finishes collecting an exited process's output and forgets it,
+// unless a newer process already replaced it
+func (r *Runner) release(p *process) {
+	for _, t := range p.tails {
+		t.close()
+	}
+	id := p.instance.ID
+	if current, ok := r.processes.Load(id); !ok || current == p {
+		r.removeSpools(id)
+		r.removePIDFile(id)
+		r.removeAPIKeys(id)
+		r.releaseLimits(id)
+		r.processes.CompareAndDelete(id, p)
+	}
+}
+
+// restartState tracks automatic restarts of one instance
+type restartState struct {
+	history []time.Time // recent automatic restarts, for crash-loop detection
+	attempt int         // consecutive failures, drives the backoff
+	total   int
+	timer   *time.Timer // pending restart, if any
+}
+
+// OnStateChange registers fn to receive instance status changes. It must
+// be called before any instance is started.
+func (r *Runner) OnStateChange(fn func(StateEvent)) {
+	r.onState = fn
+}
+
+func (r *Runner) emit(ev StateEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if r.onState != nil {
+		r.onState(ev)
+	}
+}
+
+// restartPolicy returns the instance's policy with defaults applied
+func restartPolicy(instance models.Instance) (mode string, maxRestarts int, window time.Duration) {
+	mode = instance.RestartPolicy
+	if mode == "" {
+		mode = RestartNever
+	}
+	maxRestarts = instance.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = defaultMaxRestarts
+	}
+	window = time.Duration(instance.RestartWindow) * time.Second
+	if window <= 0 {
+		window = defaultRestartWindow
+	}
+	return mode, maxRestarts, window
+}
+
+// backoff returns the delay before the given restart attempt
+func backoff(attempt int) time.Duration {
+	delay := restartBaseDelay
+	for i := 0; i < attempt && delay < restartMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > restartMaxDelay {
+		delay = restartMaxDelay
+	}
+	return delay
+}
+
+// handleExit applies the instance's restart policy after its process ended.
+// code is the exit code, or -1 if the process was killed or never started.
+func (r *Runner) handleExit(p *process, code int, exitErr error) {
+	id := p.instance.ID
+	ev := StateEvent{InstanceID: id, ExitCode: &code}
+	if exitErr != nil {
+		ev.Error = exitErr.Error()
+	}
+
+	mode, maxRestarts, window := restartPolicy(p.instance)
+	// Stop marks the process under r.mu, so an exit it raced with is seen
+	// as stopped here rather than restarted
+	r.mu.Lock()
+	stopping := p.stopping.Load()
+	restart := !stopping &&
+		(mode == RestartAlways || (mode == RestartOnFailure && code != 0))
+	if !restart {
+		if st := r.restarts[id]; st != nil {
+			ev.Restarts = st.total
+		}
+		r.mu.Unlock()
+		ev.Status = StatusStopped
+		if stopping {
+			// Exiting on our own signal is not an error
+			ev.Error = ""
+		} else if code != 0 {
+			ev.Status = StatusError
+		}
+		r.emit(ev)
+		return
+	}
+
+	now := time.Now()
+	st := r.restarts[id]
+	if st == nil {
+		st = &restartState{}
+		r.restarts[id] = st
+	}
+	if now.Sub(p.startedAt) >= stableUptime {
+		st.attempt = 0
+	}
+	recent := st.history[:0]
+	for _, t := range st.history {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	st.history = recent
+	ev.Restarts = st.total
+
+	// Crash loop: give up and leave the instance in error
+	if len(st.history) >= maxRestarts {
+		r.mu.Unlock()
+		ev.Status = StatusError
+		ev.Error = fmt.Sprintf("crash loop: %d restarts within %s, last exit: %s", len(recent), window, exitDescription(code, exitErr))
+		r.logSystem(id, ev.Error)
+		r.emit(ev)
+		return
+	}
+
+	delay := backoff(st.attempt)
+	st.attempt++
+	st.total++
+	st.history = append(st.history, now)
+	ev.Restarts = st.total
+	instance := p.instance
+	st.timer = time.AfterFunc(delay, func() { r.restart(instance) })
+	r.mu.Unlock()
+
+	next := now.Add(delay)
+	ev.Status = StatusRestarting
+	ev.NextRestartAt = &next
+	r.logSystem(id, fmt.Sprintf("%s; restarting in %s (policy %s)", exitDescription(code, exitErr), delay, mode))
+	r.emit(ev)
+}
+
+// restart runs a scheduled automatic restart unless it was cancelled or a
+// process was started meanwhile
+func (r *Runner) restart(instance models.Instance) {
+	r.mu.Lock()
+	st := r.restarts[instance.ID]
+	if st == nil || st.timer == nil {
+		// Cancelled by Stop or superseded by a manual Start
+		r.mu.Unlock()
+		return
+	}
+	st.timer = nil
+	if _, ok := r.processes.Load(instance.ID); ok {
+		// Started while the restart was pending, e.g. by a reconcile pass
+		r.mu.Unlock()
+		return
+	}
+	restarts := st.total
+	p, err := r.start(instance)
+	r.mu.Unlock()
+
+	if err != nil {
+		r.handleExit(newProcess(instance, 0, time.Now()), -1, err)
+		return
+	}
+	r.emit(StateEvent{InstanceID: instance.ID, Status: StatusRunning, Restarts: restarts, Time: p.startedAt})
+}
+
+// cancelRestart drops any pending automatic restart. With reset it also
+// forgets the restart history, as after a manual start. r.mu must be held.
+func (r *Runner) cancelRestart(instanceID string, reset bool) (cancelled bool) {
+	st := r.restarts[instanceID]
+	if st == nil {
+		return false
+	}
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+		cancelled = true
+	}
+	if reset {
+		delete(r.restarts, instanceID)
+	}
+	return cancelled
+}
+
+func exitDescription(code int, err error) string {
+	switch {
+	case err != nil && code == -1:
+		return fmt.Sprintf("process ended: %v", err)
+	case code == 0:
+		return "process exited normally"
+	default:
+		return fmt.Sprintf("process exited with code %d", code)
+	}
+}
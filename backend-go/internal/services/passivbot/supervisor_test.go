@@ -0,0 +1,140 @@
+package passivbot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// waitEvent waits until an instance's latest state change has the given
+// status
+func (r *testRunner) waitEvent(t *testing.T, instanceID, status string) StateEvent {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if ev, ok := r.lastEvent(instanceID); ok && ev.Status == status {
+			return ev
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ev, _ := r.lastEvent(instanceID)
+	t.Fatalf("%s is %s, want %s", instanceID, ev.Status, status)
+	return ev
+}
+
+// statuses lists the state changes of an instance
+func (r *testRunner) statuses(instanceID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, ev := range r.events {
+		if ev.InstanceID == instanceID {
+			out = append(out, ev.Status)
+		}
+	}
+	return out
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 2 * time.Second},
+		{1, 4 * time.Second},
+		{2, 8 * time.Second},
+		{7, 256 * time.Second},
+		{8, restartMaxDelay},
+		{100, restartMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		code   int
+		want   string
+	}{
+		{RestartNever, 0, StatusStopped},
+		{RestartNever, 1, StatusError},
+		{RestartOnFailure, 0, StatusStopped},
+		{RestartOnFailure, 1, StatusRestarting},
+		{RestartAlways, 0, StatusRestarting},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s exit %d", tt.policy, tt.code), func(t *testing.T) {
+			r := newTestRunner(t, fmt.Sprintf("exit %d\n", tt.code))
+			if err := r.Start(models.Instance{ID: "bot", RestartPolicy: tt.policy}); err != nil {
+				t.Fatal(err)
+			}
+			ev := r.waitEvent(t, "bot", tt.want)
+			if ev.ExitCode == nil || *ev.ExitCode != tt.code {
+				t.Errorf("exit code %v, want %d", ev.ExitCode, tt.code)
+			}
+			if tt.want != StatusRestarting {
+				return
+			}
+			if ev.NextRestartAt == nil || ev.Restarts != 1 {
+				t.Errorf("restart %d at %v, want the first one scheduled", ev.Restarts, ev.NextRestartAt)
+			}
+
+			// Stopping cancels the pending restart
+			if _, err := r.Stop("bot", time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if status, ok := r.Supervised("bot"); ok {
+				t.Errorf("%s after Stop", status)
+			}
+			if ev, _ := r.lastEvent("bot"); ev.Status != StatusStopped {
+				t.Errorf("last status %s, want stopped", ev.Status)
+			}
+		})
+	}
+}
+
+// Restarts back off exponentially until too many fall within the window,
+// when the instance is left in error; a manual start begins afresh
+func TestCrashLoop(t *testing.T) {
+	base := restartBaseDelay
+	restartBaseDelay = 20 * time.Millisecond
+	t.Cleanup(func() { restartBaseDelay = base })
+
+	r := newTestRunner(t, "echo crashing\nexit 1\n")
+	instance := models.Instance{ID: "bot", RestartPolicy: RestartOnFailure, MaxRestarts: 3, RestartWindow: 60}
+	for round := 0; round < 2; round++ {
+		from := len(r.statuses("bot"))
+		if err := r.Start(instance); err != nil {
+			t.Fatal(err)
+		}
+		ev := r.waitEvent(t, "bot", StatusError)
+		if !strings.HasPrefix(ev.Error, "crash loop: 3 restarts within 1m0s") || ev.Restarts != 3 {
+			t.Errorf("round %d: final event %d restarts, error %q", round, ev.Restarts, ev.Error)
+		}
+		want := []string{StatusRunning, StatusRestarting, StatusRunning, StatusRestarting, StatusRunning, StatusRestarting, StatusRunning, StatusError}
+		if got := r.statuses("bot")[from:]; !reflect.DeepEqual(got, want) {
+			t.Errorf("round %d: statuses %q, want %q", round, got, want)
+		}
+		if status, ok := r.Supervised("bot"); ok {
+			t.Errorf("round %d: %s after giving up", round, status)
+		}
+	}
+
+	var delays []string
+	for _, text := range logTexts(t, r.Runner, "bot") {
+		if i := strings.Index(text, "restarting in "); i >= 0 {
+			delays = append(delays, strings.Fields(text[i+len("restarting in "):])[0])
+		}
+	}
+	want := []string{"20ms", "40ms", "80ms", "20ms", "40ms", "80ms"}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("restart delays %q, want %q", delays, want)
+	}
+}
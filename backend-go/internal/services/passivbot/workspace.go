@@ -0,0 +1,104 @@
+package passivbot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Workspace holds the files passivbot is started with: one private
+// directory per instance and per job. Files are only readable by the
+// backend's user and are replaced atomically, so a process never reads a
+// half-written config.
+type Workspace struct {
+	root string
+}
+
+func NewWorkspace(root string) *Workspace {
+	return &Workspace{root: root}
+}
+
+// InstanceDir returns an instance's directory, creating it if needed
+func (w *Workspace) InstanceDir(instanceID string) (string, error) {
+	return w.dir("instances", instanceID)
+}
+
+// JobDir returns a job's directory, creating it if needed
+func (w *Workspace) JobDir(jobID string) (string, error) {
+	return w.dir("jobs", jobID)
+}
+
+// RemoveInstance deletes an instance's directory and everything in it
+func (w *Workspace) RemoveInstance(instanceID string) error {
+	return w.remove("instances", instanceID)
+}
+
+// RemoveJob deletes a job's directory and everything in it
+func (w *Workspace) RemoveJob(jobID string) error {
+	return w.remove("jobs", jobID)
+}
+
+// instancePath is the path of a file in an instance's directory, which
+// may not exist yet
+func (w *Workspace) instancePath(instanceID, name string) string {
+	return filepath.Join(w.root, "instances", instanceID, name)
+}
+
+func (w *Workspace) dir(kind, id string) (string, error) {
+	if err := checkWorkspaceID(id); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(w.root, kind, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// MkdirAll leaves existing directories alone; tighten them too
+	if err := os.Chmod(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (w *Workspace) remove(kind, id string) error {
+	if err := checkWorkspaceID(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(w.root, kind, id))
+}
+
+// checkWorkspaceID keeps IDs from naming anything outside their directory
+func checkWorkspaceID(id string) error {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return fmt.Errorf("invalid workspace id %q", id)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file with mode 0600 next to
+// path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
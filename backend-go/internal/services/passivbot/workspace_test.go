@@ -0,0 +1,203 @@
+package passivbot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// checkMode fails unless path has the given permission bits, where the
+// platform has them
+func checkMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != want {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode().Perm(), want)
+	}
+}
+
+func TestWorkspaceDirs(t *testing.T) {
+	root := t.TempDir()
+	w := NewWorkspace(root)
+
+	dir, err := w.InstanceDir("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != filepath.Join(root, "instances", "a") {
+		t.Errorf("instance dir %s", dir)
+	}
+	checkMode(t, dir, 0700)
+
+	// An existing directory is tightened
+	loose := filepath.Join(root, "jobs", "j")
+	os.MkdirAll(loose, 0755)
+	if dir, err = w.JobDir("j"); err != nil || dir != loose {
+		t.Fatalf("JobDir = %s, %v", dir, err)
+	}
+	checkMode(t, loose, 0700)
+
+	os.WriteFile(filepath.Join(loose, "config.json"), []byte("{}"), 0600)
+	if err := w.RemoveJob("j"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(loose); !os.IsNotExist(err) {
+		t.Errorf("job dir still exists: %v", err)
+	}
+	if err := w.RemoveInstance("missing"); err != nil {
+		t.Errorf("removing a missing instance: %v", err)
+	}
+}
+
+func TestWorkspaceInvalidIDs(t *testing.T) {
+	root := t.TempDir()
+	w := NewWorkspace(root)
+	os.WriteFile(filepath.Join(root, "keep"), nil, 0600)
+
+	for _, id := range []string{"", ".", "..", "a/b", "../keep", "/etc"} {
+		if _, err := w.InstanceDir(id); err == nil {
+			t.Errorf("InstanceDir(%q) accepted", id)
+		}
+		if _, err := w.JobDir(id); err == nil {
+			t.Errorf("JobDir(%q) accepted", id)
+		}
+		if err := w.RemoveInstance(id); err == nil {
+			t.Errorf("RemoveInstance(%q) accepted", id)
+		}
+		if err := w.RemoveJob(id); err == nil {
+			t.Errorf("RemoveJob(%q) accepted", id)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "keep")); err != nil {
+		t.Errorf("file outside the workspace removed: %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte("old"), 0644)
+
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("content %q", data)
+	}
+	checkMode(t, path, 0600)
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %d entries", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "x"), nil); err == nil {
+		t.Error("wrote into a missing directory")
+	}
+}
+
+// An instance's config and API keys live in its private directory: the
+// keys only while it runs, the directory until the instance is deleted
+func TestInstanceFiles(t *testing.T) {
+	r := newTestRunner(t, "trap 'exit 0' INT TERM\nwhile :; do sleep 0.05; done\n")
+	instance := models.Instance{
+		ID:        "a",
+		Config:    `{"bot":{"long":{"n_positions":1,"total_wallet_exposure_limit":1}}}`,
+		AccountID: "acc",
+		Credentials: &models.APICredentials{
+			User: AccountUser("acc"), Exchange: "binance", Key: "k", Secret: "s",
+		},
+	}
+	if err := r.Start(instance); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := r.workspace.instancePath("a", "")
+	keysPath := filepath.Join(dir, "api-keys.json")
+	checkMode(t, dir, 0700)
+	checkMode(t, keysPath, 0600)
+	checkMode(t, filepath.Join(dir, "config.json"), 0600)
+
+	var keys map[string]apiKeyEntry
+	data, _ := os.ReadFile(keysPath)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+	if want := (apiKeyEntry{Exchange: "binance", Key: "k", Secret: "s"}); keys["pbgui_acc"] != want || len(keys) != 1 {
+		t.Errorf("api keys %+v", keys)
+	}
+	var config struct {
+		Live map[string]string `json:"live"`
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "config.json"))
+	json.Unmarshal(data, &config)
+	if config.Live["user"] != "pbgui_acc" || config.Live["api_keys_filepath"] != keysPath {
+		t.Errorf("config points at %+v", config.Live)
+	}
+
+	if err := r.RemoveInstanceFiles("a"); err == nil {
+		t.Error("removed the files of a running instance")
+	}
+	if _, err := r.Stop("a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keysPath); !os.IsNotExist(err) {
+		t.Errorf("API keys kept after stop: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.json")); err != nil {
+		t.Errorf("config removed on stop: %v", err)
+	}
+
+	if err := r.RemoveInstanceFiles("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("instance dir kept after removal: %v", err)
+	}
+}
+
+func TestInjectAccount(t *testing.T) {
+	r := &Runner{workspace: NewWorkspace("/ws")}
+	instance := models.Instance{ID: "i", AccountID: "acc"}
+	keys := filepath.Join("/ws", "instances", "i", "api-keys.json")
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name:   "v7 with live",
+			config: map[string]interface{}{"bot": map[string]interface{}{}, "live": map[string]interface{}{"leverage": 5.0}},
+			want: map[string]interface{}{"bot": map[string]interface{}{}, "live": map[string]interface{}{
+				"leverage": 5.0, "user": "pbgui_acc", "api_keys_filepath": keys,
+			}},
+		},
+		{
+			name:   "v7 without live",
+			config: map[string]interface{}{"bot": map[string]interface{}{}},
+			want: map[string]interface{}{"bot": map[string]interface{}{}, "live": map[string]interface{}{
+				"user": "pbgui_acc", "api_keys_filepath": keys,
+			}},
+		},
+		{
+			name:   "legacy",
+			config: map[string]interface{}{"symbol": "BTCUSDT"},
+			want:   map[string]interface{}{"symbol": "BTCUSDT", "user": "pbgui_acc", "api_keys_filepath": keys},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.injectAccount(tt.config, instance)
+			if !reflect.DeepEqual(tt.config, tt.want) {
+				t.Errorf("config = %v, want %v", tt.config, tt.want)
+			}
+		})
+	}
+}
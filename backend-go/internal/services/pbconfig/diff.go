@@ -0,0 +1,120 @@
+// Package pbconfig works with passivbot config documents: validating them
+// against the schema of their passivbot version, comparing revisions and
+// similar operations that do not involve running passivbot.
+package pbconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change operations reported by Diff
+const (
+	OpAdded   = "added"
+	OpRemoved = "removed"
+	OpChanged = "changed"
+)
+
+// Change is one difference between two configs. Path is a JSON Pointer
+// (RFC 6901) to the changed value; "" is the whole document.
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Diff compares two JSON configs structurally, so formatting and key order
+// do not matter. An empty config is treated as null.
+func Diff(from, to string) ([]Change, error) {
+	a, err := parse(from)
+	if err != nil {
+		return nil, fmt.Errorf("old config: %w", err)
+	}
+	b, err := parse(to)
+	if err != nil {
+		return nil, fmt.Errorf("new config: %w", err)
+	}
+
+	changes := []Change{}
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+func parse(doc string) (interface{}, error) {
+	if strings.TrimSpace(doc) == "" {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func diffValues(path string, a, b interface{}, out *[]Change) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			diffObjects(path, av, bv, out)
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			diffArrays(path, av, bv, out)
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, Change{Path: path, Op: OpChanged, Old: a, New: b})
+	}
+}
+
+func diffObjects(path string, a, b map[string]interface{}, out *[]Change) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			*out = append(*out, Change{Path: p, Op: OpAdded, New: bv})
+		case !inB:
+			*out = append(*out, Change{Path: p, Op: OpRemoved, Old: av})
+		default:
+			diffValues(p, av, bv, out)
+		}
+	}
+}
+
+func diffArrays(path string, a, b []interface{}, out *[]Change) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := path + "/" + strconv.Itoa(i)
+		switch {
+		case i >= len(a):
+			*out = append(*out, Change{Path: p, Op: OpAdded, New: b[i]})
+		case i >= len(b):
+			*out = append(*out, Change{Path: p, Op: OpRemoved, Old: a[i]})
+		default:
+			diffValues(p, a[i], b[i], out)
+		}
+	}
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
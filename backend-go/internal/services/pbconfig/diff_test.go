@@ -0,0 +1,69 @@
+package pbconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []Change
+	}{
+		{"identical", `{"a":1,"b":{"c":true}}`, `{"b":{"c":true},"a":1}`, []Change{}},
+		{"formatting only", `{"a": [1, 2]}`, "{\n  \"a\": [1,2]\n}", []Change{}},
+		{"changed value", `{"a":1}`, `{"a":2}`, []Change{{Path: "/a", Op: OpChanged, Old: 1.0, New: 2.0}}},
+		{"added and removed keys, sorted", `{"b":1,"z":true}`, `{"a":"x","b":1}`, []Change{
+			{Path: "/a", Op: OpAdded, New: "x"},
+			{Path: "/z", Op: OpRemoved, Old: true},
+		}},
+		{"nested", `{"bot":{"long":{"n_positions":1}}}`, `{"bot":{"long":{"n_positions":3}}}`, []Change{
+			{Path: "/bot/long/n_positions", Op: OpChanged, Old: 1.0, New: 3.0},
+		}},
+		{"arrays by index", `{"a":[1,2,3]}`, `{"a":[1,5]}`, []Change{
+			{Path: "/a/1", Op: OpChanged, Old: 2.0, New: 5.0},
+			{Path: "/a/2", Op: OpRemoved, Old: 3.0},
+		}},
+		{"type change", `{"a":{"b":1}}`, `{"a":[1]}`, []Change{
+			{Path: "/a", Op: OpChanged, Old: map[string]interface{}{"b": 1.0}, New: []interface{}{1.0}},
+		}},
+		{"keys escaped as JSON pointers", `{}`, `{"coin_overrides":{"BTC/USDT":1,"a~b":2}}`, []Change{
+			{Path: "/coin_overrides", Op: OpAdded, New: map[string]interface{}{"BTC/USDT": 1.0, "a~b": 2.0}},
+		}},
+		{"escaped nested keys", `{"o":{}}`, `{"o":{"BTC/USDT":1,"a~b":2}}`, []Change{
+			{Path: "/o/BTC~1USDT", Op: OpAdded, New: 1.0},
+			{Path: "/o/a~0b", Op: OpAdded, New: 2.0},
+		}},
+		{"from empty", ``, `{"a":1}`, []Change{{Path: "", Op: OpChanged, New: map[string]interface{}{"a": 1.0}}}},
+		{"both empty", ``, ` `, []Change{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffInvalid(t *testing.T) {
+	tests := []struct {
+		name, from, to string
+	}{
+		{"old", `{`, `{}`},
+		{"new", `{}`, `{"a":}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Diff(tt.from, tt.to); err == nil {
+				t.Error("invalid JSON accepted")
+			}
+		})
+	}
+}
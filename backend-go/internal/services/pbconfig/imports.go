@@ -0,0 +1,243 @@
+package pbconfig
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hjson/hjson-go/v4"
+)
+
+const (
+	// Optimizer result files hold one result per line; only this many are
+	// imported from one file
+	maxImportResults = 100
+
+	maxImportFileSize = 8 << 20
+	maxZipEntries     = 1000
+)
+
+// Keys optimizer results add next to the config they were produced with
+var resultKeys = []string{"analysis", "analyses", "analyses_combined", "metrics", "results", "result"}
+
+// ImportedConfig is one config read from an imported file
+type ImportedConfig struct {
+	Source   string                 `json:"source"` // file name, with "#<line>" for result lines
+	Name     string                 `json:"name"`
+	Exchange string                 `json:"exchange,omitempty"`
+	Symbol   string                 `json:"symbol,omitempty"`
+	Config   string                 `json:"-"`
+	Version  string                 `json:"schema_version"`
+	Metrics  map[string]interface{} `json:"metrics,omitempty"` // optimizer analysis, if any
+}
+
+// ImportError is a file or config that could not be imported
+type ImportError struct {
+	Source string       `json:"source"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// ParseFile reads passivbot configs from one uploaded file: a .json or
+// .hjson config or optimizer result, a .txt/.jsonl file of optimizer
+// results with one JSON document per line, or a .zip of such files.
+// Configs are normalized to JSON and validated.
+func ParseFile(name string, data []byte) ([]ImportedConfig, []ImportError) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		return parseZip(name, data)
+	case ".json", ".hjson":
+		return importDocument(name, baseName(name), data)
+	case ".txt", ".jsonl", ".ndjson":
+		return parseResultLines(name, data)
+	}
+	return nil, []ImportError{{Source: name, Error: "unsupported file type: expected .json, .hjson, .txt, .jsonl or .zip"}}
+}
+
+func parseZip(name string, data []byte) ([]ImportedConfig, []ImportError) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, []ImportError{{Source: name, Error: fmt.Sprintf("invalid zip: %v", err)}}
+	}
+	if len(zr.File) > maxZipEntries {
+		return nil, []ImportError{{Source: name, Error: fmt.Sprintf("zip has more than %d entries", maxZipEntries)}}
+	}
+
+	var configs []ImportedConfig
+	var errs []ImportError
+	for _, f := range zr.File {
+		source := name + "/" + f.Name
+		base := path.Base(f.Name)
+		// Skip directories, macOS metadata and files that are not configs,
+		// like READMEs bundled with a config archive
+		if f.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		switch strings.ToLower(path.Ext(base)) {
+		case ".json", ".hjson", ".txt", ".jsonl", ".ndjson":
+		default:
+			continue
+		}
+
+		content, err := readZipFile(f)
+		if err != nil {
+			errs = append(errs, ImportError{Source: source, Error: err.Error()})
+			continue
+		}
+		c, e := ParseFile(source, content)
+		configs = append(configs, c...)
+		errs = append(errs, e...)
+	}
+	return configs, errs
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxImportFileSize {
+		return nil, fmt.Errorf("file is larger than %d MB", maxImportFileSize>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// The header size can lie; never read past the limit
+	data, err := io.ReadAll(io.LimitReader(rc, maxImportFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportFileSize {
+		return nil, fmt.Errorf("file is larger than %d MB", maxImportFileSize>>20)
+	}
+	return data, nil
+}
+
+// parseResultLines reads optimizer results stored one JSON document per line
+func parseResultLines(name string, data []byte) ([]ImportedConfig, []ImportError) {
+	var configs []ImportedConfig
+	var errs []ImportError
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxImportFileSize)
+	n, imported := 0, 0
+	for scanner.Scan() {
+		n++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if imported == maxImportResults {
+			errs = append(errs, ImportError{Source: name, Error: fmt.Sprintf("only the first %d results are imported", maxImportResults)})
+			break
+		}
+		imported++
+		c, e := importDocument(fmt.Sprintf("%s#%d", name, n), fmt.Sprintf("%s #%d", baseName(name), n), line)
+		configs = append(configs, c...)
+		errs = append(errs, e...)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, ImportError{Source: name, Error: err.Error()})
+	}
+	return configs, errs
+}
+
+// importDocument normalizes and validates one JSON or HJSON document
+func importDocument(source, name string, data []byte) ([]ImportedConfig, []ImportError) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		// HJSON is a superset of JSON, so this also reports JSON errors
+		if err := hjson.Unmarshal(data, &v); err != nil {
+			return nil, []ImportError{{Source: source, Error: fmt.Sprintf("invalid JSON/HJSON: %v", err)}}
+		}
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, []ImportError{{Source: source, Error: "config must be an object"}}
+	}
+
+	config, metrics := splitResult(doc)
+	if n, ok := config["config_name"].(string); ok && n != "" {
+		name = n
+	}
+	out, err := json.Marshal(config)
+	if err != nil {
+		return nil, []ImportError{{Source: source, Error: err.Error()}}
+	}
+
+	if err := Validate(string(out)); err != nil {
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			return nil, []ImportError{{Source: source, Error: err.Error(), Fields: invalid.Errors}}
+		}
+		return nil, []ImportError{{Source: source, Error: err.Error()}}
+	}
+	exchange, symbol := identify(config)
+	return []ImportedConfig{{
+		Source:   source,
+		Name:     name,
+		Exchange: exchange,
+		Symbol:   symbol,
+		Config:   string(out),
+		Version:  Detect(config).Version,
+		Metrics:  metrics,
+	}}, nil
+}
+
+// identify finds the exchange and symbol a config trades, where it names a
+// single one
+func identify(config map[string]interface{}) (exchange, symbol string) {
+	exchange, _ = config["exchange"].(string)
+	symbol, _ = config["symbol"].(string)
+	if exchanges, ok := lookupSlice(config, "/backtest/exchanges"); ok && exchange == "" && len(exchanges) == 1 {
+		exchange, _ = exchanges[0].(string)
+	}
+	if coins, ok := lookupSlice(config, "/live/approved_coins"); ok && symbol == "" && len(coins) == 1 {
+		symbol, _ = coins[0].(string)
+	}
+	return exchange, symbol
+}
+
+func lookupSlice(doc map[string]interface{}, path string) ([]interface{}, bool) {
+	v, _ := lookup(doc, path)
+	list, ok := v.([]interface{})
+	return list, ok
+}
+
+// splitResult separates a config from the metrics an optimizer stored with
+// it. Some optimizers nest the config under "config". doc is not modified.
+func splitResult(doc map[string]interface{}) (config, metrics map[string]interface{}) {
+	metrics = map[string]interface{}{}
+	source := doc
+	if inner, ok := doc["config"].(map[string]interface{}); ok {
+		source = inner
+		for k, v := range doc {
+			if k != "config" {
+				metrics[k] = v
+			}
+		}
+	}
+
+	config = make(map[string]interface{}, len(source))
+	for k, v := range source {
+		if contains(resultKeys, k) {
+			metrics[k] = v
+			continue
+		}
+		config[k] = v
+	}
+	if len(metrics) == 0 {
+		metrics = nil
+	}
+	return config, metrics
+}
+
+func baseName(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
@@ -0,0 +1,264 @@
+package pbconfig
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zipOf builds a zip archive of the given files; names ending in "/" are
+// directories
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "/") {
+			w.Write([]byte(content))
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compact renders a JSON document on one line
+func compact(t *testing.T, doc string) string {
+	t.Helper()
+	out, err := MergePatch(doc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestParseFile(t *testing.T) {
+	v7Line := compact(t, v7Config)
+	tests := []struct {
+		name        string
+		file        string
+		data        string
+		wantNames   []string
+		wantSources []string
+		wantErrors  []string // sources of errors
+	}{
+		{
+			name: "json config", file: "btc.json", data: v7Config,
+			wantNames: []string{"btc"}, wantSources: []string{"btc.json"},
+		},
+		{
+			name: "config_name wins", file: "btc.json", data: v7With(t, `{"config_name":"my bot"}`),
+			wantNames: []string{"my bot"}, wantSources: []string{"btc.json"},
+		},
+		{
+			name: "hjson", file: "btc.hjson",
+			data:      "{\n  # comment\n  bot: {\n    long: {n_positions: 1, total_wallet_exposure_limit: 1}\n    short: {n_positions: 0, total_wallet_exposure_limit: 0}\n  }\n}",
+			wantNames: []string{"btc"}, wantSources: []string{"btc.hjson"},
+		},
+		{
+			name: "invalid config", file: "bad.json", data: v7With(t, `{"live":{"leverage":500}}`),
+			wantErrors: []string{"bad.json"},
+		},
+		{
+			name: "invalid syntax", file: "bad.json", data: `{"bot":`,
+			wantErrors: []string{"bad.json"},
+		},
+		{
+			name: "not an object", file: "bad.json", data: `[1]`,
+			wantErrors: []string{"bad.json"},
+		},
+		{
+			name: "unsupported type", file: "btc.yaml", data: "bot: {}",
+			wantErrors: []string{"btc.yaml"},
+		},
+		{
+			name: "result lines", file: "results.txt",
+			data:        v7Line + "\n\n" + `{"bot":` + "\n" + v7Line + "\n",
+			wantNames:   []string{"results #1", "results #4"},
+			wantSources: []string{"results.txt#1", "results.txt#4"},
+			wantErrors:  []string{"results.txt#3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, errs := ParseFile(tt.file, []byte(tt.data))
+			var names, sources, errSources []string
+			for _, c := range configs {
+				names = append(names, c.Name)
+				sources = append(sources, c.Source)
+			}
+			for _, e := range errs {
+				errSources = append(errSources, e.Source)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %q, want %q", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("sources = %q, want %q", sources, tt.wantSources)
+			}
+			if !reflect.DeepEqual(errSources, tt.wantErrors) {
+				t.Errorf("errors from %q, want %q (%v)", errSources, tt.wantErrors, errs)
+			}
+		})
+	}
+}
+
+func TestParseFileNormalizes(t *testing.T) {
+	hjsonConfig := "{\n  bot: {\n    long: {n_positions: 1, total_wallet_exposure_limit: 1}\n    short: {n_positions: 0, total_wallet_exposure_limit: 0}\n  }\n  live: {leverage: 5}\n}"
+	configs, errs := ParseFile("btc.hjson", []byte(hjsonConfig))
+	if len(errs) > 0 || len(configs) != 1 {
+		t.Fatalf("got %d configs and errors %v", len(configs), errs)
+	}
+	c := configs[0]
+	if c.Version != VersionV7 {
+		t.Errorf("version = %q, want %q", c.Version, VersionV7)
+	}
+	if !jsonEqual(t, c.Config, v7Config) {
+		t.Errorf("config = %s, want %s", c.Config, v7Config)
+	}
+}
+
+func TestParseFileValidationFields(t *testing.T) {
+	_, errs := ParseFile("bad.json", []byte(v7With(t, `{"live":{"leverage":500}}`)))
+	if len(errs) != 1 || len(errs[0].Fields) != 1 || errs[0].Fields[0].Path != "/live/leverage" {
+		t.Errorf("errors = %+v, want one for /live/leverage", errs)
+	}
+}
+
+func TestParseFileResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantMetrics []string
+		wantConfig  string
+	}{
+		{
+			name:       "plain config",
+			data:       v7Config,
+			wantConfig: v7Config,
+		},
+		{
+			name:        "metrics next to the config",
+			data:        v7With(t, `{"analysis":{"adg":0.001}}`),
+			wantMetrics: []string{"analysis"},
+			wantConfig:  v7Config,
+		},
+		{
+			name:        "config nested under config",
+			data:        fmt.Sprintf(`{"config":%s,"metrics":{"adg":0.001},"w":1}`, v7Config),
+			wantMetrics: []string{"metrics", "w"},
+			wantConfig:  v7Config,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, errs := ParseFile("result.json", []byte(tt.data))
+			if len(errs) > 0 || len(configs) != 1 {
+				t.Fatalf("got %d configs and errors %v", len(configs), errs)
+			}
+			var keys []string
+			for k := range configs[0].Metrics {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantMetrics) {
+				t.Errorf("metrics keys = %q, want %q", keys, tt.wantMetrics)
+			}
+			if !jsonEqual(t, configs[0].Config, tt.wantConfig) {
+				t.Errorf("config = %s, want %s", configs[0].Config, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestParseFileIdentify(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantExchange string
+		wantSymbol   string
+	}{
+		{"legacy", `{"exchange":"bybit","symbol":"ETHUSDT"}`, "bybit", "ETHUSDT"},
+		{"v7 single coin", v7With(t, `{"backtest":{"exchanges":["binance"]},"live":{"approved_coins":["BTC"]}}`), "binance", "BTC"},
+		{"v7 several", v7With(t, `{"backtest":{"exchanges":["binance","bybit"]},"live":{"approved_coins":["BTC","ETH"]}}`), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, errs := ParseFile("c.json", []byte(tt.data))
+			if len(errs) > 0 || len(configs) != 1 {
+				t.Fatalf("got %d configs and errors %v", len(configs), errs)
+			}
+			if configs[0].Exchange != tt.wantExchange || configs[0].Symbol != tt.wantSymbol {
+				t.Errorf("identified %q %q, want %q %q", configs[0].Exchange, configs[0].Symbol, tt.wantExchange, tt.wantSymbol)
+			}
+		})
+	}
+}
+
+func TestParseFileResultLimit(t *testing.T) {
+	line := compact(t, v7Config)
+	data := strings.Repeat(line+"\n", maxImportResults+5)
+	configs, errs := ParseFile("all.jsonl", []byte(data))
+	if len(configs) != maxImportResults {
+		t.Errorf("%d configs, want %d", len(configs), maxImportResults)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want one about the limit", errs)
+	}
+}
+
+func TestParseZip(t *testing.T) {
+	line := compact(t, v7Config)
+	data := zipOf(t, map[string]string{
+		"configs/":                "",
+		"configs/btc.json":        v7Config,
+		"configs/results.jsonl":   line + "\n" + line + "\n",
+		"configs/README.md":       "# not a config",
+		"configs/.hidden.json":    "{",
+		"__MACOSX/configs/x.json": "{",
+		"configs/bad.hjson":       "{bot:",
+	})
+
+	configs, errs := ParseFile("bundle.zip", data)
+	var sources []string
+	for _, c := range configs {
+		sources = append(sources, c.Source)
+	}
+	want := []string{"bundle.zip/configs/btc.json", "bundle.zip/configs/results.jsonl#1", "bundle.zip/configs/results.jsonl#2"}
+	if !sameSet(sources, want) {
+		t.Errorf("sources = %q, want %q", sources, want)
+	}
+	if len(errs) != 1 || errs[0].Source != "bundle.zip/configs/bad.hjson" {
+		t.Errorf("errors = %v, want one for bad.hjson", errs)
+	}
+
+	if _, errs := ParseFile("broken.zip", []byte("not a zip")); len(errs) != 1 {
+		t.Errorf("errors = %v, want one for an invalid zip", errs)
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		if seen[s] == 0 {
+			return false
+		}
+		seen[s]--
+	}
+	return true
+}
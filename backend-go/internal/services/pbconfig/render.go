@@ -0,0 +1,184 @@
+package pbconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"pbgui-backend/internal/models"
+)
+
+// Render returns the config an instance runs with: its own config, or its
+// template's config with the instance's overrides merged in, plus its
+// symbols. The template and symbols must be attached to the instance.
+func Render(instance models.Instance) (string, error) {
+	rendered := instance.Config
+	if instance.TemplateID != "" {
+		if instance.Template == nil || instance.Template.ID != instance.TemplateID {
+			return "", fmt.Errorf("template %s is not loaded", instance.TemplateID)
+		}
+		var err error
+		rendered, err = MergePatch(instance.Template.Config, instance.Overrides)
+		if err != nil {
+			return "", fmt.Errorf("template %s: %w", instance.Template.Name, err)
+		}
+	}
+	if len(instance.Symbols) == 0 {
+		return rendered, nil
+	}
+	return applySymbols(rendered, instance.Symbols)
+}
+
+// applySymbols writes a multi-symbol instance's coins into the config:
+// live.approved_coins per side and coin_overrides per coin. Overrides
+// already in the config for other coins are kept. An empty config starts
+// from a default trading each side's coins; legacy configs trade a single
+// symbol and are rejected.
+func applySymbols(config string, symbols []models.InstanceSymbol) (string, error) {
+	v, err := parse(config)
+	if err != nil {
+		return "", err
+	}
+	var doc map[string]interface{}
+	switch c := v.(type) {
+	case nil:
+		doc = defaultSymbolsConfig(symbols)
+	case map[string]interface{}:
+		if len(c) == 0 {
+			doc = defaultSymbolsConfig(symbols)
+			break
+		}
+		if Detect(c) == legacySchema {
+			return "", fmt.Errorf("symbols need a passivbot 7 config; legacy configs trade a single symbol")
+		}
+		doc = c
+	default:
+		return "", fmt.Errorf("config must be a JSON object")
+	}
+	live, ok := doc["live"].(map[string]interface{})
+	if !ok {
+		live = map[string]interface{}{}
+		doc["live"] = live
+	}
+	overrides, ok := doc["coin_overrides"].(map[string]interface{})
+	if !ok {
+		overrides = map[string]interface{}{}
+	}
+
+	long, short := []string{}, []string{}
+	for _, s := range symbols {
+		if s.Long {
+			long = append(long, s.Symbol)
+		}
+		if s.Short {
+			short = append(short, s.Symbol)
+		}
+		if strings.TrimSpace(s.Overrides) == "" {
+			continue
+		}
+		o, err := parse(s.Overrides)
+		if err != nil {
+			return "", fmt.Errorf("symbol %s overrides: %w", s.Symbol, err)
+		}
+		overrides[s.Symbol] = o
+	}
+	live["approved_coins"] = map[string]interface{}{"long": long, "short": short}
+	if len(overrides) > 0 {
+		doc["coin_overrides"] = overrides
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// defaultSymbolsConfig is a passivbot 7 config with one position per
+// approved coin on each side and, as in the runner's legacy default,
+// leverage 1 and 0.1 wallet exposure per position
+func defaultSymbolsConfig(symbols []models.InstanceSymbol) map[string]interface{} {
+	positions := map[string]int{}
+	for _, s := range symbols {
+		if s.Long {
+			positions["long"]++
+		}
+		if s.Short {
+			positions["short"]++
+		}
+	}
+	bot := map[string]interface{}{}
+	for _, side := range []string{"long", "short"} {
+		n := positions[side]
+		bot[side] = map[string]interface{}{
+			"n_positions":                 n,
+			"total_wallet_exposure_limit": float64(n) / 10,
+		}
+	}
+	return map[string]interface{}{"bot": bot, "live": map[string]interface{}{"leverage": 1.0}}
+}
+
+// Quote currencies stripped from exchange-native symbols
+var quoteCurrencies = []string{"USDT", "USDC", "BUSD", "USD"}
+
+// CoinName reduces a symbol to the coin name passivbot 7 uses in
+// approved_coins, e.g. "BTC/USDT:USDT", "BTCUSDT" and "btc" all become "BTC"
+func CoinName(symbol string) string {
+	coin := strings.ToUpper(strings.TrimSpace(symbol))
+	if i := strings.IndexAny(coin, "/:"); i >= 0 {
+		return coin[:i]
+	}
+	for _, quote := range quoteCurrencies {
+		if len(coin) > len(quote) && strings.HasSuffix(coin, quote) {
+			return strings.TrimSuffix(coin, quote)
+		}
+	}
+	return coin
+}
+
+// MergePatch applies a JSON merge patch (RFC 7386) to a JSON document:
+// objects are merged recursively, null removes a key and any other value
+// replaces it. An empty patch leaves the document unchanged.
+func MergePatch(doc, patch string) (string, error) {
+	base, err := parse(doc)
+	if err != nil {
+		return "", fmt.Errorf("config: %w", err)
+	}
+	p, err := parse(patch)
+	if err != nil {
+		return "", fmt.Errorf("overrides: %w", err)
+	}
+	if strings.TrimSpace(patch) == "" {
+		p = map[string]interface{}{}
+	}
+
+	out, err := json.Marshal(mergeValue(base, p))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = map[string]interface{}{}
+	}
+
+	out := make(map[string]interface{}, len(tm)+len(pm))
+	for k, v := range tm {
+		out[k] = v
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		out[k] = mergeValue(out[k], v)
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package pbconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"pbgui-backend/internal/models"
+)
+
+// jsonEqual reports whether two JSON documents are structurally equal
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	var av, bv interface{}
+	if err := json.Unmarshal([]byte(a), &av); err != nil {
+		t.Fatalf("invalid JSON %q: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &bv); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b, err)
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7386, appendix A, plus the empty documents
+	// templates and overrides may have
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"remove one of two", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"array replaced", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"by array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"arrays not merged", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"object into scalar", `{"a":"foo"}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{"empty patch", `{"a":1}`, ``, `{"a":1}`},
+		{"empty object patch", `{"a":1}`, `{}`, `{"a":1}`},
+		{"empty document", ``, `{"a":1}`, `{"a":1}`},
+		{"override a v7 setting", v7Config, `{"bot":{"long":{"n_positions":3}},"live":{"leverage":null}}`,
+			`{"bot":{"long":{"n_positions":3,"total_wallet_exposure_limit":1},"short":{"n_positions":0,"total_wallet_exposure_limit":0}},"live":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch(tt.doc, tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, got, tt.want) {
+				t.Errorf("MergePatch = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+	}{
+		{"document", `{`, `{}`},
+		{"patch", `{}`, `{"a":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MergePatch(tt.doc, tt.patch); err == nil {
+				t.Error("invalid JSON accepted")
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	template := &models.ConfigTemplate{ID: "t1", Name: "base", Config: `{"live":{"leverage":5},"bot":{"long":{"n_positions":1}}}`}
+	tests := []struct {
+		name     string
+		instance models.Instance
+		want     string
+		wantErr  bool
+	}{
+		{"own config", models.Instance{Config: `{"a":1}`}, `{"a":1}`, false},
+		{"template", models.Instance{TemplateID: "t1", Template: template}, template.Config, false},
+		{"template with overrides", models.Instance{TemplateID: "t1", Template: template, Overrides: `{"live":{"leverage":10}}`},
+			`{"live":{"leverage":10},"bot":{"long":{"n_positions":1}}}`, false},
+		{"template not loaded", models.Instance{TemplateID: "t1"}, "", true},
+		{"other template loaded", models.Instance{TemplateID: "t2", Template: template}, "", true},
+		{"invalid overrides", models.Instance{TemplateID: "t1", Template: template, Overrides: `{`}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(tt.instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !jsonEqual(t, got, tt.want) {
+				t.Errorf("Render = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSymbols(t *testing.T) {
+	symbols := []models.InstanceSymbol{
+		{Symbol: "BTC", Long: true, Short: true, Overrides: `{"bot":{"long":{"n_positions":2}}}`},
+		{Symbol: "ETH", Long: true},
+	}
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr bool
+	}{
+		{"v7 config", `{"live":{"leverage":5},"coin_overrides":{"SOL":{"live":{"leverage":3}}}}`,
+			`{"live":{"leverage":5,"approved_coins":{"long":["BTC","ETH"],"short":["BTC"]}},"coin_overrides":{"SOL":{"live":{"leverage":3}},"BTC":{"bot":{"long":{"n_positions":2}}}}}`, false},
+		{"bot only", `{"bot":{"long":{"n_positions":1}}}`,
+			`{"bot":{"long":{"n_positions":1}},"live":{"approved_coins":{"long":["BTC","ETH"],"short":["BTC"]}},"coin_overrides":{"BTC":{"bot":{"long":{"n_positions":2}}}}}`, false},
+		{"empty config", ``,
+			`{"bot":{"long":{"n_positions":2,"total_wallet_exposure_limit":0.2},"short":{"n_positions":1,"total_wallet_exposure_limit":0.1}},"live":{"leverage":1,"approved_coins":{"long":["BTC","ETH"],"short":["BTC"]}},"coin_overrides":{"BTC":{"bot":{"long":{"n_positions":2}}}}}`, false},
+		{"empty object", `{}`,
+			`{"bot":{"long":{"n_positions":2,"total_wallet_exposure_limit":0.2},"short":{"n_positions":1,"total_wallet_exposure_limit":0.1}},"live":{"leverage":1,"approved_coins":{"long":["BTC","ETH"],"short":["BTC"]}},"coin_overrides":{"BTC":{"bot":{"long":{"n_positions":2}}}}}`, false},
+		{"legacy config", `{"symbol":"BTCUSDT","leverage":5,"wallet_exposure_limit":0.5}`, "", true},
+		{"not an object", `[1]`, "", true},
+		{"invalid JSON", `{`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(models.Instance{Config: tt.config, Symbols: symbols})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !jsonEqual(t, got, tt.want) {
+				t.Errorf("Render = %s, want %s", got, tt.want)
+			}
+			// What passivbot is started with must validate as v7
+			if tt.config == "" {
+				if err := Validate(got); err != nil {
+					t.Errorf("default config invalid: %v", err)
+				}
+			}
+		})
+	}
+
+	// Symbols are written into a template's config too
+	template := &models.ConfigTemplate{ID: "t1", Name: "base", Config: `{"bot":{"long":{"n_positions":1}}}`}
+	got, err := Render(models.Instance{TemplateID: "t1", Template: template, Symbols: symbols[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"bot":{"long":{"n_positions":1}},"live":{"approved_coins":{"long":["ETH"],"short":[]}}}`; !jsonEqual(t, got, want) {
+		t.Errorf("Render = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+package pbconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Schema versions
+const (
+	VersionV7     = "v7"
+	VersionLegacy = "legacy"
+)
+
+func bound(v float64) *float64 { return &v }
+
+var (
+	zero = bound(0)
+	one  = bound(1)
+)
+
+// v7BotSide holds the per-side settings under bot.long and bot.short
+var v7BotSide = []Field{
+	{Path: "n_positions", Type: TypeInteger, Required: true, Min: zero},
+	{Path: "total_wallet_exposure_limit", Type: TypeNumber, Required: true, Min: zero, Max: bound(100)},
+	{Path: "close_grid_markup_range", Type: TypeNumber, Min: zero},
+	{Path: "close_grid_min_markup", Type: TypeNumber, Min: zero},
+	{Path: "close_grid_qty_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "close_trailing_grid_ratio", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "close_trailing_qty_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "close_trailing_retracement_pct", Type: TypeNumber, Min: zero},
+	{Path: "close_trailing_threshold_pct", Type: TypeNumber},
+	{Path: "ema_span_0", Type: TypeNumber, Min: zero, MinExclusive: true},
+	{Path: "ema_span_1", Type: TypeNumber, Min: zero, MinExclusive: true},
+	{Path: "entry_grid_double_down_factor", Type: TypeNumber, Min: zero},
+	{Path: "entry_grid_spacing_pct", Type: TypeNumber, Min: zero},
+	{Path: "entry_grid_spacing_weight", Type: TypeNumber, Min: zero},
+	{Path: "entry_initial_ema_dist", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "entry_initial_qty_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "entry_trailing_grid_ratio", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "entry_trailing_retracement_pct", Type: TypeNumber, Min: zero},
+	{Path: "entry_trailing_threshold_pct", Type: TypeNumber},
+	{Path: "filter_relative_volume_clip_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "filter_rolling_window", Type: TypeNumber, Min: zero},
+	{Path: "unstuck_close_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "unstuck_ema_dist", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "unstuck_loss_allowance_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "unstuck_threshold", Type: TypeNumber, Min: zero, Max: one},
+}
+
+// legacyBotSide holds the per-side settings of passivbot 5 and 6 configs
+var legacyBotSide = []Field{
+	{Path: "enabled", Type: TypeBoolean},
+	{Path: "wallet_exposure_limit", Type: TypeNumber, Min: zero, Max: bound(100)},
+	{Path: "ema_span_0", Type: TypeNumber, Min: zero, MinExclusive: true},
+	{Path: "ema_span_1", Type: TypeNumber, Min: zero, MinExclusive: true},
+	{Path: "initial_qty_pct", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "initial_eprice_ema_dist", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "min_markup", Type: TypeNumber, Min: zero},
+	{Path: "markup_range", Type: TypeNumber, Min: zero},
+	{Path: "n_close_orders", Type: TypeInteger, Min: one},
+	{Path: "ddown_factor", Type: TypeNumber, Min: zero},
+	{Path: "rentry_pprice_dist", Type: TypeNumber, Min: zero},
+	{Path: "rentry_pprice_dist_wallet_exposure_weighting", Type: TypeNumber, Min: zero},
+	{Path: "grid_span", Type: TypeNumber, Min: zero},
+	{Path: "eprice_pprice_diff", Type: TypeNumber, Min: zero},
+	{Path: "eprice_exp_base", Type: TypeNumber, Min: zero},
+	{Path: "secondary_allocation", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "secondary_pprice_diff", Type: TypeNumber, Min: zero},
+	{Path: "auto_unstuck_wallet_exposure_threshold", Type: TypeNumber, Min: zero, Max: one},
+	{Path: "auto_unstuck_ema_dist", Type: TypeNumber, Min: bound(-1), Max: one},
+	{Path: "backwards_tp", Type: TypeBoolean},
+}
+
+var v7Fields = concat(
+	[]Field{
+		{Path: "/bot", Type: TypeObject, Required: true},
+		{Path: "/bot/long", Type: TypeObject, Required: true},
+		{Path: "/bot/short", Type: TypeObject, Required: true},
+	},
+	prefixed("/bot/long/", v7BotSide),
+	prefixed("/bot/short/", v7BotSide),
+	[]Field{
+		{Path: "/live", Type: TypeObject},
+		{Path: "/live/user", Type: TypeString},
+		{Path: "/live/approved_coins", Type: "array|object|string"},
+		{Path: "/live/ignored_coins", Type: "array|object|string"},
+		{Path: "/live/coin_flags", Type: TypeObject},
+		{Path: "/live/leverage", Type: TypeNumber, Min: zero, MinExclusive: true, Max: bound(125)},
+		{Path: "/live/market_orders_allowed", Type: TypeBoolean},
+		{Path: "/live/filter_by_min_effective_cost", Type: TypeBoolean},
+		{Path: "/live/execution_delay_seconds", Type: TypeNumber, Min: zero},
+		{Path: "/live/max_n_cancellations_per_batch", Type: TypeInteger, Min: zero},
+		{Path: "/live/max_n_creations_per_batch", Type: TypeInteger, Min: zero},
+		{Path: "/live/minimum_coin_age_days", Type: TypeNumber, Min: zero},
+		{Path: "/live/pnls_max_lookback_days", Type: TypeNumber, Min: zero},
+		{Path: "/live/price_distance_threshold", Type: TypeNumber, Min: zero},
+		{Path: "/live/time_in_force", Type: TypeString, Enum: []string{"good_till_cancelled", "post_only"}},
+		{Path: "/backtest", Type: TypeObject},
+		{Path: "/backtest/start_date", Type: TypeDate},
+		{Path: "/backtest/end_date", Type: TypeDate + "|" + TypeString},
+		{Path: "/backtest/exchanges", Type: TypeArray},
+		{Path: "/backtest/starting_balance", Type: TypeNumber, Min: zero, MinExclusive: true},
+		{Path: "/backtest/base_dir", Type: TypeString},
+		{Path: "/optimize", Type: TypeObject},
+		{Path: "/coin_overrides", Type: TypeObject},
+	},
+)
+
+var v7Schema = &Schema{
+	Version:     VersionV7,
+	Description: "passivbot 7: settings nested under bot, live, backtest and optimize",
+	Fields:      v7Fields,
+	Rules: []Rule{
+		{Description: "a side with positions needs wallet exposure, and the other way round", check: v7SideEnablement},
+		{Description: "coin_overrides values follow the bot and live settings", check: v7CoinOverrides},
+		{Description: "at least one of long and short must be enabled", check: v7AnySideEnabled},
+		{Description: "backtest.start_date must be before backtest.end_date", check: v7BacktestRange},
+	},
+}
+
+var legacySchema = &Schema{
+	Version:     VersionLegacy,
+	Description: "passivbot 5 and 6: flat settings with optional long and short sections",
+	Fields: concat(
+		[]Field{
+			{Path: "/exchange", Type: TypeString},
+			{Path: "/symbol", Type: TypeString},
+			{Path: "/strategy", Type: TypeString},
+			{Path: "/config_name", Type: TypeString},
+			{Path: "/leverage", Type: TypeNumber, Min: zero, MinExclusive: true, Max: bound(125)},
+			{Path: "/wallet_exposure_limit", Type: TypeNumber, Min: zero, Max: bound(100)},
+			{Path: "/long", Type: TypeObject},
+			{Path: "/short", Type: TypeObject},
+		},
+		prefixed("/long/", legacyBotSide),
+		prefixed("/short/", legacyBotSide),
+	),
+	Rules: []Rule{
+		{Description: "an enabled side needs a positive wallet_exposure_limit", check: legacySideExposure},
+		{Description: "at least one of long and short must be enabled", check: legacyAnySideEnabled},
+	},
+}
+
+// passivbot 7 treats a side as enabled when it has both positions and
+// wallet exposure; having only one of them is almost always a typo
+func v7SideEnablement(doc map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, side := range []string{"long", "short"} {
+		positions := number(doc, "/bot/"+side+"/n_positions")
+		exposure := number(doc, "/bot/"+side+"/total_wallet_exposure_limit")
+		switch {
+		case positions > 0 && exposure == 0:
+			errs = append(errs, FieldError{
+				Path:    "/bot/" + side + "/total_wallet_exposure_limit",
+				Message: fmt.Sprintf("must be greater than 0 when n_positions is %v", positions),
+			})
+		case exposure > 0 && positions == 0:
+			errs = append(errs, FieldError{
+				Path:    "/bot/" + side + "/n_positions",
+				Message: fmt.Sprintf("must be at least 1 when total_wallet_exposure_limit is %v", exposure),
+			})
+		}
+	}
+	return errs
+}
+
+// v7CoinOverrides checks each coin's bot and live overrides against the
+// fields they override
+func v7CoinOverrides(doc map[string]interface{}) []FieldError {
+	overrides, _ := doc["coin_overrides"].(map[string]interface{})
+	var errs []FieldError
+	for coin, o := range overrides {
+		override, ok := o.(map[string]interface{})
+		prefix := "/coin_overrides/" + escapePointer(coin)
+		if !ok {
+			errs = append(errs, FieldError{Path: prefix, Message: "must be object"})
+			continue
+		}
+		for _, f := range v7Fields {
+			if !strings.HasPrefix(f.Path, "/bot/") && !strings.HasPrefix(f.Path, "/live/") {
+				continue
+			}
+			v, ok := lookup(override, f.Path)
+			if !ok {
+				continue
+			}
+			if msg := f.check(v); msg != "" {
+				errs = append(errs, FieldError{Path: prefix + f.Path, Message: msg})
+			}
+		}
+	}
+	return errs
+}
+
+func v7AnySideEnabled(doc map[string]interface{}) []FieldError {
+	for _, side := range []string{"long", "short"} {
+		if number(doc, "/bot/"+side+"/n_positions") > 0 && number(doc, "/bot/"+side+"/total_wallet_exposure_limit") > 0 {
+			return nil
+		}
+	}
+	return []FieldError{{Path: "/bot", Message: "neither long nor short is enabled (n_positions and total_wallet_exposure_limit)"}}
+}
+
+func v7BacktestRange(doc map[string]interface{}) []FieldError {
+	start, _ := lookup(doc, "/backtest/start_date")
+	end, _ := lookup(doc, "/backtest/end_date")
+	s, _ := start.(string)
+	e, _ := end.(string)
+	// end_date may also be "now"
+	if s == "" || !hasType(e, TypeDate) || s < e {
+		return nil
+	}
+	return []FieldError{{Path: "/backtest/end_date", Message: "must be after start_date"}}
+}
+
+func legacySideExposure(doc map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, side := range []string{"long", "short"} {
+		enabled, _ := lookup(doc, "/"+side+"/enabled")
+		if enabled != true {
+			continue
+		}
+		if _, ok := lookup(doc, "/"+side+"/wallet_exposure_limit"); ok && number(doc, "/"+side+"/wallet_exposure_limit") == 0 {
+			errs = append(errs, FieldError{Path: "/" + side + "/wallet_exposure_limit", Message: "must be greater than 0 when the side is enabled"})
+		}
+	}
+	return errs
+}
+
+func legacyAnySideEnabled(doc map[string]interface{}) []FieldError {
+	sections := 0
+	for _, side := range []string{"long", "short"} {
+		enabled, ok := lookup(doc, "/"+side+"/enabled")
+		if !ok {
+			continue
+		}
+		sections++
+		if enabled == true {
+			return nil
+		}
+	}
+	if sections < 2 {
+		return nil
+	}
+	return []FieldError{{Path: "", Message: "neither long nor short is enabled"}}
+}
+
+func prefixed(prefix string, fields []Field) []Field {
+	out := make([]Field, len(fields))
+	for i, f := range fields {
+		f.Path = prefix + f.Path
+		out[i] = f
+	}
+	return out
+}
+
+func concat(groups ...[]Field) []Field {
+	var out []Field
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
@@ -0,0 +1,369 @@
+package pbconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"pbgui-backend/internal/models"
+)
+
+// Value types a schema field can require. A field may allow several,
+// separated by "|".
+const (
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeString  = "string"
+	TypeDate    = "date" // string in YYYY-MM-DD form
+	TypeArray   = "array"
+	TypeObject  = "object"
+)
+
+// Field describes one config value. Path is a JSON Pointer.
+type Field struct {
+	Path         string   `json:"path"`
+	Type         string   `json:"type"`
+	Required     bool     `json:"required,omitempty"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	MinExclusive bool     `json:"min_exclusive,omitempty"`
+	Enum         []string `json:"enum,omitempty"`
+}
+
+// Rule checks a relationship between fields that per-field checks cannot
+type Rule struct {
+	Description string `json:"description"`
+	check       func(doc map[string]interface{}) []FieldError
+}
+
+// Schema is one version of the passivbot config format
+type Schema struct {
+	Version     string  `json:"version"`
+	Description string  `json:"description"`
+	Fields      []Field `json:"fields"`
+	Rules       []Rule  `json:"rules"`
+}
+
+// FieldError is a problem with the value at Path, a JSON Pointer
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a config. Version is empty
+// if the config could not be parsed at all.
+type ValidationError struct {
+	Version string       `json:"schema_version"`
+	Errors  []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	first := e.Errors[0]
+	msg := fmt.Sprintf("invalid config: %s", first.Message)
+	if e.Version != "" {
+		msg = fmt.Sprintf("config does not match schema %s: %s: %s", e.Version, pathOrRoot(first.Path), first.Message)
+	}
+	if n := len(e.Errors) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// Validate checks a JSON instance config against the schema version it is
+// written in. An empty config is valid; the runner renders a default.
+func Validate(config string) error {
+	if strings.TrimSpace(config) == "" {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(config), &v); err != nil {
+		return &ValidationError{Errors: []FieldError{{Message: fmt.Sprintf("invalid JSON: %v", err)}}}
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return &ValidationError{Errors: []FieldError{{Message: "config must be a JSON object"}}}
+	}
+	return result(Detect(doc), doc, "", nil)
+}
+
+// ValidateBacktest checks backtest parameters: the date range and, when
+// given, the strategy parameters as a config of their detected version
+func ValidateBacktest(params models.BacktestParams) error {
+	var errs []FieldError
+	start := checkDate("/start_date", params.StartDate, &errs)
+	end := checkDate("/end_date", params.EndDate, &errs)
+	if start != nil && end != nil && !start.Before(*end) {
+		errs = append(errs, FieldError{Path: "/end_date", Message: "must be after start_date"})
+	}
+
+	schema := legacySchema
+	if len(params.Parameters) > 0 {
+		schema = Detect(params.Parameters)
+	}
+	return result(schema, params.Parameters, "/parameters", errs)
+}
+
+// Detect picks the schema a config is written in. passivbot v7 nests
+// settings under bot, live, backtest and optimize; older releases are flat.
+func Detect(doc map[string]interface{}) *Schema {
+	for _, key := range []string{"bot", "live", "backtest", "optimize"} {
+		if _, ok := doc[key]; ok {
+			return v7Schema
+		}
+	}
+	return legacySchema
+}
+
+// Schemas lists every supported schema version, newest first
+func Schemas() []*Schema {
+	return []*Schema{v7Schema, legacySchema}
+}
+
+// result validates doc against schema and wraps any errors, with paths
+// under prefix, into a ValidationError
+func result(schema *Schema, doc map[string]interface{}, prefix string, errs []FieldError) error {
+	if doc != nil {
+		for _, e := range schema.validate(doc) {
+			e.Path = prefix + e.Path
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Version: schema.Version, Errors: errs}
+}
+
+func (s *Schema) validate(doc map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, f := range s.Fields {
+		v, ok := lookup(doc, f.Path)
+		if !ok {
+			// A missing section is reported once, not for each of its keys
+			if f.Required && hasParent(doc, f.Path) {
+				errs = append(errs, FieldError{Path: f.Path, Message: "is required"})
+			}
+			continue
+		}
+		if msg := f.check(v); msg != "" {
+			errs = append(errs, FieldError{Path: f.Path, Message: msg})
+		}
+	}
+	errs = append(errs, s.misspellings(doc)...)
+
+	// Cross-field rules assume well-typed values, so only run them on an
+	// otherwise valid config
+	if len(errs) == 0 {
+		for _, r := range s.Rules {
+			errs = append(errs, r.check(doc)...)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+func (f Field) check(v interface{}) string {
+	if v == nil {
+		if f.Required {
+			return "must not be null"
+		}
+		return ""
+	}
+
+	matched := false
+	for _, t := range strings.Split(f.Type, "|") {
+		if hasType(v, t) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Sprintf("must be %s", strings.ReplaceAll(f.Type, "|", " or "))
+	}
+
+	if n, ok := v.(float64); ok {
+		switch {
+		case f.Min != nil && f.MinExclusive && n <= *f.Min:
+			return fmt.Sprintf("must be greater than %v", *f.Min)
+		case f.Min != nil && n < *f.Min:
+			return fmt.Sprintf("must be at least %v", *f.Min)
+		case f.Max != nil && n > *f.Max:
+			return fmt.Sprintf("must be at most %v", *f.Max)
+		}
+	}
+	if s, ok := v.(string); ok && len(f.Enum) > 0 && !contains(f.Enum, s) {
+		return fmt.Sprintf("must be one of %s", strings.Join(f.Enum, ", "))
+	}
+	return ""
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case TypeNumber:
+		_, ok := v.(float64)
+		return ok
+	case TypeInteger:
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case TypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case TypeString:
+		_, ok := v.(string)
+		return ok
+	case TypeDate:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case TypeArray:
+		_, ok := v.([]interface{})
+		return ok
+	case TypeObject:
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+// misspellings reports keys that are not in the schema but are one or two
+// edits away from a key that is, like "wallet_exposure_limt". Other unknown
+// keys are allowed, since passivbot adds settings between releases.
+func (s *Schema) misspellings(doc map[string]interface{}) []FieldError {
+	known := map[string][]string{} // parent path -> known child keys
+	for _, f := range s.Fields {
+		i := strings.LastIndex(f.Path, "/")
+		known[f.Path[:i]] = append(known[f.Path[:i]], f.Path[i+1:])
+	}
+
+	var errs []FieldError
+	for parent, keys := range known {
+		v, ok := doc, true
+		if parent != "" {
+			node, _ := lookup(doc, parent)
+			v, ok = node.(map[string]interface{})
+		}
+		if !ok {
+			continue
+		}
+		for key := range v {
+			if contains(keys, key) || len(key) < 6 {
+				continue
+			}
+			if guess := closest(key, keys); guess != "" {
+				errs = append(errs, FieldError{
+					Path:    parent + "/" + escapePointer(key),
+					Message: fmt.Sprintf("unknown setting; did you mean %q?", guess),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// closest returns the key within two edits of name, if any
+func closest(name string, keys []string) string {
+	best, bestDist := "", 3
+	for _, k := range keys {
+		if d := editDistance(name, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// lookup resolves a JSON Pointer in doc
+func lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, key := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		key = strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func hasParent(doc map[string]interface{}, path string) bool {
+	parent := path[:strings.LastIndex(path, "/")]
+	if parent == "" {
+		return true
+	}
+	v, ok := lookup(doc, parent)
+	return ok && hasType(v, TypeObject)
+}
+
+// number returns the value at path, or 0 if it is missing or not a number
+func number(doc map[string]interface{}, path string) float64 {
+	v, _ := lookup(doc, path)
+	n, _ := v.(float64)
+	return n
+}
+
+func checkDate(path, value string, errs *[]FieldError) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		*errs = append(*errs, FieldError{Path: path, Message: "must be a date in YYYY-MM-DD form"})
+		return nil
+	}
+	return &t
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,166 @@
+package pbconfig
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"pbgui-backend/internal/models"
+)
+
+// v7Config is a minimal valid passivbot 7 config
+const v7Config = `{
+	"bot": {
+		"long": {"n_positions": 1, "total_wallet_exposure_limit": 1},
+		"short": {"n_positions": 0, "total_wallet_exposure_limit": 0}
+	},
+	"live": {"leverage": 5}
+}`
+
+// v7With returns v7Config with a merge patch applied
+func v7With(t *testing.T, patch string) string {
+	t.Helper()
+	out, err := MergePatch(v7Config, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// errorPaths returns the schema version and field paths of a validation
+// error, or "" and nil for no error
+func errorPaths(t *testing.T, err error) (string, []string) {
+	t.Helper()
+	if err == nil {
+		return "", nil
+	}
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error %v is not a ValidationError", err)
+	}
+	var paths []string
+	for _, e := range invalid.Errors {
+		paths = append(paths, e.Path)
+	}
+	return invalid.Version, paths
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantVersion string
+		wantPaths   []string
+	}{
+		{"empty", "", "", nil},
+		{"invalid JSON", `{"bot":`, "", []string{""}},
+		{"not an object", `[1, 2]`, "", []string{""}},
+
+		{"valid v7", v7Config, "", nil},
+		{"missing section", v7With(t, `{"bot":{"short":null}}`), VersionV7, []string{"/bot/short"}},
+		{"missing bot reported once", `{"live":{"leverage":5}}`, VersionV7, []string{"/bot"}},
+		{"required value null", v7With(t, `{"bot":{"long":{"n_positions":null}}}`), VersionV7, []string{"/bot/long/n_positions"}},
+		{"wrong type", v7With(t, `{"bot":{"long":{"n_positions":1.5}}}`), VersionV7, []string{"/bot/long/n_positions"}},
+		{"above maximum", v7With(t, `{"live":{"leverage":200}}`), VersionV7, []string{"/live/leverage"}},
+		{"exclusive minimum", v7With(t, `{"live":{"leverage":0}}`), VersionV7, []string{"/live/leverage"}},
+		{"enum", v7With(t, `{"live":{"time_in_force":"ioc"}}`), VersionV7, []string{"/live/time_in_force"}},
+		{"several errors sorted", v7With(t, `{"live":{"leverage":-1},"bot":{"long":{"ema_span_0":0}}}`), VersionV7,
+			[]string{"/bot/long/ema_span_0", "/live/leverage"}},
+		{"misspelled key", v7With(t, `{"bot":{"long":{"total_wallet_exposure_limt":1}}}`), VersionV7,
+			[]string{"/bot/long/total_wallet_exposure_limt"}},
+		{"unknown keys allowed", v7With(t, `{"live":{"some_new_setting":1,"abc":2}}`), "", nil},
+		{"side with positions but no exposure", v7With(t, `{"bot":{"short":{"n_positions":2}}}`), VersionV7,
+			[]string{"/bot/short/total_wallet_exposure_limit"}},
+		{"no side enabled", v7With(t, `{"bot":{"long":{"n_positions":0,"total_wallet_exposure_limit":0}}}`), VersionV7,
+			[]string{"/bot"}},
+		{"backtest range", v7With(t, `{"backtest":{"start_date":"2024-02-01","end_date":"2024-01-01"}}`), VersionV7,
+			[]string{"/backtest/end_date"}},
+		{"backtest until now", v7With(t, `{"backtest":{"start_date":"2024-02-01","end_date":"now"}}`), "", nil},
+		{"invalid date", v7With(t, `{"backtest":{"start_date":"01/02/2024"}}`), VersionV7, []string{"/backtest/start_date"}},
+		{"coin override", v7With(t, `{"coin_overrides":{"BTC":{"bot":{"long":{"n_positions":-1}}}}}`), VersionV7,
+			[]string{"/coin_overrides/BTC/bot/long/n_positions"}},
+		{"coin override not an object", v7With(t, `{"coin_overrides":{"BTC":1}}`), VersionV7, []string{"/coin_overrides/BTC"}},
+
+		{"valid legacy", `{"symbol":"BTCUSDT","long":{"enabled":true,"wallet_exposure_limit":0.5}}`, "", nil},
+		{"legacy without sides", `{"exchange":"binance","leverage":5}`, "", nil},
+		{"legacy enabled without exposure", `{"long":{"enabled":true,"wallet_exposure_limit":0},"short":{"enabled":true,"wallet_exposure_limit":1}}`,
+			VersionLegacy, []string{"/long/wallet_exposure_limit"}},
+		{"legacy both sides disabled", `{"long":{"enabled":false},"short":{"enabled":false}}`, VersionLegacy, []string{""}},
+		{"legacy wrong type", `{"long":{"enabled":"yes"}}`, VersionLegacy, []string{"/long/enabled"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, paths := errorPaths(t, Validate(tt.config))
+			if version != tt.wantVersion || !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("Validate = %q %q, want %q %q", version, paths, tt.wantVersion, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *ValidationError
+		want string
+	}{
+		{"unparsed", &ValidationError{Errors: []FieldError{{Message: "config must be a JSON object"}}},
+			"invalid config: config must be a JSON object"},
+		{"one field", &ValidationError{Version: VersionV7, Errors: []FieldError{{Path: "/live/leverage", Message: "must be at most 125"}}},
+			"config does not match schema v7: /live/leverage: must be at most 125"},
+		{"more fields", &ValidationError{Version: VersionLegacy, Errors: []FieldError{{Message: "neither long nor short is enabled"}, {}, {}}},
+			"config does not match schema legacy: /: neither long nor short is enabled (and 2 more)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBacktest(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      models.BacktestParams
+		wantVersion string
+		wantPaths   []string
+	}{
+		{"dates only", models.BacktestParams{StartDate: "2024-01-01", EndDate: "2024-02-01"}, "", nil},
+		{"no dates", models.BacktestParams{}, "", nil},
+		{"invalid date", models.BacktestParams{StartDate: "2024-13-01"}, VersionLegacy, []string{"/start_date"}},
+		{"end before start", models.BacktestParams{StartDate: "2024-02-01", EndDate: "2024-01-01"}, VersionLegacy, []string{"/end_date"}},
+		{"v7 parameters", models.BacktestParams{Parameters: map[string]interface{}{
+			"bot": map[string]interface{}{"long": map[string]interface{}{}},
+		}}, VersionV7, []string{"/parameters/bot/long/n_positions", "/parameters/bot/long/total_wallet_exposure_limit", "/parameters/bot/short"}},
+		{"legacy parameters", models.BacktestParams{Parameters: map[string]interface{}{"leverage": -1.0}}, VersionLegacy, []string{"/parameters/leverage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, paths := errorPaths(t, ValidateBacktest(tt.params))
+			if version != tt.wantVersion || !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("ValidateBacktest = %q %q, want %q %q", version, paths, tt.wantVersion, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "abd", 1},
+		{"leverage", "levrage", 1},
+		{"n_positions", "n_positons", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package secrets encrypts credentials stored in the database with a
+// master key from the server configuration.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of the master key in bytes, an AES-256 key
+const KeySize = 32
+
+// Prefix of sealed values, so the format can change later
+const sealedPrefix = "v1:"
+
+var ErrNotConfigured = errors.New("MASTER_KEY is not configured")
+
+// Box seals and opens values with AES-256-GCM
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox uses masterKey, 32 random bytes encoded as base64 or hex, as the
+// encryption key. Passphrases are rejected rather than stretched, since
+// there is nowhere to keep a salt that is not next to the secrets.
+func NewBox(masterKey string) (*Box, error) {
+	key, err := DecodeKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// DecodeKey decodes a master key given as hex or base64 (standard or URL
+// alphabet, padded or not), which must hold exactly KeySize bytes
+func DecodeKey(masterKey string) ([]byte, error) {
+	s := strings.TrimSpace(masterKey)
+	decoders := []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	}
+	for _, decode := range decoders {
+		if key, err := decode(s); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("master key must be %d random bytes encoded as base64 or hex, e.g. from `openssl rand -base64 %d`", KeySize, KeySize)
+}
+
+// Seal encrypts plaintext. context, e.g. the owning record's ID and field,
+// is authenticated with it, so a sealed value cannot be moved to another
+// record. An empty plaintext seals to an empty string.
+func (b *Box) Seal(plaintext, context string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with the same context
+func (b *Box) Open(sealed, context string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return "", errors.New("unknown secret format")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("corrupt secret: %w", err)
+	}
+	n := b.aead.NonceSize()
+	if len(data) < n {
+		return "", errors.New("corrupt secret")
+	}
+	plaintext, err := b.aead.Open(nil, data[:n], data[n:], []byte(context))
+	if err != nil {
+		return "", errors.New("cannot decrypt secret; was MASTER_KEY changed?")
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, KeySize)
+
+func newTestBox(t *testing.T, key []byte) *Box {
+	t.Helper()
+	box, err := NewBox(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestDecodeKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"hex", hex.EncodeToString(testKey), false},
+		{"base64", base64.StdEncoding.EncodeToString(testKey), false},
+		{"base64 without padding", base64.RawStdEncoding.EncodeToString(testKey), false},
+		{"base64 url alphabet", base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{0xfb}, KeySize)), false},
+		{"surrounding whitespace", " " + base64.StdEncoding.EncodeToString(testKey) + "\n", false},
+		{"empty", "", true},
+		{"passphrase", "correct horse battery staple", true},
+		{"too short", base64.StdEncoding.EncodeToString(testKey[:16]), true},
+		{"too long", hex.EncodeToString(append(testKey, 0)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := DecodeKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(key) != KeySize {
+				t.Errorf("key has %d bytes, want %d", len(key), KeySize)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	box := newTestBox(t, testKey)
+	tests := []struct {
+		name      string
+		plaintext string
+		context   string
+	}{
+		{"api key", "abcdef123456", "account-1/api_key"},
+		{"unicode", "pässwörd ✓", "account-1/secret"},
+		{"no context", "secret", ""},
+		{"long", strings.Repeat("x", 4096), "account-1/secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := box.Seal(tt.plaintext, tt.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(sealed, sealedPrefix) {
+				t.Errorf("sealed value %q lacks the %q prefix", sealed, sealedPrefix)
+			}
+			if strings.Contains(sealed, tt.plaintext) {
+				t.Error("sealed value contains the plaintext")
+			}
+			got, err := box.Open(sealed, tt.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("Open = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	box := newTestBox(t, testKey)
+	a, _ := box.Seal("secret", "ctx")
+	b, _ := box.Seal("secret", "ctx")
+	if a == b {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+}
+
+func TestSealEmpty(t *testing.T) {
+	box := newTestBox(t, testKey)
+	sealed, err := box.Seal("", "ctx")
+	if err != nil || sealed != "" {
+		t.Errorf("Seal(\"\") = %q, %v; want empty", sealed, err)
+	}
+	opened, err := box.Open("", "ctx")
+	if err != nil || opened != "" {
+		t.Errorf("Open(\"\") = %q, %v; want empty", opened, err)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	box := newTestBox(t, testKey)
+	sealed, err := box.Seal("secret", "account-1/api_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	raw[len(raw)-1] ^= 1
+	tampered := sealedPrefix + base64.StdEncoding.EncodeToString(raw)
+
+	tests := []struct {
+		name    string
+		box     *Box
+		sealed  string
+		context string
+	}{
+		{"other context", box, sealed, "account-2/api_key"},
+		{"other key", newTestBox(t, bytes.Repeat([]byte{0x17}, KeySize)), sealed, "account-1/api_key"},
+		{"tampered", box, tampered, "account-1/api_key"},
+		{"unknown format", box, "v0:" + strings.TrimPrefix(sealed, sealedPrefix), "account-1/api_key"},
+		{"plaintext", box, "secret", "account-1/api_key"},
+		{"invalid base64", box, sealedPrefix + "!!!", "account-1/api_key"},
+		{"too short", box, sealedPrefix + base64.StdEncoding.EncodeToString([]byte("abc")), "account-1/api_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.box.Open(tt.sealed, tt.context); err == nil {
+				t.Errorf("Open = %q, want an error", got)
+			}
+		})
+	}
+}
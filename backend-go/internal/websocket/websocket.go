@@ -1,7 +1,6 @@
 package websocket
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 	"time"
@@ -10,6 +9,7 @@ import (
 	"github.com/gorilla/websocket"
 
 	"pbgui-backend/internal/api/handlers"
+	"pbgui-backend/internal/services/passivbot"
 )
 
 var upgrader = websocket.Upgrader{
@@ -23,7 +23,7 @@ var upgrader = websocket.Upgrader{
 func HandleLogStream(h *handlers.Handlers) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instanceID := c.Param("id")
-		
+
 		// Upgrade HTTP connection to WebSocket
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
@@ -32,78 +32,84 @@ func HandleLogStream(h *handlers.Handlers) gin.HandlerFunc {
 		}
 		defer conn.Close()
 
-		// Create channels for communication
-		logChan := make(chan string, 100)
-		done := make(chan bool)
-
-		// Start log generation goroutine
-		go func() {
-			defer close(logChan)
-			ticker := time.NewTicker(500 * time.Millisecond)
-			defer ticker.Stop()
-
-			logCount := 0
-			for {
-				select {
-				case <-done:
-					return
-				case <-ticker.C:
-					logCount++
-					timestamp := time.Now().Format("15:04:05")
-					
-					logMessage := map[string]interface{}{
-						"timestamp":   timestamp,
-						"instance_id": instanceID,
-						"level":       "INFO",
-						"message":     generateLogMessage(logCount),
-					}
+		// Subscribe to the instance's live output, replaying from ?since=<seq>
+		// and filtered by the level, symbol and event query params
+		filter, err := handlers.ParseLogFilter(c)
+		if err != nil {
+			conn.WriteJSON(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		sub, backlog, err := h.SubscribeLogs(instanceID, c.Query("since"))
+		if err != nil {
+			conn.WriteJSON(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		defer sub.Close()
 
-					messageBytes, _ := json.Marshal(logMessage)
-					select {
-					case logChan <- string(messageBytes):
-					case <-done:
-						return
-					}
-				}
+		var lastSeq uint64
+		for _, line := range backlog {
+			lastSeq = line.Seq
+			if !filter.Matches(line) {
+				continue
+			}
+			if err := conn.WriteJSON(handlers.LogMessage(instanceID, line)); err != nil {
+				log.Printf("Failed to write message: %v", err)
+				return
 			}
-		}()
+		}
 
-		// Handle WebSocket communication
+		closed := watchClose(conn)
 		for {
 			select {
-			case logMessage, ok := <-logChan:
+			case line, ok := <-sub.C:
 				if !ok {
+					// The hub disconnected us for falling too far behind
+					msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow")
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
 					return
 				}
-				
+				if line.Seq <= lastSeq || !filter.Matches(line) {
+					// Already sent as part of the replay, or filtered out
+					continue
+				}
+
 				// Send log message to client
-				if err := conn.WriteMessage(websocket.TextMessage, []byte(logMessage)); err != nil {
+				if err := conn.WriteJSON(handlers.LogMessage(instanceID, line)); err != nil {
 					log.Printf("Failed to write message: %v", err)
-					close(done)
 					return
 				}
 
-			default:
-				// Check for client disconnect
-				conn.SetReadDeadline(time.Now().Add(time.Second))
-				_, _, err := conn.ReadMessage()
-				if err != nil {
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-						log.Printf("WebSocket error: %v", err)
-					}
-					close(done)
-					return
-				}
+			case <-closed:
+				return
 			}
 		}
 	}
 }
 
-// HandleJobProgress handles WebSocket connections for job progress updates
-func HandleJobProgress(h *handlers.Handlers) gin.HandlerFunc {
+// watchClose reads from the connection until the client goes away and then
+// closes the returned channel. Log streams never expect client messages.
+func watchClose(conn *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					log.Printf("WebSocket error: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return closed
+}
+
+// HandleOperationProgress streams snapshots of a bulk operation until it
+// completes, starting with its current state
+func HandleOperationProgress(h *handlers.Handlers) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		jobID := c.Param("id")
-		
+		operationID := c.Param("id")
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			log.Printf("Failed to upgrade connection: %v", err)
@@ -111,52 +117,85 @@ func HandleJobProgress(h *handlers.Handlers) gin.HandlerFunc {
 		}
 		defer conn.Close()
 
-		// Send periodic job progress updates
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
+		op, ok := h.PBRunner.Operation(operationID)
+		if !ok {
+			conn.WriteJSON(map[string]interface{}{"error": "Operation not found", "operation_id": operationID})
+			return
+		}
+		snap, updates, cancel := op.Watch()
+		defer cancel()
 
+		if err := conn.WriteJSON(snap); err != nil {
+			log.Printf("Failed to write JSON: %v", err)
+			return
+		}
+
+		closed := watchClose(conn)
 		for {
 			select {
-			case <-ticker.C:
-				// Get job from database
-				var job struct {
-					ID       string `json:"id"`
-					Status   string `json:"status"`
-					Progress int    `json:"progress"`
-				}
-				
-				// Mock job progress
-				if err := h.DB.Raw("SELECT id, status, progress FROM jobs WHERE id = ?", jobID).Scan(&job).Error; err != nil {
-					// Job not found, send error and close
-					errorMsg := map[string]interface{}{
-						"error": "Job not found",
-						"job_id": jobID,
-					}
-					conn.WriteJSON(errorMsg)
+			case snap, ok := <-updates:
+				if !ok {
+					// Completed; snapshots may have been skipped, so send the final state
+					conn.WriteJSON(op.Snapshot())
+					msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "operation completed")
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
 					return
 				}
-
-				// Send progress update
-				if err := conn.WriteJSON(job); err != nil {
+				if err := conn.WriteJSON(snap); err != nil {
 					log.Printf("Failed to write JSON: %v", err)
 					return
 				}
 
-				// If job is completed or failed, close connection
-				if job.Status == "completed" || job.Status == "failed" || job.Status == "cancelled" {
+			case <-closed:
+				return
+			}
+		}
+	}
+}
+
+// HandleJobProgress streams a job's progress, starting with its current
+// state, until it ends. Updates are pushed by the job queue as the job
+// reports them.
+func HandleJobProgress(h *handlers.Handlers) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobID := c.Param("id")
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Printf("Failed to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		snap, updates, cancel, err := h.Jobs.Watch(jobID)
+		if err != nil {
+			conn.WriteJSON(map[string]interface{}{"error": "Job not found", "job_id": jobID})
+			return
+		}
+		defer cancel()
+
+		if err := conn.WriteJSON(snap); err != nil {
+			log.Printf("Failed to write JSON: %v", err)
+			return
+		}
+
+		closed := watchClose(conn)
+		for {
+			select {
+			case p, ok := <-updates:
+				if !ok {
+					// The job ended; its final state was the last update
+					msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "job finished")
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
 					return
 				}
-
-			default:
-				// Check for client disconnect
-				conn.SetReadDeadline(time.Now().Add(time.Second))
-				_, _, err := conn.ReadMessage()
-				if err != nil {
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-						log.Printf("WebSocket error: %v", err)
-					}
+				if err := conn.WriteJSON(p); err != nil {
+					log.Printf("Failed to write JSON: %v", err)
 					return
 				}
+
+			case <-closed:
+				return
 			}
 		}
 	}
@@ -178,14 +217,16 @@ func HandleDashboardMetrics(h *handlers.Handlers) gin.HandlerFunc {
 		for {
 			select {
 			case <-ticker.C:
-				// Get live dashboard metrics
+				// Get live dashboard metrics; resource use is summed over running instances
+				cpuUsage, memoryUsage := h.PBRunner.TotalUsage()
 				metrics := map[string]interface{}{
-					"timestamp":        time.Now(),
-					"total_pnl":        1250.75 + float64(time.Now().Second())*0.1,
-					"active_instances": 5,
-					"running_jobs":     2,
-					"cpu_usage":        45.2 + float64(time.Now().Second()%10),
-					"memory_usage":     67.8 + float64(time.Now().Second()%5),
+					"timestamp":          time.Now(),
+					"total_pnl":          1250.75 + float64(time.Now().Second())*0.1,
+					"active_instances":   5,
+					"running_jobs":       2,
+					"cpu_usage":          cpuUsage,
+					"memory_usage":       passivbot.MemoryPercent(memoryUsage),
+					"memory_usage_bytes": memoryUsage,
 				}
 
 				if err := conn.WriteJSON(metrics); err != nil {
@@ -207,18 +248,3 @@ func HandleDashboardMetrics(h *handlers.Handlers) gin.HandlerFunc {
 		}
 	}
 }
-
-func generateLogMessage(count int) string {
-	messages := []string{
-		"Market data received successfully",
-		"Position check completed",
-		"Order book updated",
-		"Grid levels calculated",
-		"Risk parameters validated",
-		"Exchange connection stable",
-		"PnL calculation updated",
-		"Strategy signals processed",
-	}
-	
-	return messages[count%len(messages)]
-}
@@ -13,6 +13,32 @@ type Config struct {
 	RedisURL      string
 	LogLevel      string
 	Environment   string
+	DataDir       string
+	WorkspaceDir  string // rendered configs and API keys of instances and jobs
+
+	// Encrypts exchange credentials at rest; accounts are unavailable
+	// without it
+	MasterKey string
+
+	// Process control
+	StopGraceSeconds int // wait after SIGINT before escalating to SIGTERM
+	BulkWorkers      int // concurrent instance actions in bulk operations
+	CgroupRoot       string
+
+	// Concurrent jobs per type; further jobs wait in the queue
+	BacktestConcurrency int
+	OptimizeConcurrency int
+
+	// Reconciliation of instance state with running processes
+	ReconcileIntervalSeconds int
+	ReconcileRestart         bool
+
+	// Instance log capture and rotation
+	LogsDir        string
+	LogMaxSizeMB   int
+	LogRotateHours int
+	LogMaxBackups  int
+	LogMaxAgeDays  int
 }
 
 func Load() *Config {
@@ -24,6 +50,25 @@ func Load() *Config {
 		RedisURL:      getEnv("REDIS_URL", "redis://localhost:6379"),
 		LogLevel:      getEnv("LOG_LEVEL", "info"),
 		Environment:   getEnv("ENVIRONMENT", "development"),
+		DataDir:       getEnv("DATA_DIR", "/opt/pbgui/data"),
+		WorkspaceDir:  getEnv("WORKSPACE_DIR", "/opt/pbgui/workspace"),
+		MasterKey:     getEnv("MASTER_KEY", ""),
+
+		StopGraceSeconds: getEnvAsInt("STOP_GRACE_SECONDS", 10),
+		BulkWorkers:      getEnvAsInt("BULK_WORKERS", 4),
+		CgroupRoot:       getEnv("CGROUP_ROOT", "/sys/fs/cgroup/pbgui"),
+
+		BacktestConcurrency: getEnvAsInt("BACKTEST_CONCURRENCY", 2),
+		OptimizeConcurrency: getEnvAsInt("OPTIMIZE_CONCURRENCY", 1),
+
+		ReconcileIntervalSeconds: getEnvAsInt("RECONCILE_INTERVAL_SECONDS", 30),
+		ReconcileRestart:         getEnvAsBool("RECONCILE_RESTART", false),
+
+		LogsDir:        getEnv("LOGS_DIR", "/opt/pbgui/logs"),
+		LogMaxSizeMB:   getEnvAsInt("LOG_MAX_SIZE_MB", 10),
+		LogRotateHours: getEnvAsInt("LOG_ROTATE_HOURS", 24),
+		LogMaxBackups:  getEnvAsInt("LOG_MAX_BACKUPS", 10),
+		LogMaxAgeDays:  getEnvAsInt("LOG_MAX_AGE_DAYS", 14),
 	}
 }
 